@@ -0,0 +1,114 @@
+package builder
+
+import (
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"time"
+)
+
+// Column identifies a column of a table. It's embedded in the typed columns
+// below, which are used by generated code to build type safe predicates.
+type Column struct {
+	Table  string
+	Column string
+}
+
+// Expr returns the column as an expression.
+func (c Column) Expr() *pb.Expr {
+	return TableCol(c.Table, c.Column)
+}
+
+// IsNull returns an expression which is true if the column is null.
+func (c Column) IsNull() *pb.Expr {
+	return Is(c.Expr(), Null())
+}
+
+// IsNotNull returns an expression which is true if the column is not null.
+func (c Column) IsNotNull() *pb.Expr {
+	return IsNot(c.Expr(), Null())
+}
+
+// StringColumn is a column holding text.
+type StringColumn struct {
+	Column
+}
+
+func (c StringColumn) Eq(s string) *pb.Expr  { return Eq(c.Expr(), Str(s)) }
+func (c StringColumn) NEq(s string) *pb.Expr { return NEq(c.Expr(), Str(s)) }
+func (c StringColumn) GT(s string) *pb.Expr  { return GT(c.Expr(), Str(s)) }
+func (c StringColumn) GTE(s string) *pb.Expr { return GTE(c.Expr(), Str(s)) }
+func (c StringColumn) LT(s string) *pb.Expr  { return LT(c.Expr(), Str(s)) }
+func (c StringColumn) LTE(s string) *pb.Expr { return LTE(c.Expr(), Str(s)) }
+
+// NumColumn is a column holding a floating point number.
+type NumColumn struct {
+	Column
+}
+
+func (c NumColumn) Eq(n float64) *pb.Expr  { return Eq(c.Expr(), Num(n)) }
+func (c NumColumn) NEq(n float64) *pb.Expr { return NEq(c.Expr(), Num(n)) }
+func (c NumColumn) GT(n float64) *pb.Expr  { return GT(c.Expr(), Num(n)) }
+func (c NumColumn) GTE(n float64) *pb.Expr { return GTE(c.Expr(), Num(n)) }
+func (c NumColumn) LT(n float64) *pb.Expr  { return LT(c.Expr(), Num(n)) }
+func (c NumColumn) LTE(n float64) *pb.Expr { return LTE(c.Expr(), Num(n)) }
+
+// IntColumn is a column holding an integer.
+type IntColumn struct {
+	Column
+}
+
+func (c IntColumn) Eq(i int64) *pb.Expr  { return Eq(c.Expr(), Int(i)) }
+func (c IntColumn) NEq(i int64) *pb.Expr { return NEq(c.Expr(), Int(i)) }
+func (c IntColumn) GT(i int64) *pb.Expr  { return GT(c.Expr(), Int(i)) }
+func (c IntColumn) GTE(i int64) *pb.Expr { return GTE(c.Expr(), Int(i)) }
+func (c IntColumn) LT(i int64) *pb.Expr  { return LT(c.Expr(), Int(i)) }
+func (c IntColumn) LTE(i int64) *pb.Expr { return LTE(c.Expr(), Int(i)) }
+
+// DecimalColumn is a column holding an exact number, such as a numeric. Values
+// are given in their textual form, as for Decimal.
+type DecimalColumn struct {
+	Column
+}
+
+func (c DecimalColumn) Eq(d string) *pb.Expr  { return Eq(c.Expr(), Decimal(d)) }
+func (c DecimalColumn) NEq(d string) *pb.Expr { return NEq(c.Expr(), Decimal(d)) }
+func (c DecimalColumn) GT(d string) *pb.Expr  { return GT(c.Expr(), Decimal(d)) }
+func (c DecimalColumn) GTE(d string) *pb.Expr { return GTE(c.Expr(), Decimal(d)) }
+func (c DecimalColumn) LT(d string) *pb.Expr  { return LT(c.Expr(), Decimal(d)) }
+func (c DecimalColumn) LTE(d string) *pb.Expr { return LTE(c.Expr(), Decimal(d)) }
+
+// BoolColumn is a column holding a boolean.
+type BoolColumn struct {
+	Column
+}
+
+func (c BoolColumn) Eq(b bool) *pb.Expr  { return Eq(c.Expr(), Bool(b)) }
+func (c BoolColumn) NEq(b bool) *pb.Expr { return NEq(c.Expr(), Bool(b)) }
+
+// TimeColumn is a column holding a timestamp.
+type TimeColumn struct {
+	Column
+}
+
+func (c TimeColumn) Eq(t time.Time) *pb.Expr  { return Eq(c.Expr(), Time(t)) }
+func (c TimeColumn) NEq(t time.Time) *pb.Expr { return NEq(c.Expr(), Time(t)) }
+func (c TimeColumn) GT(t time.Time) *pb.Expr  { return GT(c.Expr(), Time(t)) }
+func (c TimeColumn) GTE(t time.Time) *pb.Expr { return GTE(c.Expr(), Time(t)) }
+func (c TimeColumn) LT(t time.Time) *pb.Expr  { return LT(c.Expr(), Time(t)) }
+func (c TimeColumn) LTE(t time.Time) *pb.Expr { return LTE(c.Expr(), Time(t)) }
+
+// DateColumn is a column holding a date. Only the date of the times given is
+// used, in their own location.
+type DateColumn struct {
+	Column
+}
+
+func date(t time.Time) *pb.Expr {
+	return Date(t.Date())
+}
+
+func (c DateColumn) Eq(t time.Time) *pb.Expr  { return Eq(c.Expr(), date(t)) }
+func (c DateColumn) NEq(t time.Time) *pb.Expr { return NEq(c.Expr(), date(t)) }
+func (c DateColumn) GT(t time.Time) *pb.Expr  { return GT(c.Expr(), date(t)) }
+func (c DateColumn) GTE(t time.Time) *pb.Expr { return GTE(c.Expr(), date(t)) }
+func (c DateColumn) LT(t time.Time) *pb.Expr  { return LT(c.Expr(), date(t)) }
+func (c DateColumn) LTE(t time.Time) *pb.Expr { return LTE(c.Expr(), date(t)) }
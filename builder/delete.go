@@ -26,6 +26,13 @@ func (sb *DeleteStatementBuilder) Where(expr *pb.Expr) *DeleteStatementBuilder {
 	return sb
 }
 
+// AllowFullTable allows the statement to delete every row of the table when it
+// has no where clause. Without it the server refuses such statements.
+func (sb *DeleteStatementBuilder) AllowFullTable() *DeleteStatementBuilder {
+	sb.delete.AllowFullTable = true
+	return sb
+}
+
 // Statement returns either the correctly built statement or the first error
 // that occurred.
 func (sb *DeleteStatementBuilder) Statement() (*pb.Statement, error) {
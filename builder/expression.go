@@ -1,7 +1,12 @@
 package builder
 
 import (
+	"fmt"
 	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"math"
+	"reflect"
+	"time"
 )
 
 func lit(lit *pb.Lit) *pb.Expr {
@@ -30,6 +35,170 @@ func Num(num float64) *pb.Expr {
 	})
 }
 
+// Int returns a new integer literal.
+func Int(i int64) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Int{
+			Int: i,
+		},
+	})
+}
+
+// Time returns a new timestamp literal. Times outside the range of
+// google.protobuf.Timestamp (years 1 to 9999) give a literal which fails
+// validation.
+func Time(t time.Time) *pb.Expr {
+	ts, _ := ptypes.TimestampProto(t)
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Timestamp{
+			Timestamp: ts,
+		},
+	})
+}
+
+// Date returns a new date literal.
+func Date(year int, month time.Month, day int) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Date{
+			Date: &pb.Date{
+				Year:  int32(year),
+				Month: int32(month),
+				Day:   int32(day),
+			},
+		},
+	})
+}
+
+// UUID returns a new UUID literal from its textual form.
+func UUID(uuid string) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Uuid{
+			Uuid: uuid,
+		},
+	})
+}
+
+// Decimal returns a new exact numeric literal from its textual form, such as
+// "-12.50".
+func Decimal(decimal string) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Decimal{
+			Decimal: decimal,
+		},
+	})
+}
+
+// JSON returns a new JSON literal from its encoded form.
+func JSON(json string) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Json{
+			Json: json,
+		},
+	})
+}
+
+// Array returns a new array literal. Each element must be a literal; any
+// other expression gives an array which fails validation.
+func Array(elements ...*pb.Expr) *pb.Expr {
+	lits := make([]*pb.Lit, len(elements))
+	for i, e := range elements {
+		lits[i] = e.GetLit()
+	}
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Array{
+			Array: &pb.Array{
+				Elements: lits,
+			},
+		},
+	})
+}
+
+// Blob returns a new binary literal.
+func Blob(b []byte) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Blob{
+			Blob: b,
+		},
+	})
+}
+
+// CurrentTime returns the CURRENT_TIME literal, the time the statement runs.
+func CurrentTime() *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_CurrentTime{
+			CurrentTime: &pb.CurrentTime{},
+		},
+	})
+}
+
+// CurrentDate returns the CURRENT_DATE literal, the date the statement runs.
+func CurrentDate() *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_CurrentDate{
+			CurrentDate: &pb.CurrentDate{},
+		},
+	})
+}
+
+// CurrentTimestamp returns the CURRENT_TIMESTAMP literal, the date and time
+// the statement runs.
+func CurrentTimestamp() *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_CurrentTimestamp{
+			CurrentTimestamp: &pb.CurrentTimestamp{},
+		},
+	})
+}
+
+// Value returns the literal for a Go value: nil or a nil pointer gives NULL,
+// strings Str, integers Int, floats Num, bools Bool, []byte Blob and time.Time
+// Time. Expressions are returned unchanged, and pointers are followed.
+// Unsigned integers too large for an int64, and values of any other type,
+// give an error.
+func Value(v interface{}) (*pb.Expr, error) {
+	switch v := v.(type) {
+	case nil:
+		return Null(), nil
+	case *pb.Expr:
+		return v, nil
+	case []byte:
+		return Blob(v), nil
+	case time.Time:
+		return Time(v), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return Null(), nil
+		}
+		return Value(rv.Elem().Interface())
+	case reflect.String:
+		return Str(rv.String()), nil
+	case reflect.Bool:
+		return Bool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d is too large for an integer literal", rv.Uint())
+		}
+		return Int(int64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return Num(rv.Float()), nil
+	}
+	return nil, fmt.Errorf("no literal for %T", v)
+}
+
+// Bool returns a new boolean literal.
+func Bool(b bool) *pb.Expr {
+	return lit(&pb.Lit{
+		Lit: &pb.Lit_Boolean{
+			Boolean: b,
+		},
+	})
+}
+
 var null = lit(&pb.Lit{
 	Lit: &pb.Lit_Null{},
 })
@@ -39,6 +208,25 @@ func Null() *pb.Expr {
 	return null
 }
 
+// Param returns a new placeholder for the parameter with the given name, whose
+// value is given when the statement is run.
+func Param(name string) *pb.Expr {
+	return TypedParam(name, pb.ParamType_ANY)
+}
+
+// TypedParam returns a new placeholder for the parameter with the given name,
+// which must be bound to a value of the given type (or null).
+func TypedParam(name string, t pb.ParamType) *pb.Expr {
+	return &pb.Expr{
+		Expr: &pb.Expr_Param{
+			Param: &pb.Param{
+				Name: name,
+				Type: t,
+			},
+		},
+	}
+}
+
 // Col returns a new column expression where only the column is set.
 func Col(column string) *pb.Expr {
 	return SchemaTableCol("", "", column)
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"fmt"
 	pb "github.com/GeorgeBills/grpcdb/api"
 )
 
@@ -25,24 +26,75 @@ func (sb *InsertStatementBuilder) Statement() (*pb.Statement, error) {
 	return Statement(&pb.Statement{Statement: &pb.Statement_Insert{Insert: sb.insert}}, sb.err)
 }
 
-func (sb *InsertStatementBuilder) Values(literals [][]string) *InsertStatementBuilder {
-	vals := &pb.Values{}
-	for _, row := range literals {
-		newRow := &pb.Row{}
-		for _, lit := range row {
-			newVal := Str(lit)
-			newRow.Values = append(newRow.Values, newVal)
+// Values adds rows of values to insert. Each row must have a value for each
+// of the columns given to Insert.
+func (sb *InsertStatementBuilder) Values(rows ...[]*pb.Expr) *InsertStatementBuilder {
+	if sb.err != nil {
+		return sb
+	}
+	vals := sb.insert.GetToInsert().GetValues()
+	if vals == nil {
+		vals = &pb.Values{}
+		sb.insert.ToInsert = &pb.ToInsert{
+			Insert: &pb.ToInsert_Values{
+				Values: vals,
+			},
 		}
-		vals.Rows = append(vals.Rows, newRow)
 	}
-	sb.insert.ToInsert = &pb.ToInsert{
-		Insert: &pb.ToInsert_Values{
-			Values: vals,
-		},
+	for _, row := range rows {
+		if len(row) != len(sb.insert.Columns) {
+			sb.err = fmt.Errorf("row %d has %d values, but %d columns are being inserted", len(vals.Rows), len(row), len(sb.insert.Columns))
+			return sb
+		}
+		vals.Rows = append(vals.Rows, &pb.Row{Values: row})
+	}
+	return sb
+}
+
+// ValueMaps adds rows of values to insert, each given as a map of column to
+// Go value, which are converted as by Value. Each row must have a value for
+// exactly the columns given to Insert.
+func (sb *InsertStatementBuilder) ValueMaps(rows ...map[string]interface{}) *InsertStatementBuilder {
+	for _, m := range rows {
+		if sb.err != nil {
+			return sb
+		}
+		row := make([]*pb.Expr, len(sb.insert.Columns))
+		for i, col := range sb.insert.Columns {
+			v, ok := m[col]
+			if !ok {
+				sb.err = fmt.Errorf("row has no value for column %s", col)
+				return sb
+			}
+			e, err := Value(v)
+			if err != nil {
+				sb.err = fmt.Errorf("column %s: %v", col, err)
+				return sb
+			}
+			row[i] = e
+		}
+		if len(m) != len(row) {
+			for col := range m {
+				if !contains(sb.insert.Columns, col) {
+					sb.err = fmt.Errorf("row has a value for column %s, which isn't being inserted", col)
+					return sb
+				}
+			}
+		}
+		sb.Values(row)
 	}
 	return sb
 }
 
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (sb *InsertStatementBuilder) From(ssb *SelectStatementBuilder) *InsertStatementBuilder {
 	sel, err := ssb.Select()
 	if err != nil {
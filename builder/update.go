@@ -37,6 +37,13 @@ func (sb *UpdateStatementBuilder) Where(expr *pb.Expr) *UpdateStatementBuilder {
 	return sb
 }
 
+// AllowFullTable allows the statement to update every row of the table when it
+// has no where clause. Without it the server refuses such statements.
+func (sb *UpdateStatementBuilder) AllowFullTable() *UpdateStatementBuilder {
+	sb.update.AllowFullTable = true
+	return sb
+}
+
 // Statement returns either the correctly built statement or the first error
 // that occurred.
 func (sb *UpdateStatementBuilder) Statement() (*pb.Statement, error) {
@@ -1,39 +1,349 @@
-package main
+// Package client is a Go client for grpcdb servers.
+package client
 
 import (
 	"context"
-	"github.com/GeorgeBills/grpcdb/api"
-	. "github.com/GeorgeBills/grpcdb/builder"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/scan"
 	"google.golang.org/grpc"
-	"log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
 	"time"
 )
 
-const (
-	target = "localhost:1234"
-)
+// Client sends statements to a grpcdb server.
+type Client struct {
+	conn   *grpc.ClientConn
+	client pb.GRPCDBClient
+}
+
+type options struct {
+	tls          *tls.Config
+	tlsFiles     *tlsFiles
+	creds        credentials.PerRPCCredentials
+	timeout      time.Duration
+	retries      int
+	backoff      time.Duration
+	interceptors []grpc.UnaryClientInterceptor
+	dialOptions  []grpc.DialOption
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithTLS secures the connection with TLS. Without it the connection is
+// insecure.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tls = config
+	}
+}
+
+type tlsFiles struct {
+	caFile, certFile, keyFile string
+}
+
+// WithTLSFiles secures the connection with TLS, loading the configuration from
+// PEM files. The server's certificate is verified against the CAs in caFile,
+// or against the system's CAs if caFile is empty. If certFile and keyFile are
+// set the client presents that certificate to the server, as required for
+// mutual TLS. Errors loading the files are returned by Dial.
+func WithTLSFiles(caFile, certFile, keyFile string) Option {
+	return func(o *options) {
+		o.tlsFiles = &tlsFiles{caFile, certFile, keyFile}
+	}
+}
+
+// WithCredentials attaches credentials, such as an API key or token, to every
+// call.
+func WithCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithBearerToken sends token, such as an API key or JWT, in the authorization
+// metadata of every call.
+func WithBearerToken(token string) Option {
+	return WithCredentials(bearerToken(token))
+}
+
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity returns false so that tokens can be used against a
+// server on localhost; use TLS everywhere else.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
 
-func main() {
-	conn, err := grpc.Dial(target, grpc.WithInsecure())
+// WithTimeout sets the deadline for calls whose context doesn't already have
+// one.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetries retries calls which fail with Aborted, or with Unavailable if
+// they don't change any data, up to retries times, waiting backoff before the
+// first retry and doubling the wait before each subsequent retry. Inserts,
+// updates, deletes and prepared statements aren't retried when Unavailable,
+// since the server also returns it when the database fails after a statement
+// may have been applied.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithInterceptors adds interceptors to every call. They're run in the order
+// given, each retry passes through them again, and they see the default
+// deadline.
+func WithInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithDialOptions passes options through to grpc.Dial.
+func WithDialOptions(dialOptions ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, dialOptions...)
+	}
+}
+
+// Dial connects to the grpcdb server at target.
+func Dial(target string, opts ...Option) (*Client, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.tlsFiles != nil {
+		config, err := loadTLSConfig(o.tlsFiles)
+		if err != nil {
+			return nil, err
+		}
+		o.tls = config
+	}
+	var dialOptions []grpc.DialOption
+	if o.tls != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(o.tls)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+	if o.creds != nil {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(o.creds))
+	}
+	var interceptors []grpc.UnaryClientInterceptor
+	if o.timeout > 0 {
+		interceptors = append(interceptors, timeoutInterceptor(o.timeout))
+	}
+	if o.retries > 0 {
+		interceptors = append(interceptors, retryInterceptor(o.retries, o.backoff))
+	}
+	interceptors = append(interceptors, o.interceptors...)
+	if len(interceptors) > 0 {
+		dialOptions = append(dialOptions, grpc.WithUnaryInterceptor(chain(interceptors)))
+	}
+	dialOptions = append(dialOptions, o.dialOptions...)
+	conn, err := grpc.Dial(target, dialOptions...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return &Client{
+		conn:   conn,
+		client: pb.NewGRPCDBClient(conn),
+	}, nil
+}
+
+func loadTLSConfig(files *tlsFiles) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if files.caFile != "" {
+		pem, err := ioutil.ReadFile(files.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", files.caFile)
+		}
 	}
-	defer conn.Close()
-	client := grpcdbpb.NewGRPCDBClient(conn)
-	statement, err := Select("person", "full_name").
-		OrderBy(Col("birth"), grpcdbpb.OrderingDirection_DESC).
-		Statement()
+	if files.certFile != "" || files.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.certFile, files.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// GRPCDBClient returns the underlying generated client.
+func (c *Client) GRPCDBClient() pb.GRPCDBClient {
+	return c.client
+}
+
+// Query builds the statement and runs it.
+func (c *Client) Query(ctx context.Context, sb builder.StatementBuilder) (*pb.Result, error) {
+	statement, err := sb.Statement()
+	if err != nil {
+		return nil, err
+	}
+	return c.QueryStatement(ctx, statement)
+}
+
+// QueryStatement runs an already built statement.
+func (c *Client) QueryStatement(ctx context.Context, statement *pb.Statement) (*pb.Result, error) {
+	return c.client.Query(ctx, statement)
+}
+
+// All builds and runs the statement and scans every row of the result into
+// dest, as described by scan.All.
+func (c *Client) All(ctx context.Context, sb builder.StatementBuilder, dest interface{}) error {
+	result, err := c.Query(ctx, sb)
+	if err != nil {
+		return err
+	}
+	return scan.All(result, dest)
+}
+
+// One builds and runs the statement and scans the first row of the result into
+// dest, as described by scan.One.
+func (c *Client) One(ctx context.Context, sb builder.StatementBuilder, dest interface{}) error {
+	result, err := c.Query(ctx, sb)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return scan.One(result, dest)
+}
+
+// Explain builds the statement and returns the database's plan for it,
+// without running it. With analyze the statement is run to measure it, then
+// rolled back; the server's policy must allow this.
+func (c *Client) Explain(ctx context.Context, sb builder.StatementBuilder, analyze bool) (*pb.ExplainResult, error) {
+	statement, err := sb.Statement()
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Explain(ctx, &pb.ExplainRequest{Statement: statement, Analyze: analyze})
+}
+
+// Prepare builds a statement with parameters and prepares it on the server.
+// Run it with ExecutePrepared, binding a value to each of the handle's
+// parameters.
+func (c *Client) Prepare(ctx context.Context, sb builder.StatementBuilder) (*pb.PreparedHandle, error) {
+	statement, err := sb.Statement()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer cancel()
-	result, err := client.Query(ctx, statement)
+	return c.client.Prepare(ctx, statement)
+}
+
+// ExecutePrepared runs a prepared statement with values bound to its
+// parameters, by name. The server returns NotFound if it has evicted the
+// statement, in which case it must be prepared again.
+func (c *Client) ExecutePrepared(ctx context.Context, id string, params map[string]*pb.Value) (*pb.Result, error) {
+	return c.client.ExecutePrepared(ctx, &pb.ExecutePreparedRequest{Id: id, Parameters: params})
+}
+
+// Translate builds the statement and returns the SQL the server would run for
+// it, after applying its policy, without running it.
+func (c *Client) Translate(ctx context.Context, sb builder.StatementBuilder) (*pb.TranslatedStatement, error) {
+	statement, err := sb.Statement()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return c.client.Translate(ctx, statement)
+}
+
+// DescribeSchema describes the given schemas, or all schemas if none are
+// given.
+func (c *Client) DescribeSchema(ctx context.Context, schemas ...string) (*pb.DatabaseSchema, error) {
+	return c.client.DescribeSchema(ctx, &pb.DescribeSchemaRequest{Schemas: schemas})
+}
+
+// chain combines interceptors into one, which runs them in order.
+func chain(interceptors []grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		next := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, invoke := interceptors[i], next
+			next = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return interceptor(ctx, method, req, reply, cc, invoke, opts...)
+			}
+		}
+		return next(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// retryable returns true for errors where the call can safely be made again:
+// the database aborted the statement (e.g. a serialization failure or
+// deadlock) without applying it, or the server or database was unavailable
+// and the call only reads.
+func retryable(method string, req interface{}, err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted:
+		return true
+	case codes.Unavailable:
+		return readOnly(method, req)
+	}
+	return false
+}
+
+// readOnly returns true if the call can't change any data. Prepared
+// statements are assumed to, as the handle doesn't say what they do.
+func readOnly(method string, req interface{}) bool {
+	switch method {
+	case "/grpcdbpb.GRPCDB/DescribeSchema", "/grpcdbpb.GRPCDB/Explain", "/grpcdbpb.GRPCDB/Translate", "/grpcdbpb.GRPCDB/Prepare":
+		// explain analyze runs the statement in a transaction it rolls back
+		return true
+	case "/grpcdbpb.GRPCDB/Query":
+		statement, ok := req.(*pb.Statement)
+		return ok && statement.GetSelect() != nil
+	}
+	return false
+}
+
+func retryInterceptor(retries int, backoff time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		wait := backoff
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		for i := 0; i < retries && retryable(method, req, err); i++ {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return err
 	}
-	log.Printf("Result: %v", result)
 }
@@ -0,0 +1,221 @@
+package client_test
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/client"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// server fails the first failures calls, then returns a single row holding
+// the authorization metadata and whether the call had a deadline.
+type server struct {
+	failures int
+	calls    int
+}
+
+func (s *server) Query(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.Result, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	_, deadline := ctx.Deadline()
+	return &grpcdbpb.Result{
+		Columns: []string{"authorization", "deadline"},
+		Rows: []*grpcdbpb.ResultRow{{Values: []*grpcdbpb.Value{
+			{Value: &grpcdbpb.Value_Str{Str: first(md["authorization"])}},
+			{Value: &grpcdbpb.Value_Boolean{Boolean: deadline}},
+		}}},
+	}, nil
+}
+
+func (s *server) DescribeSchema(ctx context.Context, req *grpcdbpb.DescribeSchemaRequest) (*grpcdbpb.DatabaseSchema, error) {
+	return &grpcdbpb.DatabaseSchema{}, nil
+}
+
+func (s *server) Explain(ctx context.Context, req *grpcdbpb.ExplainRequest) (*grpcdbpb.ExplainResult, error) {
+	s.calls++
+	plan := &grpcdbpb.PlanNode{NodeType: "Seq Scan", Relation: req.Statement.GetSelect().GetFrom()}
+	if req.Analyze {
+		plan.ActualLoops = 1
+	}
+	return &grpcdbpb.ExplainResult{Sql: "SELECT a FROM t", Plan: plan}, nil
+}
+
+func (s *server) Translate(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.TranslatedStatement, error) {
+	s.calls++
+	return &grpcdbpb.TranslatedStatement{Sql: "SELECT a FROM " + statement.GetSelect().GetFrom()}, nil
+}
+
+func (s *server) Prepare(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.PreparedHandle, error) {
+	s.calls++
+	return &grpcdbpb.PreparedHandle{Id: "h", Parameters: []string{"id"}}, nil
+}
+
+func (s *server) ExecutePrepared(ctx context.Context, req *grpcdbpb.ExecutePreparedRequest) (*grpcdbpb.Result, error) {
+	s.calls++
+	if req.Id != "h" {
+		return nil, status.Error(codes.NotFound, "unknown handle")
+	}
+	return &grpcdbpb.Result{Columns: []string{"id"}, Rows: []*grpcdbpb.ResultRow{{Values: []*grpcdbpb.Value{req.Parameters["id"]}}}}, nil
+}
+
+func first(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	return ss[0]
+}
+
+func serve(t *testing.T, s *server) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	gs := grpc.NewServer()
+	grpcdbpb.RegisterGRPCDBServer(gs, s)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	return lis.Addr().String()
+}
+
+type row struct {
+	Authorization string `grpcdb:"authorization"`
+	Deadline      bool   `grpcdb:"deadline"`
+}
+
+func TestClient(t *testing.T) {
+	s := &server{failures: 2}
+	var intercepted []string
+	c, err := client.Dial(serve(t, s),
+		client.WithBearerToken("secret"),
+		client.WithTimeout(time.Minute),
+		client.WithRetries(2, time.Millisecond),
+		client.WithInterceptors(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			intercepted = append(intercepted, method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	var r row
+	err = c.One(context.Background(), Select("t", "a"), &r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.Authorization != "Bearer secret" {
+		t.Errorf("Expected credentials to be sent, got: %q", r.Authorization)
+	}
+	if !r.Deadline {
+		t.Errorf("Expected the default deadline to be set")
+	}
+	if s.calls != 3 || len(intercepted) != 3 {
+		t.Errorf("Expected 3 calls through the interceptor, got %d calls and %d intercepted", s.calls, len(intercepted))
+	}
+}
+
+func TestClientRetriesExhausted(t *testing.T) {
+	s := &server{failures: 5}
+	c, err := client.Dial(serve(t, s), client.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	_, err = c.Query(context.Background(), Select("t", "a"))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable, got: %v", err)
+	}
+	if s.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", s.calls)
+	}
+}
+
+func TestClientWritesNotRetried(t *testing.T) {
+	s := &server{failures: 5}
+	c, err := client.Dial(serve(t, s), client.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	_, err = c.Query(context.Background(), Delete(Table("t")).Where(Eq(Col("a"), Num(1))))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable, got: %v", err)
+	}
+	if s.calls != 1 {
+		t.Errorf("Expected the delete not to be retried, got %d calls", s.calls)
+	}
+}
+
+func TestClientExplain(t *testing.T) {
+	c, err := client.Dial(serve(t, &server{}))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	result, err := c.Explain(context.Background(), Select("t", "a"), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Sql != "SELECT a FROM t" || result.Plan.Relation != "t" || result.Plan.ActualLoops != 1 {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+func TestClientPrepared(t *testing.T) {
+	c, err := client.Dial(serve(t, &server{}))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	handle, err := c.Prepare(context.Background(), Select("t", "id").Where(Eq(Col("id"), Param("id"))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	id := &grpcdbpb.Value{Value: &grpcdbpb.Value_Int{Int: 7}}
+	result, err := c.ExecutePrepared(context.Background(), handle.Id, map[string]*grpcdbpb.Value{"id": id})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Rows) != 1 || !proto.Equal(result.Rows[0].Values[0], id) {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+func TestClientTranslate(t *testing.T) {
+	c, err := client.Dial(serve(t, &server{}))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	ts, err := c.Translate(context.Background(), Select("t", "a"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ts.Sql != "SELECT a FROM t" {
+		t.Errorf("Unexpected result: %v", ts)
+	}
+}
+
+func TestClientBuilderError(t *testing.T) {
+	s := &server{}
+	c, err := client.Dial(serve(t, s))
+	if err != nil {
+		t.Fatalf("Couldn't dial: %v", err)
+	}
+	defer c.Close()
+	_, err = c.Query(context.Background(), Select("t", "a").Having(Col("b")))
+	if err == nil || s.calls != 0 {
+		t.Errorf("Expected the builder's error without calling the server, got: %v", err)
+	}
+}
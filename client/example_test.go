@@ -0,0 +1,32 @@
+package client_test
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/client"
+	"log"
+	"time"
+)
+
+func Example() {
+	c, err := client.Dial("localhost:1234",
+		client.WithTimeout(3*time.Second),
+		client.WithRetries(3, 100*time.Millisecond),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	var people []struct {
+		FullName string `grpcdb:"full_name"`
+	}
+	err = c.All(context.Background(),
+		Select("person", "full_name").
+			OrderBy(Col("birth"), grpcdbpb.OrderingDirection_DESC),
+		&people)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("People: %v", people)
+}
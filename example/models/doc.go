@@ -0,0 +1,5 @@
+// Package models is generated by grpcdb-gen from testdata/database.sql, as an
+// example of the generated code.
+package models
+
+//go:generate go run ../../grpcdb-gen -ddl ../../testdata/database.sql -package models -out models.go
@@ -0,0 +1,167 @@
+// Code generated by grpcdb-gen. DO NOT EDIT.
+
+package models
+
+import (
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/scan"
+	"time"
+)
+
+// Continent is the continent enum type.
+type Continent string
+
+// Values of the continent enum type.
+const (
+	ContinentAfrica       Continent = "Africa"
+	ContinentAsia         Continent = "Asia"
+	ContinentEurope       Continent = "Europe"
+	ContinentNorthAmerica Continent = "North America"
+	ContinentOceania      Continent = "Oceania"
+	ContinentSouthAmerica Continent = "South America"
+)
+
+// ContinentColumn is a column holding a Continent.
+type ContinentColumn struct {
+	builder.Column
+}
+
+func (c ContinentColumn) Eq(v Continent) *pb.Expr {
+	return builder.Eq(c.Expr(), builder.Str(string(v)))
+}
+
+func (c ContinentColumn) NEq(v Continent) *pb.Expr {
+	return builder.NEq(c.Expr(), builder.Str(string(v)))
+}
+
+func scanContinent(v *pb.Value) (Continent, error) {
+	s, err := scan.String(v)
+	return Continent(s), err
+}
+
+func scanContinentPtr(v *pb.Value) (*Continent, error) {
+	s, err := scan.StringPtr(v)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	e := Continent(*s)
+	return &e, nil
+}
+
+// CountryTable is the name of the country table.
+const CountryTable = "country"
+
+// CountryColumns are the names of every column in the country table.
+var CountryColumns = []string{
+	"id",
+	"country",
+	"continent",
+}
+
+// Country holds the typed columns of the country table.
+var Country = struct {
+	ID        builder.StringColumn
+	Country   builder.StringColumn
+	Continent ContinentColumn
+}{
+	ID:        builder.StringColumn{Column: builder.Column{Table: "country", Column: "id"}},
+	Country:   builder.StringColumn{Column: builder.Column{Table: "country", Column: "country"}},
+	Continent: ContinentColumn{Column: builder.Column{Table: "country", Column: "continent"}},
+}
+
+// CountryRow is a row of the country table.
+type CountryRow struct {
+	ID        string     `grpcdb:"id"`
+	Country   *string    `grpcdb:"country"`
+	Continent *Continent `grpcdb:"continent"`
+}
+
+// ScanCountryRows converts the rows of a result into CountryRows. Columns
+// are matched by name, and columns which aren't in the country table are
+// ignored.
+func ScanCountryRows(result *pb.Result) ([]CountryRow, error) {
+	rows := make([]CountryRow, len(result.Rows))
+	for i, r := range result.Rows {
+		if len(r.Values) != len(result.Columns) {
+			return nil, fmt.Errorf("row %d has %d values but there are %d columns", i, len(r.Values), len(result.Columns))
+		}
+		for j, column := range result.Columns {
+			var err error
+			switch column {
+			case "id":
+				rows[i].ID, err = scan.String(r.Values[j])
+			case "country":
+				rows[i].Country, err = scan.StringPtr(r.Values[j])
+			case "continent":
+				rows[i].Continent, err = scanContinentPtr(r.Values[j])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %s: %v", i, column, err)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// PersonTable is the name of the person table.
+const PersonTable = "person"
+
+// PersonColumns are the names of every column in the person table.
+var PersonColumns = []string{
+	"id",
+	"full_name",
+	"birth",
+	"country_id",
+}
+
+// Person holds the typed columns of the person table.
+var Person = struct {
+	ID        builder.StringColumn
+	FullName  builder.StringColumn
+	Birth     builder.DateColumn
+	CountryID builder.StringColumn
+}{
+	ID:        builder.StringColumn{Column: builder.Column{Table: "person", Column: "id"}},
+	FullName:  builder.StringColumn{Column: builder.Column{Table: "person", Column: "full_name"}},
+	Birth:     builder.DateColumn{Column: builder.Column{Table: "person", Column: "birth"}},
+	CountryID: builder.StringColumn{Column: builder.Column{Table: "person", Column: "country_id"}},
+}
+
+// PersonRow is a row of the person table.
+type PersonRow struct {
+	ID        string     `grpcdb:"id"`
+	FullName  *string    `grpcdb:"full_name"`
+	Birth     *time.Time `grpcdb:"birth"`
+	CountryID *string    `grpcdb:"country_id"`
+}
+
+// ScanPersonRows converts the rows of a result into PersonRows. Columns
+// are matched by name, and columns which aren't in the person table are
+// ignored.
+func ScanPersonRows(result *pb.Result) ([]PersonRow, error) {
+	rows := make([]PersonRow, len(result.Rows))
+	for i, r := range result.Rows {
+		if len(r.Values) != len(result.Columns) {
+			return nil, fmt.Errorf("row %d has %d values but there are %d columns", i, len(r.Values), len(result.Columns))
+		}
+		for j, column := range result.Columns {
+			var err error
+			switch column {
+			case "id":
+				rows[i].ID, err = scan.String(r.Values[j])
+			case "full_name":
+				rows[i].FullName, err = scan.StringPtr(r.Values[j])
+			case "birth":
+				rows[i].Birth, err = scan.TimePtr(r.Values[j])
+			case "country_id":
+				rows[i].CountryID, err = scan.StringPtr(r.Values[j])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %s: %v", i, column, err)
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	pb "github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	. "github.com/GeorgeBills/grpcdb/example/models"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPredicates(t *testing.T) {
+	birth := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	statement, err := Select(PersonTable, PersonColumns...).
+		JoinEq(CountryTable, Person.CountryID.Expr(), Country.ID.Expr()).
+		Where(Person.Birth.GT(birth)).
+		Where(Country.Continent.Eq(ContinentEurope)).
+		Statement()
+	if err != nil {
+		t.Fatalf("Couldn't build statement: %v", err)
+	}
+	expected := All(
+		GT(TableCol("person", "birth"), Date(2000, time.January, 1)),
+		Eq(TableCol("country", "continent"), Str("Europe")),
+	)
+	if actual := statement.GetSelect().Where; !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+}
+
+func TestScanRows(t *testing.T) {
+	birth := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, err := ptypes.TimestampProto(birth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &pb.Result{
+		Columns: []string{"id", "full_name", "birth", "unknown"},
+		Rows: []*pb.ResultRow{
+			{Values: []*pb.Value{
+				{Value: &pb.Value_Str{Str: "a"}},
+				{Value: &pb.Value_Str{Str: "Alice"}},
+				{Value: &pb.Value_Time{Time: ts}},
+				{Value: &pb.Value_Int{Int: 1}},
+			}},
+			{Values: []*pb.Value{
+				{Value: &pb.Value_Str{Str: "b"}},
+				{Value: &pb.Value_Null{Null: &pb.Null{}}},
+				{Value: &pb.Value_Null{Null: &pb.Null{}}},
+				{Value: &pb.Value_Null{Null: &pb.Null{}}},
+			}},
+		},
+	}
+	actual, err := ScanPersonRows(result)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	name := "Alice"
+	expected := []PersonRow{
+		{ID: "a", FullName: &name, Birth: &birth},
+		{ID: "b"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected: %+v\nActual: %+v", expected, actual)
+	}
+
+	result.Rows[0].Values[0] = &pb.Value{Value: &pb.Value_Int{Int: 1}}
+	_, err = ScanPersonRows(result)
+	if err == nil || err.Error() != "row 0 column id: can't scan integer into string" {
+		t.Errorf("Expected type mismatch error, got: %v", err)
+	}
+}
@@ -1,8 +1,12 @@
 module github.com/GeorgeBills/grpcdb
 
+go 1.15
+
 require (
 	github.com/golang/protobuf v1.3.0
 	github.com/lib/pq v1.0.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd
+	google.golang.org/genproto v0.0.0-20180831171423-11092d34479b
 	google.golang.org/grpc v1.19.0
 )
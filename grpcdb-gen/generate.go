@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"go/format"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// goType describes how a database type is represented in generated code.
+type goType struct {
+	Type     string // type of the row struct field for NOT NULL columns
+	NullType string // type of the row struct field for nullable columns
+	Column   string // typed column used to build predicates
+	Scan     string // function converting a *pb.Value into Type
+	NullScan string // function converting a *pb.Value into NullType
+}
+
+var (
+	stringType  = goType{"string", "*string", "builder.StringColumn", "scan.String", "scan.StringPtr"}
+	intType     = goType{"int64", "*int64", "builder.IntColumn", "scan.Int64", "scan.Int64Ptr"}
+	decimalType = goType{"string", "*string", "builder.DecimalColumn", "scan.String", "scan.StringPtr"}
+	floatType   = goType{"float64", "*float64", "builder.NumColumn", "scan.Float64", "scan.Float64Ptr"}
+	boolType    = goType{"bool", "*bool", "builder.BoolColumn", "scan.Bool", "scan.BoolPtr"}
+	timeType    = goType{"time.Time", "*time.Time", "builder.TimeColumn", "scan.Time", "scan.TimePtr"}
+	dateType    = goType{"time.Time", "*time.Time", "builder.DateColumn", "scan.Time", "scan.TimePtr"}
+	bytesType   = goType{"[]byte", "[]byte", "builder.Column", "scan.Bytes", "scan.Bytes"}
+)
+
+// enumType returns the representation of a column holding a generated enum.
+func enumType(name string) goType {
+	return goType{name, "*" + name, name + "Column", "scan" + name, "scan" + name + "Ptr"}
+}
+
+// typeOf maps a column's declared type (as given by PostgreSQL, SQLite or a DDL
+// file) onto a Go type, using the same kinds as type checking. Anything
+// unrecognised is treated as a string, as are exact numbers, to keep their
+// precision.
+func typeOf(schema *pb.Schema, declared string) goType {
+	switch grpcdb.ColumnKind(schema, declared) {
+	case grpcdb.KindEnum:
+		return enumType(goName(declared))
+	case grpcdb.KindBoolean:
+		return boolType
+	case grpcdb.KindInteger:
+		return intType
+	case grpcdb.KindDecimal:
+		return decimalType
+	case grpcdb.KindFloat:
+		return floatType
+	case grpcdb.KindDate:
+		return dateType
+	case grpcdb.KindTimestamp:
+		return timeType
+	case grpcdb.KindBinary:
+		return bytesType
+	}
+	return stringType
+}
+
+// initialisms are written in upper case in Go names, per the Go style guide.
+var initialisms = map[string]bool{
+	"API": true, "HTML": true, "HTTP": true, "ID": true, "IP": true,
+	"JSON": true, "SQL": true, "URL": true, "UUID": true, "XML": true,
+}
+
+// goName converts a database name such as "country_id" or "North America" into
+// an exported Go name such as "CountryID" or "NorthAmerica".
+func goName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var sb strings.Builder
+	for _, w := range words {
+		upper := strings.ToUpper(w)
+		if initialisms[upper] {
+			sb.WriteString(upper)
+			continue
+		}
+		runes := []rune(w)
+		sb.WriteString(strings.ToUpper(string(runes[0])) + string(runes[1:]))
+	}
+	s := sb.String()
+	if s == "" || unicode.IsDigit([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+}
+
+type enumData struct {
+	Name   string
+	GoName string
+	Values []enumValue
+}
+
+type enumValue struct {
+	Value  string
+	GoName string
+}
+
+type tableData struct {
+	Name    string
+	GoName  string
+	Columns []columnData
+}
+
+type columnData struct {
+	Name      string
+	GoName    string
+	FieldType string
+	Column    string
+	Scan      string
+}
+
+// generate returns the formatted source of a package holding typed table,
+// column and row definitions for every table and enum type in the schema.
+func generate(schema *pb.Schema, pkg string) ([]byte, error) {
+	data := struct {
+		Package string
+		Enums   []enumData
+		Tables  []tableData
+		Time    bool
+	}{Package: pkg}
+	for _, e := range schema.Enums {
+		ed := enumData{Name: e.Name, GoName: goName(e.Name)}
+		for _, v := range e.Values {
+			ed.Values = append(ed.Values, enumValue{v, ed.GoName + goName(v)})
+		}
+		data.Enums = append(data.Enums, ed)
+	}
+	for _, t := range schema.Tables {
+		td := tableData{Name: t.Name, GoName: goName(t.Name)}
+		for _, c := range t.Columns {
+			typ := typeOf(schema, c.Type)
+			cd := columnData{
+				Name:      c.Name,
+				GoName:    goName(c.Name),
+				FieldType: typ.Type,
+				Column:    typ.Column,
+				Scan:      typ.Scan,
+			}
+			if c.Nullable {
+				cd.FieldType, cd.Scan = typ.NullType, typ.NullScan
+			}
+			if typ.Type == "time.Time" {
+				data.Time = true
+			}
+			td.Columns = append(td.Columns, cd)
+		}
+		data.Tables = append(data.Tables, td)
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Error formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by grpcdb-gen. DO NOT EDIT.
+
+package {{.Package}}
+{{if or .Tables .Enums}}
+import (
+	{{- if .Tables}}
+	"fmt"
+	{{- end}}
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/scan"
+	{{- if .Time}}
+	"time"
+	{{- end}}
+)
+{{end}}
+{{- range .Enums}}
+// {{.GoName}} is the {{.Name}} enum type.
+type {{.GoName}} string
+
+// Values of the {{.Name}} enum type.
+const (
+{{- $enum := .GoName}}
+{{- range .Values}}
+	{{.GoName}} {{$enum}} = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// {{.GoName}}Column is a column holding a {{.GoName}}.
+type {{.GoName}}Column struct {
+	builder.Column
+}
+
+func (c {{.GoName}}Column) Eq(v {{.GoName}}) *pb.Expr {
+	return builder.Eq(c.Expr(), builder.Str(string(v)))
+}
+
+func (c {{.GoName}}Column) NEq(v {{.GoName}}) *pb.Expr {
+	return builder.NEq(c.Expr(), builder.Str(string(v)))
+}
+
+func scan{{.GoName}}(v *pb.Value) ({{.GoName}}, error) {
+	s, err := scan.String(v)
+	return {{.GoName}}(s), err
+}
+
+func scan{{.GoName}}Ptr(v *pb.Value) (*{{.GoName}}, error) {
+	s, err := scan.StringPtr(v)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	e := {{.GoName}}(*s)
+	return &e, nil
+}
+{{end}}
+{{- range .Tables}}
+// {{.GoName}}Table is the name of the {{.Name}} table.
+const {{.GoName}}Table = {{printf "%q" .Name}}
+
+// {{.GoName}}Columns are the names of every column in the {{.Name}} table.
+var {{.GoName}}Columns = []string{
+{{- range .Columns}}
+	{{printf "%q" .Name}},
+{{- end}}
+}
+
+// {{.GoName}} holds the typed columns of the {{.Name}} table.
+var {{.GoName}} = struct {
+{{- range .Columns}}
+	{{.GoName}} {{.Column}}
+{{- end}}
+}{
+{{- $table := .Name}}
+{{- range .Columns}}
+	{{- if eq .Column "builder.Column"}}
+	{{.GoName}}: builder.Column{Table: {{printf "%q" $table}}, Column: {{printf "%q" .Name}}},
+	{{- else}}
+	{{.GoName}}: {{.Column}}{Column: builder.Column{Table: {{printf "%q" $table}}, Column: {{printf "%q" .Name}}}},
+	{{- end}}
+{{- end}}
+}
+
+// {{.GoName}}Row is a row of the {{.Name}} table.
+type {{.GoName}}Row struct {
+{{- range .Columns}}
+	{{.GoName}} {{.FieldType}} ` + "`" + `grpcdb:"{{.Name}}"` + "`" + `
+{{- end}}
+}
+
+// Scan{{.GoName}}Rows converts the rows of a result into {{.GoName}}Rows. Columns
+// are matched by name, and columns which aren't in the {{.Name}} table are
+// ignored.
+func Scan{{.GoName}}Rows(result *pb.Result) ([]{{.GoName}}Row, error) {
+	rows := make([]{{.GoName}}Row, len(result.Rows))
+	for i, r := range result.Rows {
+		if len(r.Values) != len(result.Columns) {
+			return nil, fmt.Errorf("row %d has %d values but there are %d columns", i, len(r.Values), len(result.Columns))
+		}
+		for j, column := range result.Columns {
+			var err error
+			switch column {
+			{{- range .Columns}}
+			case {{printf "%q" .Name}}:
+				rows[i].{{.GoName}}, err = {{.Scan}}(r.Values[j])
+			{{- end}}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %s: %v", i, column, err)
+			}
+		}
+	}
+	return rows, nil
+}
+{{end}}`))
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"io/ioutil"
+	"testing"
+)
+
+// TestGenerate checks that the example package is up to date with the
+// generator.
+func TestGenerate(t *testing.T) {
+	schema, err := readDDL("../testdata/database.sql")
+	if err != nil {
+		t.Fatalf("Couldn't read DDL: %v", err)
+	}
+	actual, err := generate(schema.Schemas[0], "models")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected, err := ioutil.ReadFile("../example/models/models.go")
+	if err != nil {
+		t.Fatalf("Couldn't read example: %v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("example/models/models.go is out of date; run go generate ./example/models")
+	}
+}
+
+func TestGoName(t *testing.T) {
+	table := map[string]string{
+		"person":        "Person",
+		"country_id":    "CountryID",
+		"North America": "NorthAmerica",
+		"api_url":       "APIURL",
+		"2fa":           "X2fa",
+	}
+	for name, expected := range table {
+		if actual := goName(name); actual != expected {
+			t.Errorf("goName(%q): expected %s, got %s", name, expected, actual)
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	schema := &pb.Schema{Enums: []*pb.EnumType{{Name: "continent"}}}
+	table := map[string]goType{
+		"bigint":                   intType,
+		"INT(11)":                  intType,
+		"interval":                 stringType,
+		"point":                    stringType,
+		"numeric":                  decimalType,
+		"decimal(10,2)":            decimalType,
+		"double precision":         floatType,
+		"date":                     dateType,
+		"timestamp with time zone": timeType,
+		"time without time zone":   stringType,
+		"timetz":                   stringType,
+		"bytea":                    bytesType,
+		"continent":                enumType("Continent"),
+	}
+	for declared, expected := range table {
+		if actual := typeOf(schema, declared); actual != expected {
+			t.Errorf("typeOf(%q): expected %v, got %v", declared, expected, actual)
+		}
+	}
+}
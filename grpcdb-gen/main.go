@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/introspect"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	ddl := flag.String("ddl", "", "read the schema from a file of SQL DDL statements")
+	target := flag.String("target", "", "read the schema from a grpcdb server at this address")
+	schemaName := flag.String("schema", "public", "generate code for the tables in this schema")
+	pkg := flag.String("package", "models", "name of the generated package")
+	out := flag.String("out", "", "write generated code to this file instead of stdout")
+	flag.Parse()
+
+	var schema *grpcdbpb.DatabaseSchema
+	var err error
+	switch {
+	case *ddl != "" && *target == "":
+		schema, err = readDDL(*ddl)
+	case *target != "" && *ddl == "":
+		schema, err = describeSchema(*target, *schemaName)
+	default:
+		err = fmt.Errorf("Exactly one of -ddl or -target is required")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var found *grpcdbpb.Schema
+	for _, s := range schema.Schemas {
+		if s.Name == *schemaName {
+			found = s
+		}
+	}
+	if found == nil {
+		log.Fatalf("Schema %s not found", *schemaName)
+	}
+
+	src, err := generate(found, *pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readDDL(filename string) (*grpcdbpb.DatabaseSchema, error) {
+	ddl, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return introspect.ParseDDL(string(ddl))
+}
+
+func describeSchema(target, schema string) (*grpcdbpb.DatabaseSchema, error) {
+	conn, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := grpcdbpb.NewGRPCDBClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.DescribeSchema(ctx, &grpcdbpb.DescribeSchemaRequest{Schemas: []string{schema}})
+}
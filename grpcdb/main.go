@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"github.com/GeorgeBills/grpcdb/client"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	target := flag.String("target", "localhost:1234", "address of the grpcdb server")
+	timeout := flag.Duration("timeout", 10*time.Second, "deadline for each call to the server")
+	format := flag.String("format", "table", "output format: table, csv or json")
+	explain := flag.Bool("explain", false, "print the query plan for each statement instead of running it")
+	command := flag.String("c", "", "run this statement or command and exit")
+	history := flag.String("history", defaultHistory(), "file to save history in, or empty to disable history")
+	useTLS := flag.Bool("tls", false, "connect with TLS, verifying the server against the system's CAs unless -tls-ca is set")
+	tlsCA := flag.String("tls-ca", "", "verify the server's certificate against the CAs in this PEM file")
+	tlsCert := flag.String("tls-cert", "", "present the client certificate in this PEM file (mutual TLS)")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert")
+	token := flag.String("token", os.Getenv("GRPCDB_TOKEN"), "authenticate with this API key or JWT (default $GRPCDB_TOKEN)")
+	flag.Parse()
+
+	if !validFormat(*format) {
+		log.Fatalf("Unrecognized format %s; must be one of table, csv or json", *format)
+	}
+
+	options := []client.Option{client.WithTimeout(*timeout)}
+	if *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		options = append(options, client.WithTLSFiles(*tlsCA, *tlsCert, *tlsKey))
+	}
+	if *token != "" {
+		options = append(options, client.WithBearerToken(*token))
+	}
+	c, err := client.Dial(*target, options...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	r := &repl{
+		client:  c,
+		out:     os.Stdout,
+		format:  *format,
+		explain: *explain,
+	}
+	if *command != "" {
+		err = r.run(strings.TrimSuffix(strings.TrimSpace(*command), ";"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *history != "" {
+		err = r.openHistory(*history)
+		if err != nil {
+			log.Printf("Couldn't open history: %v", err)
+		}
+		defer r.closeHistory()
+	}
+	r.loop(os.Stdin, isTerminal(os.Stdin))
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".grpcdb_history")
+}
+
+// isTerminal returns true if f is a terminal rather than a file or pipe, in
+// which case prompts are shown.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
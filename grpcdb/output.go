@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func validFormat(format string) bool {
+	return format == "table" || format == "csv" || format == "json"
+}
+
+// printResult writes the result in the given format. Results of statements
+// which don't return rows are printed as the number of rows affected.
+func printResult(w io.Writer, format string, result *grpcdbpb.Result) error {
+	switch format {
+	case "table":
+		return printTable(w, result)
+	case "csv":
+		return printCSV(w, result)
+	case "json":
+		return printJSON(w, result)
+	}
+	return fmt.Errorf("Unrecognized format %s", format)
+}
+
+// printPlan writes the SQL the server ran and its query plan, in the style of
+// psql's EXPLAIN output.
+func printPlan(w io.Writer, result *grpcdbpb.ExplainResult) error {
+	var sb strings.Builder
+	sb.WriteString(result.Sql + "\n")
+	writePlanNode(&sb, result.Plan, 0)
+	if result.PlanningTimeMs > 0 {
+		fmt.Fprintf(&sb, "Planning Time: %.3f ms\n", result.PlanningTimeMs)
+	}
+	if result.ExecutionTimeMs > 0 {
+		fmt.Fprintf(&sb, "Execution Time: %.3f ms\n", result.ExecutionTimeMs)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writePlanNode(sb *strings.Builder, node *grpcdbpb.PlanNode, depth int) {
+	if node == nil {
+		return
+	}
+	indent := strings.Repeat(" ", 6*depth)
+	if depth > 0 {
+		sb.WriteString(indent[:len(indent)-6] + "  ->  ")
+	}
+	switch {
+	case node.JoinType == "" || node.JoinType == "Inner":
+		sb.WriteString(node.NodeType)
+	case node.NodeType == "Nested Loop":
+		sb.WriteString(node.NodeType + " " + node.JoinType + " Join")
+	default:
+		// e.g. Hash Join becomes Hash Left Join
+		sb.WriteString(strings.Replace(node.NodeType, " Join", " "+node.JoinType+" Join", 1))
+	}
+	if node.Index != "" {
+		sb.WriteString(" using " + node.Index)
+	}
+	if node.Relation != "" {
+		sb.WriteString(" on " + node.Relation)
+		if node.Alias != "" && node.Alias != node.Relation {
+			sb.WriteString(" " + node.Alias)
+		}
+	}
+	fmt.Fprintf(sb, "  (cost=%.2f..%.2f rows=%.0f width=%d)", node.StartupCost, node.TotalCost, node.PlanRows, node.PlanWidth)
+	if node.ActualLoops > 0 {
+		fmt.Fprintf(sb, " (actual time=%.3f..%.3f rows=%.0f loops=%.0f)", node.ActualStartupTimeMs, node.ActualTotalTimeMs, node.ActualRows, node.ActualLoops)
+	}
+	sb.WriteString("\n")
+	if node.Filter != "" {
+		sb.WriteString(indent + "  Filter: " + node.Filter + "\n")
+	}
+	for _, child := range node.Plans {
+		writePlanNode(sb, child, depth+1)
+	}
+}
+
+// printTable writes the result as a table with aligned columns, in the style
+// of psql.
+func printTable(w io.Writer, result *grpcdbpb.Result) error {
+	if len(result.Columns) == 0 {
+		_, err := fmt.Fprintf(w, "%d rows affected\n", result.RowsAffected)
+		return err
+	}
+	cells := make([][]string, len(result.Rows))
+	widths := make([]int, len(result.Columns))
+	for i, c := range result.Columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	for i, r := range result.Rows {
+		cells[i] = make([]string, len(r.Values))
+		for j, v := range r.Values {
+			s := formatValue(v, "NULL")
+			cells[i][j] = s
+			if j < len(widths) && utf8.RuneCountInString(s) > widths[j] {
+				widths[j] = utf8.RuneCountInString(s)
+			}
+		}
+	}
+	var sb strings.Builder
+	writeLine := func(values []string) {
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+			sb.WriteString(v)
+			if i < len(values)-1 && i < len(widths) {
+				sb.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(v)))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	writeLine(result.Columns)
+	for i, width := range widths {
+		if i > 0 {
+			sb.WriteString("-+-")
+		}
+		sb.WriteString(strings.Repeat("-", width))
+	}
+	sb.WriteString("\n")
+	for _, c := range cells {
+		writeLine(c)
+	}
+	if len(result.Rows) == 1 {
+		sb.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&sb, "(%d rows)\n", len(result.Rows))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// printCSV writes the result as CSV with a header row. NULLs are written as
+// empty fields.
+func printCSV(w io.Writer, result *grpcdbpb.Result) error {
+	cw := csv.NewWriter(w)
+	if len(result.Columns) == 0 {
+		cw.Write([]string{"rows_affected"})
+		cw.Write([]string{strconv.FormatInt(result.RowsAffected, 10)})
+	} else {
+		cw.Write(result.Columns)
+		for _, r := range result.Rows {
+			record := make([]string, len(r.Values))
+			for i, v := range r.Values {
+				record[i] = formatValue(v, "")
+			}
+			cw.Write(record)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printJSON writes the result as an array of objects, one per row, keeping the
+// columns in order.
+func printJSON(w io.Writer, result *grpcdbpb.Result) error {
+	if len(result.Columns) == 0 {
+		_, err := fmt.Fprintf(w, "{\"rows_affected\": %d}\n", result.RowsAffected)
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, r := range result.Rows {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n  {")
+		for j, v := range r.Values {
+			if j >= len(result.Columns) {
+				break
+			}
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			key, err := json.Marshal(result.Columns[j])
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				return err
+			}
+			sb.Write(key)
+			sb.WriteString(": ")
+			sb.Write(value)
+		}
+		sb.WriteString("}")
+	}
+	if len(result.Rows) > 0 {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("]\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// formatValue formats a value as text, writing NULLs as null.
+func formatValue(v *grpcdbpb.Value, null string) string {
+	switch val := v.GetValue().(type) {
+	case *grpcdbpb.Value_Str:
+		return val.Str
+	case *grpcdbpb.Value_Num:
+		return strconv.FormatFloat(val.Num, 'g', -1, 64)
+	case *grpcdbpb.Value_Int:
+		return strconv.FormatInt(val.Int, 10)
+	case *grpcdbpb.Value_Boolean:
+		return strconv.FormatBool(val.Boolean)
+	case *grpcdbpb.Value_Blob:
+		return `\x` + hex.EncodeToString(val.Blob)
+	case *grpcdbpb.Value_Time:
+		t, err := ptypes.Timestamp(val.Time)
+		if err != nil {
+			return val.Time.String()
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return null
+}
+
+// jsonValue converts a value into one encoding/json will marshal naturally.
+// Blobs are base64 encoded.
+func jsonValue(v *grpcdbpb.Value) interface{} {
+	switch val := v.GetValue().(type) {
+	case *grpcdbpb.Value_Str:
+		return val.Str
+	case *grpcdbpb.Value_Num:
+		return val.Num
+	case *grpcdbpb.Value_Int:
+		return val.Int
+	case *grpcdbpb.Value_Boolean:
+		return val.Boolean
+	case *grpcdbpb.Value_Blob:
+		return val.Blob
+	case *grpcdbpb.Value_Time:
+		return formatValue(v, "")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/GeorgeBills/grpcdb/api"
+	"strings"
+	"testing"
+)
+
+var result = &grpcdbpb.Result{
+	Columns: []string{"id", "full_name", "photo"},
+	Rows: []*grpcdbpb.ResultRow{
+		row(num(1), str("Alice"), &grpcdbpb.Value{Value: &grpcdbpb.Value_Blob{Blob: []byte{0xca, 0xfe}}}),
+		row(num(20), str("Bob, Jr."), null()),
+	},
+}
+
+func TestPrintResult(t *testing.T) {
+	table := []struct {
+		format   string
+		result   *grpcdbpb.Result
+		expected string
+	}{
+		{"table", result, `
+id | full_name | photo
+---+-----------+-------
+1  | Alice     | \xcafe
+20 | Bob, Jr.  | NULL
+(2 rows)
+`},
+		{"csv", result, `
+id,full_name,photo
+1,Alice,\xcafe
+20,"Bob, Jr.",
+`},
+		{"json", result, `
+[
+  {"id": 1, "full_name": "Alice", "photo": "yv4="},
+  {"id": 20, "full_name": "Bob, Jr.", "photo": null}
+]
+`},
+		{"table", &grpcdbpb.Result{RowsAffected: 3}, `
+3 rows affected
+`},
+		{"json", &grpcdbpb.Result{Columns: []string{"id"}}, `
+[]
+`},
+	}
+	for _, tt := range table {
+		t.Run(tt.format, func(t *testing.T) {
+			var sb strings.Builder
+			err := printResult(&sb, tt.format, tt.result)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := strings.TrimPrefix(tt.expected, "\n")
+			if sb.String() != expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", expected, sb.String())
+			}
+		})
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	plan := &grpcdbpb.ExplainResult{
+		Sql: "SELECT * FROM person JOIN country ON person.country_id = country.id WHERE full_name = 'a'",
+		Plan: &grpcdbpb.PlanNode{
+			NodeType: "Nested Loop", JoinType: "Inner", TotalCost: 16.4, PlanRows: 1, PlanWidth: 100,
+			ActualTotalTimeMs: 0.02, ActualRows: 1, ActualLoops: 1,
+			Plans: []*grpcdbpb.PlanNode{
+				{NodeType: "Seq Scan", Relation: "person", Alias: "person", Filter: "((full_name)::text = 'a'::text)", TotalCost: 8.1, PlanRows: 1, PlanWidth: 68, ActualLoops: 1, ActualRows: 1, ActualTotalTimeMs: 0.01},
+				{NodeType: "Index Scan", Index: "country_pkey", Relation: "country", Alias: "c", StartupCost: 0.15, TotalCost: 8.17, PlanRows: 1, PlanWidth: 32, ActualLoops: 1},
+			},
+		},
+		PlanningTimeMs:  0.25,
+		ExecutionTimeMs: 0.05,
+	}
+	expected := `SELECT * FROM person JOIN country ON person.country_id = country.id WHERE full_name = 'a'
+Nested Loop  (cost=0.00..16.40 rows=1 width=100) (actual time=0.000..0.020 rows=1 loops=1)
+  ->  Seq Scan on person  (cost=0.00..8.10 rows=1 width=68) (actual time=0.000..0.010 rows=1 loops=1)
+        Filter: ((full_name)::text = 'a'::text)
+  ->  Index Scan using country_pkey on country c  (cost=0.15..8.17 rows=1 width=32) (actual time=0.000..0.000 rows=0 loops=1)
+Planning Time: 0.250 ms
+Execution Time: 0.050 ms
+`
+	var sb strings.Builder
+	err := printPlan(&sb, plan)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, sb.String())
+	}
+}
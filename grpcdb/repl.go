@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/client"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	prompt       = "grpcdb> "
+	continuation = "   ...> "
+	historySize  = 500
+)
+
+const help = `Statements end with a semicolon and may span several lines. They're written
+either as JSON encoded Statement messages or in SQL-like text:
+
+  select full_name from person where birth > '2000-01-01' order by birth desc;
+
+Commands:
+
+  \describe [table]         list tables, or describe a table's columns
+  \format table|csv|json    set the output format
+  \explain on|off           print query plans instead of running statements
+  \history                  show statement history
+  \help                     show this help
+  \quit                     exit
+`
+
+// repl reads statements and commands, sends them to the server and prints the
+// results.
+type repl struct {
+	client      *client.Client
+	out         io.Writer
+	format      string
+	explain     bool
+	history     []string
+	historyFile *os.File
+}
+
+// loop reads input until EOF or \quit. Errors are printed and don't end the
+// loop.
+func (r *repl) loop(in io.Reader, interactive bool) {
+	scanner := bufio.NewScanner(in)
+	var buf strings.Builder
+	for {
+		if interactive {
+			if buf.Len() == 0 {
+				fmt.Fprint(r.out, prompt)
+			} else {
+				fmt.Fprint(r.out, continuation)
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if buf.Len() == 0 && (line == "" || strings.HasPrefix(line, "--")) {
+			continue
+		}
+		if buf.Len() == 0 && strings.HasPrefix(line, `\`) {
+			if line == `\quit` || line == `\q` {
+				return
+			}
+			r.addHistory(line)
+			r.printError(r.run(line))
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(line)
+		if strings.HasSuffix(line, ";") {
+			input := buf.String()
+			buf.Reset()
+			r.addHistory(input)
+			r.printError(r.run(strings.TrimSuffix(input, ";")))
+		}
+	}
+	if interactive {
+		fmt.Fprintln(r.out)
+	}
+}
+
+func (r *repl) printError(err error) {
+	if err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+	}
+}
+
+// run runs a single statement or command.
+func (r *repl) run(input string) error {
+	if strings.HasPrefix(input, `\`) {
+		fields := strings.Fields(input)
+		return r.command(fields[0], fields[1:])
+	}
+	statement, err := parseStatement(input)
+	if err != nil {
+		return err
+	}
+	if r.explain {
+		plan, err := r.client.GRPCDBClient().Explain(context.Background(), &grpcdbpb.ExplainRequest{Statement: statement})
+		if err != nil {
+			return err
+		}
+		return printPlan(r.out, plan)
+	}
+	result, err := r.client.QueryStatement(context.Background(), statement)
+	if err != nil {
+		return err
+	}
+	return printResult(r.out, r.format, result)
+}
+
+func (r *repl) command(name string, args []string) error {
+	switch name {
+	case `\describe`, `\d`:
+		if len(args) > 1 {
+			return fmt.Errorf("Usage: \\describe [table]")
+		}
+		schema, err := r.client.DescribeSchema(context.Background())
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return printResult(r.out, r.format, describeTables(schema))
+		}
+		result, err := describeTable(schema, args[0])
+		if err != nil {
+			return err
+		}
+		return printResult(r.out, r.format, result)
+	case `\format`:
+		if len(args) != 1 || !validFormat(args[0]) {
+			return fmt.Errorf("Usage: \\format table|csv|json")
+		}
+		r.format = args[0]
+	case `\explain`:
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return fmt.Errorf("Usage: \\explain on|off")
+		}
+		r.explain = args[0] == "on"
+	case `\history`:
+		for _, h := range r.history {
+			fmt.Fprintln(r.out, h)
+		}
+	case `\help`, `\?`:
+		fmt.Fprint(r.out, help)
+	default:
+		return fmt.Errorf("Unrecognized command %s; try \\help", name)
+	}
+	return nil
+}
+
+// openHistory loads previous history from filename and appends new entries to
+// it. Multi-line statements are stored on a single line.
+func (r *repl) openHistory(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+	if len(r.history) > historySize {
+		r.history = r.history[len(r.history)-historySize:]
+	}
+	r.historyFile, err = os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return err
+}
+
+func (r *repl) closeHistory() {
+	if r.historyFile != nil {
+		r.historyFile.Close()
+	}
+}
+
+func (r *repl) addHistory(input string) {
+	entry := strings.Join(strings.Fields(input), " ")
+	r.history = append(r.history, entry)
+	if r.historyFile != nil {
+		fmt.Fprintln(r.historyFile, entry)
+	}
+}
+
+// describeTables lists every table in the schema.
+func describeTables(schema *grpcdbpb.DatabaseSchema) *grpcdbpb.Result {
+	result := &grpcdbpb.Result{Columns: []string{"schema", "table", "columns"}}
+	for _, s := range schema.Schemas {
+		for _, t := range s.Tables {
+			result.Rows = append(result.Rows, row(str(s.Name), str(t.Name), num(int64(len(t.Columns)))))
+		}
+	}
+	return result
+}
+
+// describeTable lists the columns of the named table, which may be qualified
+// with its schema.
+func describeTable(schema *grpcdbpb.DatabaseSchema, name string) (*grpcdbpb.Result, error) {
+	schemaName, tableName := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		schemaName, tableName = name[:i], name[i+1:]
+	}
+	var table *grpcdbpb.Table
+	for _, s := range schema.Schemas {
+		if schemaName != "" && s.Name != schemaName {
+			continue
+		}
+		for _, t := range s.Tables {
+			if t.Name != tableName {
+				continue
+			}
+			if table != nil {
+				return nil, fmt.Errorf("Table %s is ambiguous; qualify it with a schema", name)
+			}
+			table = t
+		}
+	}
+	if table == nil {
+		return nil, fmt.Errorf("Table %s not found", name)
+	}
+	primaryKey := make(map[string]bool)
+	for _, c := range table.PrimaryKey {
+		primaryKey[c] = true
+	}
+	references := make(map[string]string)
+	for _, fk := range table.ForeignKeys {
+		for i, c := range fk.Columns {
+			ref := fk.References.GetTable()
+			if i < len(fk.ReferencedColumns) {
+				ref += "." + fk.ReferencedColumns[i]
+			}
+			references[c] = ref
+		}
+	}
+	result := &grpcdbpb.Result{Columns: []string{"column", "type", "nullable", "default", "primary_key", "references"}}
+	for _, c := range table.Columns {
+		def := null()
+		if c.HasDefault {
+			def = str(c.Default)
+		}
+		ref := null()
+		if r, ok := references[c.Name]; ok {
+			ref = str(r)
+		}
+		result.Rows = append(result.Rows, row(str(c.Name), str(c.Type), boolean(c.Nullable), def, boolean(primaryKey[c.Name]), ref))
+	}
+	return result, nil
+}
+
+func row(values ...*grpcdbpb.Value) *grpcdbpb.ResultRow {
+	return &grpcdbpb.ResultRow{Values: values}
+}
+
+func str(s string) *grpcdbpb.Value {
+	return &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: s}}
+}
+
+func num(i int64) *grpcdbpb.Value {
+	return &grpcdbpb.Value{Value: &grpcdbpb.Value_Int{Int: i}}
+}
+
+func boolean(b bool) *grpcdbpb.Value {
+	return &grpcdbpb.Value{Value: &grpcdbpb.Value_Boolean{Boolean: b}}
+}
+
+func null() *grpcdbpb.Value {
+	return &grpcdbpb.Value{Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/client"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseStatement(t *testing.T) {
+	selectStatement := func(from string, limit uint64, columns ...string) *grpcdbpb.Statement {
+		return &grpcdbpb.Statement{Statement: &grpcdbpb.Statement_Select{Select: &grpcdbpb.Select{
+			ResultColumn: columns,
+			From:         from,
+			Limit:        limit,
+		}}}
+	}
+	table := []struct {
+		input    string
+		expected *grpcdbpb.Statement
+	}{
+		{"select id, full_name from person", selectStatement("person", 0, "id", "full_name")},
+		{"SELECT *\nFROM public.person\nLIMIT 10", selectStatement("public.person", 10, "*")},
+		{`{"select": {"resultColumn": ["id"], "from": "person"}}`, selectStatement("person", 0, "id")},
+		{"select from person", nil},
+		{"delete from person where", nil},
+		{`{"select": 1}`, nil},
+	}
+	for _, tt := range table {
+		t.Run(tt.input, func(t *testing.T) {
+			statement, err := parseStatement(tt.input)
+			if tt.expected == nil {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", statement)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(statement, tt.expected) {
+				t.Errorf("Expected: %v\nActual: %v", tt.expected, statement)
+			}
+		})
+	}
+}
+
+// explainServer explains statements with a fixed plan, translated as the server
+// would without a policy.
+type explainServer struct{}
+
+func (explainServer) Query(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.Result, error) {
+	return &grpcdbpb.Result{}, nil
+}
+
+func (explainServer) DescribeSchema(ctx context.Context, req *grpcdbpb.DescribeSchemaRequest) (*grpcdbpb.DatabaseSchema, error) {
+	return &grpcdbpb.DatabaseSchema{}, nil
+}
+
+func (explainServer) Translate(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.TranslatedStatement, error) {
+	return &grpcdbpb.TranslatedStatement{}, nil
+}
+
+func (explainServer) Prepare(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.PreparedHandle, error) {
+	return &grpcdbpb.PreparedHandle{}, nil
+}
+
+func (explainServer) ExecutePrepared(ctx context.Context, req *grpcdbpb.ExecutePreparedRequest) (*grpcdbpb.Result, error) {
+	return &grpcdbpb.Result{}, nil
+}
+
+func (explainServer) Explain(ctx context.Context, req *grpcdbpb.ExplainRequest) (*grpcdbpb.ExplainResult, error) {
+	sql, err := grpcdb.TranslateStatement(req.Statement)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcdbpb.ExplainResult{Sql: sql, Plan: &grpcdbpb.PlanNode{
+		NodeType:  "Limit",
+		TotalCost: 0.1,
+		PlanRows:  5,
+		PlanWidth: 36,
+		Plans:     []*grpcdbpb.PlanNode{{NodeType: "Seq Scan", Relation: "person", TotalCost: 22.7, PlanRows: 1270, PlanWidth: 36}},
+	}}, nil
+}
+
+func TestLoop(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	gs := grpc.NewServer()
+	grpcdbpb.RegisterGRPCDBServer(gs, explainServer{})
+	go gs.Serve(lis)
+	defer gs.Stop()
+	c, err := client.Dial(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	input := `
+-- statements may span lines
+select id,
+  full_name
+from person limit 5;
+\format csv
+\format xml
+\history
+\quit
+select id from person;
+`
+	var sb strings.Builder
+	r := &repl{client: c, out: &sb, format: "table", explain: true}
+	r.loop(strings.NewReader(input), false)
+	expected := `SELECT id, full_name FROM person LIMIT 5
+Limit  (cost=0.00..0.10 rows=5 width=36)
+  ->  Seq Scan on person  (cost=0.00..22.70 rows=1270 width=36)
+Error: Usage: \format table|csv|json
+select id, full_name from person limit 5;
+\format csv
+\format xml
+\history
+`
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, sb.String())
+	}
+	if r.format != "csv" {
+		t.Errorf("Expected format to be csv, got: %s", r.format)
+	}
+}
+
+func TestDescribeTable(t *testing.T) {
+	schema := &grpcdbpb.DatabaseSchema{Schemas: []*grpcdbpb.Schema{{
+		Name: "public",
+		Tables: []*grpcdbpb.Table{{
+			Name: "person",
+			Columns: []*grpcdbpb.Column{
+				{Name: "id", Type: "uuid"},
+				{Name: "country_id", Type: "integer", Nullable: true, HasDefault: true, Default: "1"},
+			},
+			PrimaryKey: []string{"id"},
+			ForeignKeys: []*grpcdbpb.ForeignKey{{
+				Columns:           []string{"country_id"},
+				References:        &grpcdbpb.SchemaTable{Schema: "public", Table: "country"},
+				ReferencedColumns: []string{"id"},
+			}},
+		}},
+	}}}
+	result, err := describeTable(schema, "public.person")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var sb strings.Builder
+	printResult(&sb, "table", result)
+	expected := `column     | type    | nullable | default | primary_key | references
+-----------+---------+----------+---------+-------------+-----------
+id         | uuid    | false    | NULL    | true        | NULL
+country_id | integer | true     | 1       | false       | country.id
+(2 rows)
+`
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, sb.String())
+	}
+	_, err = describeTable(schema, "other.person")
+	if err == nil {
+		t.Errorf("Expected an error describing a missing table")
+	}
+}
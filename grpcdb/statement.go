@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/jsonpb"
+	"strings"
+)
+
+// parseStatement parses a statement written either as a JSON encoded
+// Statement message or in the text form accepted by query.Parse.
+func parseStatement(input string) (*grpcdbpb.Statement, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, "{") {
+		statement := &grpcdbpb.Statement{}
+		err := jsonpb.UnmarshalString(input, statement)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JSON statement: %v", err)
+		}
+		return statement, nil
+	}
+	return query.Parse(input)
+}
@@ -1,12 +1,25 @@
 package grpcdb_test
 
 import (
+	"database/sql"
 	"github.com/GeorgeBills/grpcdb"
 	pb "github.com/GeorgeBills/grpcdb/api"
 	. "github.com/GeorgeBills/grpcdb/builder"
+	_ "github.com/mattn/go-sqlite3"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// sqliteIncompatible lists golden tests whose SQL is valid for PostgreSQL but
+// which SQLite's parser will reject, along with the reason why.
+var sqliteIncompatible = map[string]string{
+	"OFFSET":         "SQLite requires LIMIT before OFFSET",
+	"array literals": "SQLite has no arrays",
+}
+
 func TestTranslation(t *testing.T) {
 	table := []struct {
 		name             string
@@ -56,7 +69,7 @@ func TestTranslation(t *testing.T) {
 		},
 		{
 			"big SELECT",
-			`SELECT x, y, z FROM t1 JOIN t2 ON t1.a = t2.b WHERE c > 3 AND d IS NOT NULL ORDER BY e ASC GROUP BY f, g`,
+			`SELECT x, y, z FROM t1 JOIN t2 ON t1.a = t2.b WHERE c > 3 AND d IS NOT NULL GROUP BY f, g ORDER BY e ASC`,
 			Select("t1", "x", "y", "z").
 				JoinEq("t2", TableCol("t1", "a"), TableCol("t2", "b")).
 				Where(GT(Col("c"), Num(3))).
@@ -70,6 +83,24 @@ func TestTranslation(t *testing.T) {
 			Select("t", "x").
 				OrderBy(Col("y"), pb.OrderingDirection_DESC),
 		},
+		{
+			"ORDER BY (multiple terms)",
+			"SELECT x FROM t ORDER BY y DESC, z ASC",
+			Select("t", "x").
+				OrderBy(Col("y"), pb.OrderingDirection_DESC).
+				OrderBy(Col("z"), pb.OrderingDirection_ASC),
+		},
+		{
+			"clause ordering",
+			"SELECT x FROM t WHERE a > 0 GROUP BY b HAVING c = 1 ORDER BY d ASC LIMIT 5 OFFSET 10",
+			Select("t", "x").
+				Offset(10).
+				Limit(5).
+				OrderBy(Col("d"), pb.OrderingDirection_ASC).
+				GroupBy(Col("b")).
+				Having(Eq(Col("c"), Num(1))).
+				Where(GT(Col("a"), Num(0))),
+		},
 		{
 			"LIMIT",
 			"SELECT x FROM t LIMIT 123",
@@ -107,13 +138,23 @@ func TestTranslation(t *testing.T) {
 			"INSERT INTO (single row)",
 			"INSERT INTO t (x, y, z) VALUES (1, 2, 3)",
 			Insert(Table("t"), "x", "y", "z").
-				Values([][]string{{"1", "2", "3"}}),
+				Values([]*pb.Expr{Int(1), Int(2), Int(3)}),
 		},
 		{
 			"INSERT INTO (multiple rows)",
-			"INSERT INTO t (x, y) VALUES (1, 2), (3, 4)",
+			"INSERT INTO t (x, y) VALUES (1, 'a'), (NULL, CURRENT_TIMESTAMP)",
 			Insert(Table("t"), "x", "y").
-				Values([][]string{{"1", "2"}, {"3", "4"}}),
+				Values([]*pb.Expr{Num(1), Str("a")}).
+				Values([]*pb.Expr{Null(), CurrentTimestamp()}),
+		},
+		{
+			"INSERT INTO (value maps)",
+			"INSERT INTO t (a, b, c, d, e) VALUES (1, 'x', NULL, true, 2.5), (-2, '', NULL, false, CURRENT_DATE)",
+			Insert(Table("t"), "a", "b", "c", "d", "e").
+				ValueMaps(
+					map[string]interface{}{"a": 1, "b": "x", "c": nil, "d": true, "e": 2.5},
+					map[string]interface{}{"a": int8(-2), "b": new(string), "c": (*int)(nil), "d": false, "e": CurrentDate()},
+				),
 		},
 		{
 			"INSERT INTO SELECT FROM",
@@ -132,6 +173,31 @@ func TestTranslation(t *testing.T) {
 			Delete(Table("t")).
 				Where(Not(LTE(Col("x"), Num(0)))),
 		},
+		{
+			"negated negative literals",
+			"SELECT a FROM t WHERE b = -(-5) AND c = -(-1) AND d = -(-0) AND e = -(-1.5) AND f = -5",
+			Select("t", "a").
+				Where(Eq(Col("b"), neg(Int(-5)))).
+				Where(Eq(Col("c"), neg(Decimal("-1")))).
+				Where(Eq(Col("d"), neg(Num(math.Copysign(0, -1))))).
+				Where(Eq(Col("e"), neg(Num(-1.5)))).
+				Where(Eq(Col("f"), neg(Int(5)))),
+		},
+		{
+			"typed columns",
+			"SELECT a FROM t WHERE t.i > 9007199254740993 AND t.d = 0.10 AND t.ts < CAST('2000-01-02T03:04:05Z' AS timestamptz) AND t.day = CAST('2000-01-02' AS date)",
+			Select("t", "a").
+				Where(IntColumn{Column: Column{Table: "t", Column: "i"}}.GT(9007199254740993)).
+				Where(DecimalColumn{Column: Column{Table: "t", Column: "d"}}.Eq("0.10")).
+				Where(TimeColumn{Column: Column{Table: "t", Column: "ts"}}.LT(time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC))).
+				Where(DateColumn{Column: Column{Table: "t", Column: "day"}}.Eq(time.Date(2000, 1, 2, 23, 0, 0, 0, time.UTC))),
+		},
+		{
+			"parenthesised expressions",
+			"DELETE FROM t WHERE (a = 1 OR b = 2) AND NOT (c AND d) AND (e = f) = false",
+			Delete(Table("t")).
+				Where(And(And(Or(Eq(Col("a"), Num(1)), Eq(Col("b"), Num(2))), Not(And(Col("c"), Col("d")))), Eq(Eq(Col("e"), Col("f")), Bool(false)))),
+		},
 		{
 			"UPDATE",
 			"UPDATE t SET a = b, c = d",
@@ -148,7 +214,55 @@ func TestTranslation(t *testing.T) {
 				Set("c", Num(2)).
 				Where(GTE(Col("d"), Num(3))),
 		},
+		{
+			"string literals",
+			"SELECT a FROM t WHERE b = 'it''s' OR c = ''",
+			Select("t", "a").
+				Where(Or(Eq(Col("b"), Str("it's")), Eq(Col("c"), Str("")))),
+		},
+		{
+			"typed literals",
+			"SELECT a FROM t WHERE b = -42 AND c > CAST('2000-01-02T03:04:05.5Z' AS timestamptz) AND d = CAST('1999-12-31' AS date) AND e = CAST('123e4567-e89b-12d3-a456-426614174000' AS uuid) AND f = -12.50 AND g = CAST('{\"k\": [1, \"it''s\"]}' AS jsonb)",
+			Select("t", "a").
+				Where(Eq(Col("b"), Int(-42))).
+				Where(GT(Col("c"), Time(time.Date(2000, 1, 2, 3, 4, 5, 5e8, time.UTC)))).
+				Where(Eq(Col("d"), Date(1999, time.December, 31))).
+				Where(Eq(Col("e"), UUID("123e4567-e89b-12d3-a456-426614174000"))).
+				Where(Eq(Col("f"), Decimal("-12.50"))).
+				Where(Eq(Col("g"), JSON(`{"k": [1, "it's"]}`))),
+		},
+		{
+			"blob literals",
+			`SELECT a FROM t WHERE b = CAST('\xcafe' AS bytea) OR c = CAST('\x' AS bytea)`,
+			Select("t", "a").
+				Where(Or(Eq(Col("b"), Blob([]byte{0xca, 0xfe})), Eq(Col("c"), Blob(nil)))),
+		},
+		{
+			"current time literals",
+			"UPDATE t SET a = CURRENT_TIME, b = CURRENT_DATE WHERE c < CURRENT_TIMESTAMP",
+			Update(Table("t")).
+				Set("a", CurrentTime()).
+				Set("b", CurrentDate()).
+				Where(LT(Col("c"), CurrentTimestamp())),
+		},
+		{
+			"array literals",
+			"SELECT a FROM t WHERE b = ARRAY[1, 2] OR c = ARRAY[ARRAY['x'], ARRAY[]]",
+			Select("t", "a").
+				Where(Or(Eq(Col("b"), Array(Int(1), Int(2))), Eq(Col("c"), Array(Array(Str("x")), Array())))),
+		},
+		{
+			"parameters",
+			"SELECT * FROM person WHERE id = $1 OR manager_id = $1 AND birth > $2",
+			Select("person", "*").
+				Where(Or(Eq(Col("id"), Param("id")), And(Eq(Col("manager_id"), Param("id")), GT(Col("birth"), Param("born_after"))))),
+		},
 	}
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Couldn't open SQLite database: %v", err)
+	}
+	defer db.Close()
 	for _, tt := range table {
 		t.Run(tt.name, func(t *testing.T) {
 			statement, err := tt.statementBuilder.Statement()
@@ -162,6 +276,118 @@ func TestTranslation(t *testing.T) {
 			if result != tt.sql {
 				t.Errorf("Expected: '%s'\nActual: '%s'\nStatement: %#v", tt.sql, result, statement)
 			}
+			if reason, ok := sqliteIncompatible[tt.name]; ok {
+				t.Logf("Skipping syntax check: %s", reason)
+				return
+			}
+			checkSyntax(t, db, result)
 		})
 	}
 }
+
+func neg(e *pb.Expr) *pb.Expr {
+	return &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: pb.UnaryOp_NEG, Expr: e}}}
+}
+
+func TestInsertValuesErrors(t *testing.T) {
+	table := []struct {
+		name     string
+		builder  StatementBuilder
+		expected string
+	}{
+		{
+			"row width",
+			Insert(Table("t"), "a", "b").Values([]*pb.Expr{Num(1), Num(2)}, []*pb.Expr{Num(3)}),
+			"row 1 has 1 values, but 2 columns are being inserted",
+		},
+		{
+			"missing column",
+			Insert(Table("t"), "a", "b").ValueMaps(map[string]interface{}{"a": 1}),
+			"row has no value for column b",
+		},
+		{
+			"extra column",
+			Insert(Table("t"), "a").ValueMaps(map[string]interface{}{"a": 1, "b": 2}),
+			"row has a value for column b, which isn't being inserted",
+		},
+		{
+			"unsupported value",
+			Insert(Table("t"), "a").ValueMaps(map[string]interface{}{"a": struct{}{}}),
+			"column a: no literal for struct {}",
+		},
+		{
+			"unsigned overflow",
+			Insert(Table("t"), "a").ValueMaps(map[string]interface{}{"a": uint64(1 << 63)}),
+			"column a: 9223372036854775808 is too large for an integer literal",
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.Statement()
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Expected: %s\nActual: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestTranslateWithParams(t *testing.T) {
+	statement, err := Update(Table("person")).
+		Set("full_name", Param("name")).
+		Where(Or(Eq(Col("id"), Param("id")), Eq(Col("manager_id"), TypedParam("id", pb.ParamType_INTEGER)))).
+		Statement()
+	if err != nil {
+		t.Fatalf("Couldn't build statement: %v", err)
+	}
+	sql, params, err := grpcdb.TranslateWithParams(statement)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sql != "UPDATE person SET full_name = $1 WHERE id = $2 OR manager_id = $2" {
+		t.Errorf("Unexpected translation: %s", sql)
+	}
+	expected := []*pb.Param{{Name: "name"}, {Name: "id", Type: pb.ParamType_INTEGER}}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, params)
+	}
+	if param := statement.GetUpdate().Where.GetBinaryExpr().Expr1.GetBinaryExpr().Expr2.GetParam(); param.Type != pb.ParamType_ANY {
+		t.Errorf("Expected the statement to be left unchanged, got: %v", param)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	statement := &pb.Statement{Statement: &pb.Statement_Delete{Delete: &pb.Delete{
+		From:  Table("t"),
+		Where: Eq(Col("a"), nil),
+	}}}
+	_, err := grpcdb.TranslateStatement(statement)
+	expected := "Error translating statement DELETE FROM t WHERE a = <nil>: expression was nil"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected: %s\nActual: %v", expected, err)
+	}
+
+	// literals written into the SQL as is are checked even without Validate
+	for _, lit := range []*pb.Expr{Decimal("1; DROP TABLE t"), Num(math.NaN())} {
+		statement := mustStatement(t, Delete(Table("t")).Where(Eq(Col("a"), lit)))
+		sql, err := grpcdb.TranslateStatement(statement)
+		if err == nil {
+			t.Errorf("Expected an error, got: %s", sql)
+		}
+	}
+}
+
+// checkSyntax fails the test if SQLite can't parse sql. The tables referenced
+// by the golden tests don't exist, so errors other than syntax errors (e.g. "no
+// such table") are expected and ignored.
+func checkSyntax(t *testing.T, db *sql.DB, sql string) {
+	t.Helper()
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		msg := err.Error()
+		if strings.Contains(msg, "syntax error") || strings.Contains(msg, "incomplete input") {
+			t.Errorf("Invalid SQL '%s': %v", sql, err)
+		}
+		return
+	}
+	stmt.Close()
+}
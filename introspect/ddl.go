@@ -0,0 +1,494 @@
+package introspect
+
+import (
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"strings"
+	"unicode"
+)
+
+// defaultSchema is the schema that unqualified names in a DDL file belong to.
+const defaultSchema = "public"
+
+// token is a single lexical token in a DDL file.
+type token struct {
+	text   string
+	quoted bool // quoted identifier or string literal; keywords are never quoted
+	pos    int
+}
+
+// is reports whether the token is the given (case insensitive) keyword or
+// punctuation.
+func (t token) is(s string) bool {
+	return !t.quoted && strings.EqualFold(t.text, s)
+}
+
+// tokenize splits a DDL file into identifiers, keywords, string literals and
+// punctuation, dropping whitespace and comments. String literals keep their
+// quotes so that column defaults can be reproduced as written.
+func tokenize(ddl string) ([]token, error) {
+	var tokens []token
+	runes := []rune(ddl)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			start := i
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("unterminated comment at %d", start)
+			}
+			i += 2
+		case r == '\'' || r == '"':
+			start := i
+			i++
+			var sb strings.Builder
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated quote at %d", start)
+				}
+				if runes[i] == r {
+					// doubled quotes are escaped quotes
+					if i+1 < len(runes) && runes[i+1] == r {
+						sb.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			text := sb.String()
+			if r == '\'' {
+				text = string(runes[start:i])
+			}
+			tokens = append(tokens, token{text: text, quoted: true, pos: start})
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{text: string(runes[start:i]), pos: start})
+		case r == ':' && i+1 < len(runes) && runes[i+1] == ':':
+			tokens = append(tokens, token{text: "::", pos: i})
+			i += 2
+		default:
+			tokens = append(tokens, token{text: string(r), pos: i})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// ddlParser reads CREATE TYPE ... AS ENUM, CREATE TABLE and CREATE INDEX
+// statements into a schema description, skipping any other statements.
+type ddlParser struct {
+	tokens []token
+	i      int
+	c      *collector
+}
+
+// ParseDDL describes the schema created by a file of SQL DDL statements, such
+// as testdata/database.sql. Enum types, tables, primary and foreign keys and
+// indexes are read; any other statements are ignored.
+func ParseDDL(ddl string) (*pb.DatabaseSchema, error) {
+	tokens, err := tokenize(ddl)
+	if err != nil {
+		return nil, err
+	}
+	p := &ddlParser{tokens: tokens, c: newCollector()}
+	for !p.eof() {
+		err = p.statement()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.c.schema, nil
+}
+
+func (p *ddlParser) eof() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *ddlParser) peek() token {
+	if p.eof() {
+		return token{pos: -1}
+	}
+	return p.tokens[p.i]
+}
+
+func (p *ddlParser) next() token {
+	t := p.peek()
+	p.i++
+	return t
+}
+
+// accept consumes the next tokens if they match the given keywords.
+func (p *ddlParser) accept(keywords ...string) bool {
+	if p.i+len(keywords) > len(p.tokens) {
+		return false
+	}
+	for j, k := range keywords {
+		if !p.tokens[p.i+j].is(k) {
+			return false
+		}
+	}
+	p.i += len(keywords)
+	return true
+}
+
+func (p *ddlParser) expect(keywords ...string) error {
+	if !p.accept(keywords...) {
+		t := p.peek()
+		if t.pos < 0 {
+			return fmt.Errorf("expected %s but reached end of file", strings.Join(keywords, " "))
+		}
+		return fmt.Errorf("expected %s at %d but got %q", strings.Join(keywords, " "), t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *ddlParser) ident() (string, error) {
+	t := p.next()
+	if t.pos < 0 {
+		return "", fmt.Errorf("expected identifier but reached end of file")
+	}
+	if !t.quoted && !isIdentStart(t.text) {
+		return "", fmt.Errorf("expected identifier at %d but got %q", t.pos, t.text)
+	}
+	if t.quoted {
+		return t.text, nil
+	}
+	return strings.ToLower(t.text), nil
+}
+
+func isIdentStart(s string) bool {
+	r := []rune(s)[0]
+	return unicode.IsLetter(r) || r == '_'
+}
+
+// qualifiedName reads "name" or "schema.name".
+func (p *ddlParser) qualifiedName() (string, string, error) {
+	name, err := p.ident()
+	if err != nil {
+		return "", "", err
+	}
+	if !p.accept(".") {
+		return defaultSchema, name, nil
+	}
+	table, err := p.ident()
+	return name, table, err
+}
+
+// identList reads a parenthesised, comma separated list of identifiers.
+func (p *ddlParser) identList() ([]string, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+	var idents []string
+	for {
+		ident, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		idents = append(idents, ident)
+		// skip ASC, DESC, COLLATE etc. in index definitions
+		p.skipUntil(",", ")")
+		if p.accept(")") {
+			return idents, nil
+		}
+		err = p.expect(",")
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipUntil skips tokens up to, but not including, the first of stops which
+// isn't nested inside parentheses, and returns the skipped tokens.
+func (p *ddlParser) skipUntil(stops ...string) []token {
+	start := p.i
+	depth := 0
+	for !p.eof() {
+		t := p.peek()
+		if depth == 0 {
+			for _, stop := range stops {
+				if t.is(stop) {
+					return p.tokens[start:p.i]
+				}
+			}
+		}
+		if t.is("(") {
+			depth++
+		} else if t.is(")") {
+			depth--
+		}
+		p.i++
+	}
+	return p.tokens[start:p.i]
+}
+
+func (p *ddlParser) statement() error {
+	switch {
+	case p.accept(";"):
+		return nil
+	case p.accept("CREATE", "TYPE"):
+		return p.createType()
+	case p.accept("CREATE", "TABLE"):
+		return p.createTable()
+	case p.accept("CREATE", "UNIQUE", "INDEX"):
+		return p.createIndex(true)
+	case p.accept("CREATE", "INDEX"):
+		return p.createIndex(false)
+	}
+	p.skipUntil(";")
+	return nil
+}
+
+func (p *ddlParser) createType() error {
+	schema, name, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	if !p.accept("AS", "ENUM") {
+		// composite and range types aren't of interest
+		p.skipUntil(";")
+		return nil
+	}
+	err = p.expect("(")
+	if err != nil {
+		return err
+	}
+	e := p.c.getEnum(schema, name)
+	for {
+		t := p.next()
+		if !t.quoted || !strings.HasPrefix(t.text, "'") {
+			return fmt.Errorf("expected enum label at %d but got %q", t.pos, t.text)
+		}
+		e.Values = append(e.Values, unquote(t.text))
+		if p.accept(")") {
+			return nil
+		}
+		err = p.expect(",")
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unquote removes the quotes from a string literal and unescapes doubled
+// quotes.
+func unquote(s string) string {
+	return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+}
+
+// columnConstraints are the keywords which end a column's type.
+var columnConstraints = []string{
+	",", ")", "CONSTRAINT", "PRIMARY", "NOT", "NULL", "DEFAULT", "REFERENCES",
+	"UNIQUE", "CHECK", "COLLATE", "GENERATED",
+}
+
+func (p *ddlParser) createTable() error {
+	p.accept("IF", "NOT", "EXISTS")
+	schema, name, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	t := p.c.getTable(schema, name)
+	err = p.expect("(")
+	if err != nil {
+		return err
+	}
+	for {
+		err = p.tableElement(schema, t)
+		if err != nil {
+			return err
+		}
+		if p.accept(")") {
+			break
+		}
+		err = p.expect(",")
+		if err != nil {
+			return err
+		}
+	}
+	// primary key columns are implicitly not null
+	for _, col := range t.Columns {
+		for _, pk := range t.PrimaryKey {
+			if col.Name == pk {
+				col.Nullable = false
+			}
+		}
+	}
+	p.skipUntil(";")
+	return nil
+}
+
+// tableElement reads either a column definition or a table constraint.
+func (p *ddlParser) tableElement(schema string, t *pb.Table) error {
+	constraint := ""
+	if p.accept("CONSTRAINT") {
+		var err error
+		constraint, err = p.ident()
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case p.accept("PRIMARY", "KEY"):
+		cols, err := p.identList()
+		t.PrimaryKey = cols
+		return err
+	case p.accept("FOREIGN", "KEY"):
+		cols, err := p.identList()
+		if err != nil {
+			return err
+		}
+		err = p.expect("REFERENCES")
+		if err != nil {
+			return err
+		}
+		return p.references(t, constraint, cols)
+	case p.accept("UNIQUE"), p.accept("CHECK"), p.accept("EXCLUDE"):
+		p.skipUntil(",", ")")
+		return nil
+	}
+	return p.column(schema, t)
+}
+
+func (p *ddlParser) column(schema string, t *pb.Table) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	var typ []string
+	for _, tok := range p.skipUntil(columnConstraints...) {
+		typ = append(typ, tok.text)
+	}
+	if len(typ) == 0 {
+		return fmt.Errorf("expected type for column %s", name)
+	}
+	col := &pb.Column{Name: name, Type: formatType(typ), Nullable: true}
+	t.Columns = append(t.Columns, col)
+	for {
+		switch {
+		case p.accept("CONSTRAINT"):
+			_, err = p.ident()
+			if err != nil {
+				return err
+			}
+		case p.accept("PRIMARY", "KEY"):
+			t.PrimaryKey = []string{name}
+		case p.accept("NOT", "NULL"):
+			col.Nullable = false
+		case p.accept("NULL"):
+			col.Nullable = true
+		case p.accept("DEFAULT"):
+			var def []string
+			for _, tok := range p.skipUntil(columnConstraints...) {
+				def = append(def, tok.text)
+			}
+			col.HasDefault, col.Default = true, strings.Join(def, " ")
+		case p.accept("REFERENCES"):
+			err = p.references(t, "", []string{name})
+			if err != nil {
+				return err
+			}
+		case p.accept("UNIQUE"), p.accept("CHECK"), p.accept("COLLATE"), p.accept("GENERATED"):
+			p.skipUntil(columnConstraints...)
+		default:
+			return nil
+		}
+	}
+}
+
+// formatType joins the tokens of a type back together, e.g. "varchar ( 20 )"
+// becomes "varchar(20)".
+func formatType(tokens []string) string {
+	var sb strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && tok != "(" && tok != ")" && tok != "," && tok != "[" && tok != "]" && tokens[i-1] != "(" && tokens[i-1] != "[" {
+			sb.WriteString(" ")
+		}
+		if tok == "," {
+			tok = ", "
+		}
+		sb.WriteString(strings.ToLower(tok))
+	}
+	return strings.Replace(sb.String(), ",  ", ", ", -1)
+}
+
+func (p *ddlParser) references(t *pb.Table, name string, cols []string) error {
+	schema, table, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	fk := &pb.ForeignKey{
+		Name:       name,
+		Columns:    cols,
+		References: &pb.SchemaTable{Schema: schema, Table: table},
+	}
+	if p.peek().is("(") {
+		fk.ReferencedColumns, err = p.identList()
+		if err != nil {
+			return err
+		}
+	}
+	t.ForeignKeys = append(t.ForeignKeys, fk)
+	// referential actions aren't of interest
+	for {
+		switch {
+		case p.accept("MATCH"):
+			p.next()
+		case p.accept("ON", "DELETE"), p.accept("ON", "UPDATE"):
+			switch {
+			case p.accept("NO", "ACTION"), p.accept("SET", "NULL"), p.accept("SET", "DEFAULT"):
+			default:
+				p.next() // CASCADE or RESTRICT
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *ddlParser) createIndex(unique bool) error {
+	p.accept("CONCURRENTLY")
+	p.accept("IF", "NOT", "EXISTS")
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	err = p.expect("ON")
+	if err != nil {
+		return err
+	}
+	p.accept("ONLY")
+	schema, table, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	if p.accept("USING") {
+		p.next()
+	}
+	cols, err := p.identList()
+	if err != nil {
+		return err
+	}
+	t := p.c.getTable(schema, table)
+	t.Indexes = append(t.Indexes, &pb.Index{Name: name, Columns: cols, Unique: unique})
+	p.skipUntil(";")
+	return nil
+}
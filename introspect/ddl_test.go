@@ -0,0 +1,106 @@
+package introspect_test
+
+import (
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/introspect"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseDDL(t *testing.T) {
+	ddl, err := ioutil.ReadFile("../testdata/database.sql")
+	if err != nil {
+		t.Fatalf("Couldn't read DDL: %v", err)
+	}
+	actual, err := introspect.ParseDDL(string(ddl))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &grpcdbpb.DatabaseSchema{
+		Schemas: []*grpcdbpb.Schema{{
+			Name: "public",
+			Tables: []*grpcdbpb.Table{
+				{
+					Name: "country",
+					Columns: []*grpcdbpb.Column{
+						{Name: "id", Type: "uuid"},
+						{Name: "country", Type: "varchar", Nullable: true},
+						{Name: "continent", Type: "continent", Nullable: true},
+					},
+					PrimaryKey: []string{"id"},
+				},
+				{
+					Name: "person",
+					Columns: []*grpcdbpb.Column{
+						{Name: "id", Type: "uuid"},
+						{Name: "full_name", Type: "varchar", Nullable: true},
+						{Name: "birth", Type: "date", Nullable: true},
+						{Name: "country_id", Type: "uuid", Nullable: true},
+					},
+					PrimaryKey: []string{"id"},
+					ForeignKeys: []*grpcdbpb.ForeignKey{{
+						Columns:           []string{"country_id"},
+						References:        &grpcdbpb.SchemaTable{Schema: "public", Table: "country"},
+						ReferencedColumns: []string{"id"},
+					}},
+				},
+			},
+			Enums: []*grpcdbpb.EnumType{{
+				Name:   "continent",
+				Values: []string{"Africa", "Asia", "Europe", "North America", "Oceania", "South America"},
+			}},
+		}},
+	}
+	if !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+}
+
+func TestParseDDLConstraints(t *testing.T) {
+	ddl := `
+		-- a comment
+		CREATE TABLE IF NOT EXISTS shop.item (
+			id integer NOT NULL,
+			sku varchar(20) NOT NULL DEFAULT 'n/a', /* another comment */
+			price numeric(10, 2) CHECK (price > 0),
+			parent_id integer,
+			CONSTRAINT item_pk PRIMARY KEY (id),
+			CONSTRAINT item_parent_fk FOREIGN KEY (parent_id) REFERENCES shop.item (id) ON DELETE SET NULL,
+			UNIQUE (sku)
+		);
+		CREATE UNIQUE INDEX item_sku ON shop.item (sku DESC);
+		INSERT INTO shop.item VALUES (1, 'a', 1.00, NULL);
+	`
+	actual, err := introspect.ParseDDL(ddl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &grpcdbpb.DatabaseSchema{
+		Schemas: []*grpcdbpb.Schema{{
+			Name: "shop",
+			Tables: []*grpcdbpb.Table{{
+				Name: "item",
+				Columns: []*grpcdbpb.Column{
+					{Name: "id", Type: "integer"},
+					{Name: "sku", Type: "varchar(20)", HasDefault: true, Default: "'n/a'"},
+					{Name: "price", Type: "numeric(10, 2)", Nullable: true},
+					{Name: "parent_id", Type: "integer", Nullable: true},
+				},
+				PrimaryKey: []string{"id"},
+				ForeignKeys: []*grpcdbpb.ForeignKey{{
+					Name:              "item_parent_fk",
+					Columns:           []string{"parent_id"},
+					References:        &grpcdbpb.SchemaTable{Schema: "shop", Table: "item"},
+					ReferencedColumns: []string{"id"},
+				}},
+				Indexes: []*grpcdbpb.Index{
+					{Name: "item_sku", Columns: []string{"sku"}, Unique: true},
+				},
+			}},
+		}},
+	}
+	if !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+}
@@ -0,0 +1,79 @@
+// Package introspect reads schema metadata (tables, columns, keys, indexes and
+// enum types) from a live database.
+package introspect
+
+import (
+	"context"
+	"database/sql"
+	pb "github.com/GeorgeBills/grpcdb/api"
+)
+
+// collector accumulates schema metadata as it's read, keeping schemas and
+// tables in the order they were first seen.
+type collector struct {
+	schema  *pb.DatabaseSchema
+	schemas map[string]*pb.Schema
+	tables  map[tableKey]*pb.Table
+}
+
+type tableKey struct {
+	schema, table string
+}
+
+func newCollector() *collector {
+	return &collector{
+		schema:  &pb.DatabaseSchema{},
+		schemas: make(map[string]*pb.Schema),
+		tables:  make(map[tableKey]*pb.Table),
+	}
+}
+
+func (c *collector) getSchema(name string) *pb.Schema {
+	s, ok := c.schemas[name]
+	if !ok {
+		s = &pb.Schema{Name: name}
+		c.schemas[name] = s
+		c.schema.Schemas = append(c.schema.Schemas, s)
+	}
+	return s
+}
+
+func (c *collector) getTable(schema, table string) *pb.Table {
+	key := tableKey{schema, table}
+	t, ok := c.tables[key]
+	if !ok {
+		s := c.getSchema(schema)
+		t = &pb.Table{Name: table}
+		c.tables[key] = t
+		s.Tables = append(s.Tables, t)
+	}
+	return t
+}
+
+func (c *collector) getEnum(schema, enum string) *pb.EnumType {
+	s := c.getSchema(schema)
+	for _, e := range s.Enums {
+		if e.Name == enum {
+			return e
+		}
+	}
+	e := &pb.EnumType{Name: enum}
+	s.Enums = append(s.Enums, e)
+	return e
+}
+
+// queryEach runs query and calls read for each row returned.
+func queryEach(ctx context.Context, db *sql.DB, read func(*sql.Rows) error, query string, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = read(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,185 @@
+package introspect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/lib/pq"
+)
+
+// pgSchemaFilter restricts a query to the schemas in $1, or to all non-system
+// schemas if $1 is NULL. It's formatted with the name of the schema column.
+const pgSchemaFilter = `%[1]s NOT IN ('pg_catalog', 'information_schema')
+	AND %[1]s NOT LIKE 'pg\_%%'
+	AND ($1::text[] IS NULL OR %[1]s = ANY($1::text[]))`
+
+var pgSchemasQuery = `
+SELECT nspname
+FROM pg_namespace
+WHERE ` + fmt.Sprintf(pgSchemaFilter, "nspname") + `
+ORDER BY nspname`
+
+var pgColumnsQuery = `
+SELECT
+	table_schema,
+	table_name,
+	column_name,
+	CASE WHEN data_type = 'USER-DEFINED' THEN udt_name ELSE data_type END,
+	is_nullable = 'YES',
+	column_default
+FROM information_schema.columns
+WHERE ` + fmt.Sprintf(pgSchemaFilter, "table_schema") + `
+	AND (table_schema, table_name) IN (
+		SELECT table_schema, table_name
+		FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE'
+	)
+ORDER BY table_schema, table_name, ordinal_position`
+
+// pgConstraintsQuery reads primary and foreign keys. The column arrays are
+// ordered by their position in the constraint, not in the table.
+var pgConstraintsQuery = `
+SELECT
+	n.nspname,
+	c.relname,
+	con.conname,
+	con.contype,
+	ARRAY(
+		SELECT a.attname
+		FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+		ORDER BY k.ord
+	),
+	fn.nspname,
+	fc.relname,
+	ARRAY(
+		SELECT a.attname
+		FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+		ORDER BY k.ord
+	)
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_class fc ON fc.oid = con.confrelid
+LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+WHERE con.contype IN ('p', 'f')
+	AND ` + fmt.Sprintf(pgSchemaFilter, "n.nspname") + `
+ORDER BY n.nspname, c.relname, con.conname`
+
+var pgIndexesQuery = `
+SELECT
+	n.nspname,
+	t.relname,
+	i.relname,
+	ix.indisunique,
+	ix.indisprimary,
+	ARRAY(
+		SELECT a.attname
+		FROM unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = k.attnum
+		ORDER BY k.ord
+	)
+FROM pg_index ix
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN pg_class t ON t.oid = ix.indrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE t.relkind = 'r'
+	AND ` + fmt.Sprintf(pgSchemaFilter, "n.nspname") + `
+ORDER BY n.nspname, t.relname, i.relname`
+
+var pgEnumsQuery = `
+SELECT n.nspname, t.typname, e.enumlabel
+FROM pg_type t
+JOIN pg_enum e ON e.enumtypid = t.oid
+JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE ` + fmt.Sprintf(pgSchemaFilter, "n.nspname") + `
+ORDER BY n.nspname, t.typname, e.enumsortorder`
+
+// Postgres describes the given schemas of a PostgreSQL database, or all
+// non-system schemas if none are given.
+func Postgres(ctx context.Context, db *sql.DB, schemas []string) (*pb.DatabaseSchema, error) {
+	c := newCollector()
+	var filter interface{} = pq.Array(schemas)
+	if len(schemas) == 0 {
+		filter = nil
+	}
+	steps := []struct {
+		query string
+		read  func(*sql.Rows) error
+	}{
+		{pgSchemasQuery, func(rows *sql.Rows) error {
+			var name string
+			err := rows.Scan(&name)
+			if err != nil {
+				return err
+			}
+			c.getSchema(name)
+			return nil
+		}},
+		{pgColumnsQuery, func(rows *sql.Rows) error {
+			var schema, table string
+			var def sql.NullString
+			col := &pb.Column{}
+			err := rows.Scan(&schema, &table, &col.Name, &col.Type, &col.Nullable, &def)
+			if err != nil {
+				return err
+			}
+			col.HasDefault, col.Default = def.Valid, def.String
+			t := c.getTable(schema, table)
+			t.Columns = append(t.Columns, col)
+			return nil
+		}},
+		{pgConstraintsQuery, func(rows *sql.Rows) error {
+			var schema, table, name, contype string
+			var cols, refCols []string
+			var refSchema, refTable sql.NullString
+			err := rows.Scan(&schema, &table, &name, &contype, pq.Array(&cols), &refSchema, &refTable, pq.Array(&refCols))
+			if err != nil {
+				return err
+			}
+			t := c.getTable(schema, table)
+			switch contype {
+			case "p":
+				t.PrimaryKey = cols
+			case "f":
+				t.ForeignKeys = append(t.ForeignKeys, &pb.ForeignKey{
+					Name:              name,
+					Columns:           cols,
+					References:        &pb.SchemaTable{Schema: refSchema.String, Table: refTable.String},
+					ReferencedColumns: refCols,
+				})
+			}
+			return nil
+		}},
+		{pgIndexesQuery, func(rows *sql.Rows) error {
+			var schema, table string
+			idx := &pb.Index{}
+			err := rows.Scan(&schema, &table, &idx.Name, &idx.Unique, &idx.Primary, pq.Array(&idx.Columns))
+			if err != nil {
+				return err
+			}
+			t := c.getTable(schema, table)
+			t.Indexes = append(t.Indexes, idx)
+			return nil
+		}},
+		{pgEnumsQuery, func(rows *sql.Rows) error {
+			var schema, enum, value string
+			err := rows.Scan(&schema, &enum, &value)
+			if err != nil {
+				return err
+			}
+			e := c.getEnum(schema, enum)
+			e.Values = append(e.Values, value)
+			return nil
+		}},
+	}
+	for _, step := range steps {
+		err := queryEach(ctx, db, step.read, step.query, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.schema, nil
+}
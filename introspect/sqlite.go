@@ -0,0 +1,157 @@
+package introspect
+
+import (
+	"context"
+	"database/sql"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"sort"
+)
+
+// sqliteSchema is the name SQLite gives the main database.
+const sqliteSchema = "main"
+
+const sqliteTablesQuery = `
+SELECT name
+FROM sqlite_master
+WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+ORDER BY name`
+
+// SQLite describes the main database of a SQLite connection. SQLite has no
+// enum types, and doesn't name foreign keys, so those are always left empty.
+func SQLite(ctx context.Context, db *sql.DB) (*pb.DatabaseSchema, error) {
+	c := newCollector()
+	c.getSchema(sqliteSchema)
+	var tables []string
+	err := queryEach(ctx, db, func(rows *sql.Rows) error {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, name)
+		return nil
+	}, sqliteTablesQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tables {
+		t := c.getTable(sqliteSchema, name)
+		steps := []func(context.Context, *sql.DB, *pb.Table) error{
+			sqliteColumns,
+			sqliteForeignKeys,
+			sqliteIndexes,
+		}
+		for _, step := range steps {
+			err = step(ctx, db, t)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c.schema, nil
+}
+
+func sqliteColumns(ctx context.Context, db *sql.DB, t *pb.Table) error {
+	pk := make(map[int]string)
+	err := queryEach(ctx, db, func(rows *sql.Rows) error {
+		var cid, pkIndex int
+		var notNull bool
+		var def sql.NullString
+		col := &pb.Column{}
+		err := rows.Scan(&cid, &col.Name, &col.Type, &notNull, &def, &pkIndex)
+		if err != nil {
+			return err
+		}
+		col.Nullable = !notNull
+		col.HasDefault, col.Default = def.Valid, def.String
+		t.Columns = append(t.Columns, col)
+		if pkIndex > 0 {
+			pk[pkIndex] = col.Name
+		}
+		return nil
+	}, "SELECT * FROM pragma_table_info(?)", t.Name)
+	if err != nil {
+		return err
+	}
+	// pk is the 1-based position of the column within the primary key
+	for i := 1; i <= len(pk); i++ {
+		t.PrimaryKey = append(t.PrimaryKey, pk[i])
+	}
+	return nil
+}
+
+func sqliteForeignKeys(ctx context.Context, db *sql.DB, t *pb.Table) error {
+	fks := make(map[int]*pb.ForeignKey)
+	var ids []int
+	err := queryEach(ctx, db, func(rows *sql.Rows) error {
+		var id, seq int
+		var table, from string
+		var to sql.NullString
+		var onUpdate, onDelete, match string
+		err := rows.Scan(&id, &seq, &table, &from, &to, &onUpdate, &onDelete, &match)
+		if err != nil {
+			return err
+		}
+		fk, ok := fks[id]
+		if !ok {
+			fk = &pb.ForeignKey{References: &pb.SchemaTable{Schema: sqliteSchema, Table: table}}
+			fks[id] = fk
+			ids = append(ids, id)
+		}
+		fk.Columns = append(fk.Columns, from)
+		// to is NULL when the foreign key implicitly references the
+		// primary key of the parent table
+		if to.Valid {
+			fk.ReferencedColumns = append(fk.ReferencedColumns, to.String)
+		}
+		return nil
+	}, "SELECT * FROM pragma_foreign_key_list(?) ORDER BY id, seq", t.Name)
+	if err != nil {
+		return err
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		t.ForeignKeys = append(t.ForeignKeys, fks[id])
+	}
+	return nil
+}
+
+func sqliteIndexes(ctx context.Context, db *sql.DB, t *pb.Table) error {
+	var indexes []*pb.Index
+	err := queryEach(ctx, db, func(rows *sql.Rows) error {
+		var seq int
+		var origin string
+		var partial bool
+		idx := &pb.Index{}
+		err := rows.Scan(&seq, &idx.Name, &idx.Unique, &origin, &partial)
+		if err != nil {
+			return err
+		}
+		idx.Primary = origin == "pk"
+		indexes = append(indexes, idx)
+		return nil
+	}, "SELECT * FROM pragma_index_list(?) ORDER BY name", t.Name)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		err = queryEach(ctx, db, func(rows *sql.Rows) error {
+			var seqno, cid int
+			var name sql.NullString
+			err := rows.Scan(&seqno, &cid, &name)
+			if err != nil {
+				return err
+			}
+			// name is NULL for expressions
+			if name.Valid {
+				idx.Columns = append(idx.Columns, name.String)
+			}
+			return nil
+		}, "SELECT * FROM pragma_index_info(?) ORDER BY seqno", idx.Name)
+		if err != nil {
+			return err
+		}
+		t.Indexes = append(t.Indexes, idx)
+	}
+	return nil
+}
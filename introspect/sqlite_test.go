@@ -0,0 +1,85 @@
+package introspect_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/introspect"
+	"github.com/golang/protobuf/proto"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+const ddl = `
+CREATE TABLE country (
+    id TEXT PRIMARY KEY,
+    country VARCHAR,
+    continent TEXT NOT NULL DEFAULT 'Europe'
+);
+
+CREATE TABLE person (
+    id TEXT PRIMARY KEY,
+    full_name VARCHAR,
+    birth DATE,
+    country_id TEXT REFERENCES country (id)
+);
+
+CREATE UNIQUE INDEX person_full_name_birth ON person (full_name, birth);
+`
+
+func TestSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Couldn't open SQLite database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(ddl)
+	if err != nil {
+		t.Fatalf("Couldn't create tables: %v", err)
+	}
+	actual, err := introspect.SQLite(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &grpcdbpb.DatabaseSchema{
+		Schemas: []*grpcdbpb.Schema{{
+			Name: "main",
+			Tables: []*grpcdbpb.Table{
+				{
+					Name: "country",
+					Columns: []*grpcdbpb.Column{
+						{Name: "id", Type: "TEXT", Nullable: true},
+						{Name: "country", Type: "VARCHAR", Nullable: true},
+						{Name: "continent", Type: "TEXT", HasDefault: true, Default: "'Europe'"},
+					},
+					PrimaryKey: []string{"id"},
+					Indexes: []*grpcdbpb.Index{
+						{Name: "sqlite_autoindex_country_1", Columns: []string{"id"}, Unique: true, Primary: true},
+					},
+				},
+				{
+					Name: "person",
+					Columns: []*grpcdbpb.Column{
+						{Name: "id", Type: "TEXT", Nullable: true},
+						{Name: "full_name", Type: "VARCHAR", Nullable: true},
+						{Name: "birth", Type: "DATE", Nullable: true},
+						{Name: "country_id", Type: "TEXT", Nullable: true},
+					},
+					PrimaryKey: []string{"id"},
+					ForeignKeys: []*grpcdbpb.ForeignKey{{
+						Columns:           []string{"country_id"},
+						References:        &grpcdbpb.SchemaTable{Schema: "main", Table: "country"},
+						ReferencedColumns: []string{"id"},
+					}},
+					Indexes: []*grpcdbpb.Index{
+						{Name: "person_full_name_birth", Columns: []string{"full_name", "birth"}, Unique: true},
+						{Name: "sqlite_autoindex_person_1", Columns: []string{"id"}, Unique: true, Primary: true},
+					},
+				},
+			},
+		}},
+	}
+	if !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+}
@@ -0,0 +1,238 @@
+package policy
+
+import (
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"regexp"
+)
+
+var (
+	// identifier matches "name" and "qualifier.name"
+	identifier = regexp.MustCompile(`^(?:([A-Za-z_][A-Za-z0-9_]*)\.)?([A-Za-z_][A-Za-z0-9_]*)$`)
+	// column matches result columns which are column names, optionally
+	// qualified with a table and optionally followed by an alias
+	column = regexp.MustCompile(`^\s*(?:([A-Za-z_][A-Za-z0-9_]*)\.)?([A-Za-z_][A-Za-z0-9_]*)(?:\s+(?:(?i)AS\s+)?[A-Za-z_][A-Za-z0-9_]*)?\s*$`)
+	// wildcard matches * and table.*
+	wildcard = regexp.MustCompile(`^\s*(?:([A-Za-z_][A-Za-z0-9_]*)\.)?\*\s*$`)
+	// aggregate matches count, sum, avg, min and max of a column or *,
+	// optionally followed by an alias
+	aggregate = regexp.MustCompile(`^\s*(?i:count|sum|avg|min|max)\s*\(\s*(?i:DISTINCT\s+)?(?:([A-Za-z_][A-Za-z0-9_]*)\.)?([A-Za-z_][A-Za-z0-9_]*|\*)\s*\)(?:\s+(?:(?i)AS\s+)?[A-Za-z_][A-Za-z0-9_]*)?\s*$`)
+)
+
+// Check returns a *DeniedError if the statement does anything the principal
+// hasn't been granted, or nil if it's allowed. Every column the statement
+// reads, whether in the result, a join, a WHERE clause or an insert's select,
+// requires the select privilege. Result columns may only be columns, aliased
+// columns, * and table.*, or count, sum, avg, min or max of one of those; any
+// other expression is raw SQL which could read other tables, for example in a
+// subquery, so it's denied. Unqualified columns in a statement with joins
+// require the privilege on every joined table.
+//
+// The statement should already have been checked with grpcdb.Validate.
+func (p *Policy) Check(principal string, s *pb.Statement) error {
+	c := &checker{policy: p, principal: principal}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		c.selectStatement(s.GetSelect())
+	case *pb.Statement_Insert:
+		c.insertStatement(s.GetInsert())
+	case *pb.Statement_Update:
+		c.updateStatement(s.GetUpdate())
+	case *pb.Statement_Delete:
+		c.deleteStatement(s.GetDelete())
+	default:
+		c.deny("unrecognized statement type %T", s.Statement)
+	}
+	if len(c.reasons) > 0 {
+		return &DeniedError{Principal: principal, Reasons: c.reasons}
+	}
+	return nil
+}
+
+type checker struct {
+	policy    *Policy
+	principal string
+	reasons   []string
+	seen      map[string]bool
+}
+
+func (c *checker) deny(format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	if c.seen[reason] {
+		return
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	c.seen[reason] = true
+	c.reasons = append(c.reasons, reason)
+}
+
+// scopeTable is a table that columns may refer to.
+type scopeTable struct {
+	name      string // as written in the statement
+	qualified string // qualified with the default schema if required
+	grant     *tableGrant
+}
+
+func (c *checker) table(name string, priv Privilege) *scopeTable {
+	qualified, ok := c.policy.qualify(name)
+	if !ok {
+		c.deny("can't check table %q", name)
+		return nil
+	}
+	st := &scopeTable{name: name, qualified: qualified, grant: c.policy.grant(c.principal, qualified)}
+	if st.grant == nil || (!st.grant.all[priv] && len(st.grant.columns[priv]) == 0) {
+		c.deny("may not %s %s", priv, qualified)
+	}
+	return st
+}
+
+func (c *checker) schemaTable(st *pb.SchemaTable, priv Privilege) *scopeTable {
+	name := st.GetTable()
+	if st.GetSchema() != "" {
+		name = st.Schema + "." + name
+	}
+	return c.table(name, priv)
+}
+
+// column checks a column with the given privilege. Columns qualified with a
+// table must match a table in scope by name; unqualified columns require the
+// privilege on every table in scope.
+func (c *checker) column(scope []*scopeTable, col *pb.Col, priv Privilege) {
+	matched := false
+	for _, st := range scope {
+		if st == nil {
+			continue
+		}
+		if col.Table != "" {
+			table := col.Table
+			if col.Schema != "" {
+				table = col.Schema + "." + table
+			}
+			if table != st.name && table != st.qualified && !(col.Schema == "" && st.qualified == c.policy.DefaultSchema+"."+table) {
+				continue
+			}
+		}
+		matched = true
+		if !st.grant.allows(priv, col.Column) {
+			c.deny("may not %s %s.%s", priv, st.qualified, col.Column)
+		}
+	}
+	if !matched {
+		c.deny("can't check column %s.%s: table isn't in the statement", col.Table, col.Column)
+	}
+}
+
+// allColumns requires the privilege on every column of the tables in scope
+// named table, or of every table in scope if table is empty.
+func (c *checker) allColumns(scope []*scopeTable, table string, priv Privilege, why string) {
+	matched := false
+	for _, st := range scope {
+		if st == nil || (table != "" && table != st.name && st.qualified != c.policy.DefaultSchema+"."+table) {
+			continue
+		}
+		matched = true
+		if st.grant == nil || !st.grant.all[priv] {
+			c.deny("may not %s every column of %s, as required by %s", priv, st.qualified, why)
+		}
+	}
+	if !matched {
+		c.deny("can't check %s: table isn't in the statement", why)
+	}
+}
+
+func (c *checker) selectStatement(sel *pb.Select) {
+	scope := []*scopeTable{c.table(sel.From, Select)}
+	for _, j := range sel.Join {
+		scope = append(scope, c.table(j.Table, Select))
+	}
+	for _, rc := range sel.ResultColumn {
+		if m := wildcard.FindStringSubmatch(rc); m != nil {
+			c.allColumns(scope, m[1], Select, fmt.Sprintf("result column %q", rc))
+		} else if m := column.FindStringSubmatch(rc); m != nil {
+			c.column(scope, &pb.Col{Table: m[1], Column: m[2]}, Select)
+		} else if m := aggregate.FindStringSubmatch(rc); m != nil && m[2] == "*" {
+			c.allColumns(scope, m[1], Select, fmt.Sprintf("result column %q", rc))
+		} else if m != nil {
+			c.column(scope, &pb.Col{Table: m[1], Column: m[2]}, Select)
+		} else {
+			c.deny("may not select result column %q: only columns, *, and aggregates of them can be checked", rc)
+		}
+	}
+	for _, j := range sel.Join {
+		c.expr(scope, j.On)
+	}
+	c.expr(scope, sel.Where)
+	for _, e := range sel.GroupBy {
+		c.expr(scope, e)
+	}
+	c.expr(scope, sel.Having)
+	for _, ot := range sel.OrderBy {
+		c.expr(scope, ot.GetBy())
+	}
+}
+
+func (c *checker) insertStatement(ins *pb.Insert) {
+	priv := Insert
+	if ins.Insert == pb.InsertType_REPLACE {
+		// REPLACE deletes conflicting rows before inserting
+		c.schemaTable(ins.Into, Delete)
+	}
+	into := c.schemaTable(ins.Into, priv)
+	for _, name := range ins.Columns {
+		c.column([]*scopeTable{into}, &pb.Col{Column: name}, priv)
+	}
+	switch ins.GetToInsert().GetInsert().(type) {
+	case *pb.ToInsert_Values:
+		for _, row := range ins.ToInsert.GetValues().Rows {
+			for _, v := range row.Values {
+				c.expr(nil, v)
+			}
+		}
+	case *pb.ToInsert_Select:
+		c.selectStatement(ins.ToInsert.GetSelect())
+	}
+}
+
+func (c *checker) updateStatement(upd *pb.Update) {
+	table := c.schemaTable(upd.Table, Update)
+	scope := []*scopeTable{table}
+	for _, set := range upd.Set {
+		c.column(scope, &pb.Col{Column: set.Column}, Update)
+		c.expr(scope, set.To)
+	}
+	c.expr(scope, upd.Where)
+	if upd.Where == nil && upd.AllowFullTable {
+		c.fullTable(table, Update)
+	}
+}
+
+func (c *checker) deleteStatement(del *pb.Delete) {
+	table := c.schemaTable(del.From, Delete)
+	scope := []*scopeTable{table}
+	c.expr(scope, del.Where)
+	if del.Where == nil && del.AllowFullTable {
+		c.fullTable(table, Delete)
+	}
+}
+
+// fullTable requires permission to update or delete every row of the table.
+func (c *checker) fullTable(st *scopeTable, priv Privilege) {
+	if st != nil && (st.grant == nil || !st.grant.fullTable) {
+		c.deny("may not %s every row of %s", priv, st.qualified)
+	}
+}
+
+// expr requires select on every column the expression reads.
+func (c *checker) expr(scope []*scopeTable, e *pb.Expr) {
+	switch e.GetExpr().(type) {
+	case *pb.Expr_Col:
+		c.column(scope, e.GetCol(), Select)
+	case *pb.Expr_UnaryExpr:
+		c.expr(scope, e.GetUnaryExpr().Expr)
+	case *pb.Expr_BinaryExpr:
+		c.expr(scope, e.GetBinaryExpr().Expr1)
+		c.expr(scope, e.GetBinaryExpr().Expr2)
+	}
+}
@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/proto"
+	"strings"
+)
+
+// Limits bound the cost of a principal's statements. Zero means no limit.
+type Limits struct {
+	// MaxRows is the most rows a select may return. Selects without a limit,
+	// or with a greater one, have their limit lowered to MaxRows.
+	MaxRows uint64 `json:"max_rows"`
+	// MaxJoins is the most joins a statement may have.
+	MaxJoins int `json:"max_joins"`
+	// MaxExprDepth is how deeply expressions may nest.
+	MaxExprDepth int `json:"max_expr_depth"`
+	// MaxStatementSize is the largest encoded statement, in bytes.
+	MaxStatementSize int `json:"max_statement_size"`
+	// MaxCost is the greatest cost the database may estimate for a statement.
+	// It's checked by the server, which runs EXPLAIN before the statement.
+	MaxCost float64 `json:"max_cost"`
+}
+
+func (l Limits) check() error {
+	switch {
+	case l.MaxJoins < 0:
+		return errors.New("max_joins must not be negative")
+	case l.MaxExprDepth < 0:
+		return errors.New("max_expr_depth must not be negative")
+	case l.MaxStatementSize < 0:
+		return errors.New("max_statement_size must not be negative")
+	case l.MaxCost < 0:
+		return errors.New("max_cost must not be negative")
+	}
+	return nil
+}
+
+// LimitsFor returns the limits on the principal's statements, taking each limit
+// they don't set from those for Anyone.
+func (p *Policy) LimitsFor(principal string) Limits {
+	l, anyone := p.Limits[principal], p.Limits[Anyone]
+	if l.MaxRows == 0 {
+		l.MaxRows = anyone.MaxRows
+	}
+	if l.MaxJoins == 0 {
+		l.MaxJoins = anyone.MaxJoins
+	}
+	if l.MaxExprDepth == 0 {
+		l.MaxExprDepth = anyone.MaxExprDepth
+	}
+	if l.MaxStatementSize == 0 {
+		l.MaxStatementSize = anyone.MaxStatementSize
+	}
+	if l.MaxCost == 0 {
+		l.MaxCost = anyone.MaxCost
+	}
+	return l
+}
+
+// Enforce returns a *LimitError if the statement exceeds the principal's
+// limits, other than MaxCost. Otherwise it returns the statement, copied and
+// with its limit lowered if it's a select which could return more than
+// MaxRows.
+func (p *Policy) Enforce(principal string, s *pb.Statement) (*pb.Statement, error) {
+	l := p.LimitsFor(principal)
+	var reasons []string
+	if size := proto.Size(s); l.MaxStatementSize > 0 && size > l.MaxStatementSize {
+		reasons = append(reasons, fmt.Sprintf("statement is %d bytes, more than the limit of %d", size, l.MaxStatementSize))
+	}
+	if joins := countJoins(s); l.MaxJoins > 0 && joins > l.MaxJoins {
+		reasons = append(reasons, fmt.Sprintf("statement has %d joins, more than the limit of %d", joins, l.MaxJoins))
+	}
+	if depth := statementDepth(s); l.MaxExprDepth > 0 && depth > l.MaxExprDepth {
+		reasons = append(reasons, fmt.Sprintf("expressions are nested %d deep, more than the limit of %d", depth, l.MaxExprDepth))
+	}
+	if len(reasons) > 0 {
+		return nil, &LimitError{Principal: principal, Reasons: reasons}
+	}
+	if sel := s.GetSelect(); sel != nil && l.MaxRows > 0 && (sel.Limit == 0 || sel.Limit > l.MaxRows) {
+		s = proto.Clone(s).(*pb.Statement)
+		s.GetSelect().Limit = l.MaxRows
+	}
+	return s, nil
+}
+
+func countJoins(s *pb.Statement) int {
+	if sel := s.GetSelect(); sel != nil {
+		return len(sel.Join)
+	}
+	return len(s.GetInsert().GetToInsert().GetSelect().GetJoin())
+}
+
+// statementDepth returns the depth of the most deeply nested expression in
+// the statement.
+func statementDepth(s *pb.Statement) int {
+	var exprs []*pb.Expr
+	selectExprs := func(sel *pb.Select) {
+		if sel == nil {
+			return
+		}
+		exprs = append(exprs, sel.Where, sel.Having)
+		exprs = append(exprs, sel.GroupBy...)
+		for _, j := range sel.Join {
+			exprs = append(exprs, j.On)
+		}
+		for _, o := range sel.OrderBy {
+			exprs = append(exprs, o.By)
+		}
+	}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		selectExprs(s.GetSelect())
+	case *pb.Statement_Insert:
+		ins := s.GetInsert()
+		selectExprs(ins.GetToInsert().GetSelect())
+		for _, row := range ins.GetToInsert().GetValues().GetRows() {
+			exprs = append(exprs, row.Values...)
+		}
+	case *pb.Statement_Update:
+		upd := s.GetUpdate()
+		exprs = append(exprs, upd.Where)
+		for _, set := range upd.Set {
+			exprs = append(exprs, set.To)
+		}
+	case *pb.Statement_Delete:
+		exprs = append(exprs, s.GetDelete().Where)
+	}
+	max := 0
+	for _, e := range exprs {
+		if d := exprDepth(e); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func exprDepth(e *pb.Expr) int {
+	if e == nil {
+		return 0
+	}
+	switch e.Expr.(type) {
+	case *pb.Expr_UnaryExpr:
+		return 1 + exprDepth(e.GetUnaryExpr().Expr)
+	case *pb.Expr_BinaryExpr:
+		d1, d2 := exprDepth(e.GetBinaryExpr().Expr1), exprDepth(e.GetBinaryExpr().Expr2)
+		if d2 > d1 {
+			d1 = d2
+		}
+		return 1 + d1
+	}
+	return 1
+}
+
+// LimitError lists the limits a statement exceeds.
+type LimitError struct {
+	Principal string
+	Reasons   []string
+}
+
+func (le *LimitError) Error() string {
+	who := le.Principal
+	if who == "" {
+		who = "anonymous caller"
+	}
+	return fmt.Sprintf("Statement from %s exceeds limits: %s", who, strings.Join(le.Reasons, "; "))
+}
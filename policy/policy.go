@@ -0,0 +1,267 @@
+// Package policy decides which tables and columns each caller may use.
+//
+// A policy is a JSON document granting privileges on tables, and optionally
+// only on some of their columns, to principals:
+//
+//	{
+//	    "default_schema": "public",
+//	    "principals": {
+//	        "orders-service": [
+//	            {"table": "orders", "privileges": ["select", "insert", "update", "delete"], "where": "tenant_id = claims.tenant"},
+//	            {"table": "person", "privileges": ["select"], "columns": ["id", "full_name"]}
+//	        ],
+//	        "*": [
+//	            {"table": "country", "privileges": ["select"]}
+//	        ]
+//	    },
+//	    "limits": {
+//	        "*": {"max_rows": 1000, "max_joins": 3, "max_expr_depth": 32, "max_statement_size": 65536, "max_cost": 100000}
+//	    },
+//	    "analyze": ["orders-service"]
+//	}
+//
+// Grants under "*" apply to every caller, including unauthenticated ones.
+// Anything not granted is denied, and callers are only shown the tables they
+// have been granted something on; see Visible.
+//
+// A grant's "where" is a row filter, written as in a query's WHERE clause, which
+// limits the caller to matching rows of the table; see Apply. Columns in it
+// refer to the granted table, except for those qualified with "claims", which
+// refer to the caller's claims, such as those in their JWT.
+//
+// Updates and deletes without a WHERE clause are refused unless the statement
+// sets allow_full_table, and then only if the grant sets "allow_full_table"
+// too.
+//
+// Limits bound the cost of each caller's statements; see Limits. Only the
+// principals listed under "analyze" may have their statements run by EXPLAIN
+// ANALYZE, which executes them.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"io/ioutil"
+	"strings"
+)
+
+// Privilege is an action a grant permits on a table.
+type Privilege string
+
+// Privileges which may be granted.
+const (
+	Select Privilege = "select"
+	Insert Privilege = "insert"
+	Update Privilege = "update"
+	Delete Privilege = "delete"
+)
+
+// Anyone is the principal whose grants apply to every caller.
+const Anyone = "*"
+
+// Grant permits privileges on a table. If Columns is empty the privileges
+// apply to every column. If Where is set then only rows matching it may be
+// used. If AllowFullTable is set then statements setting allow_full_table may
+// update or delete every row.
+type Grant struct {
+	Table      string      `json:"table"`
+	Privileges []Privilege `json:"privileges"`
+	Columns    []string    `json:"columns"`
+	Where      string      `json:"where"`
+	// AllowFullTable permits updates and deletes without a WHERE clause
+	AllowFullTable bool `json:"allow_full_table"`
+}
+
+// Policy holds the grants for each principal.
+type Policy struct {
+	// DefaultSchema is the schema of tables which aren't qualified with one,
+	// both in grants and in statements. It defaults to "public".
+	DefaultSchema string             `json:"default_schema"`
+	Principals    map[string][]Grant `json:"principals"`
+	// Limits maps principal to the limits on their statements. Limits for
+	// Anyone apply where a principal doesn't set their own.
+	Limits map[string]Limits `json:"limits"`
+	// Analyze lists the principals who may use EXPLAIN ANALYZE. It may
+	// include Anyone.
+	Analyze []string `json:"analyze"`
+
+	// tables maps principal to qualified table name to the privileges
+	// granted on it
+	tables map[string]map[string]*tableGrant
+}
+
+// tableGrant combines every grant a principal has on a table.
+type tableGrant struct {
+	all       map[Privilege]bool            // privileges granted on every column
+	columns   map[Privilege]map[string]bool // privileges granted on some columns
+	filters   []*pb.Expr                    // row filters, all of which must match
+	fullTable bool                          // updates and deletes may omit WHERE
+}
+
+func (tg *tableGrant) allows(priv Privilege, column string) bool {
+	return tg != nil && (tg.all[priv] || tg.columns[priv][column])
+}
+
+// Parse parses and checks a policy.
+func Parse(b []byte) (*Policy, error) {
+	p := &Policy{}
+	err := json.Unmarshal(b, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.DefaultSchema == "" {
+		p.DefaultSchema = "public"
+	}
+	p.tables = make(map[string]map[string]*tableGrant)
+	for principal, grants := range p.Principals {
+		tables := make(map[string]*tableGrant)
+		for i, g := range grants {
+			at := fmt.Sprintf("principals[%q][%d]", principal, i)
+			table, ok := p.qualify(g.Table)
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid table %q", at, g.Table)
+			}
+			if len(g.Privileges) == 0 {
+				return nil, fmt.Errorf("%s: at least one privilege is required", at)
+			}
+			tg := tables[table]
+			if tg == nil {
+				tg = &tableGrant{all: make(map[Privilege]bool), columns: make(map[Privilege]map[string]bool)}
+				tables[table] = tg
+			}
+			tg.fullTable = tg.fullTable || g.AllowFullTable
+			if g.Where != "" {
+				filter, err := parseFilter(g.Where)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid where: %v", at, err)
+				}
+				tg.filters = append(tg.filters, filter)
+			}
+			for _, priv := range g.Privileges {
+				switch priv {
+				case Select, Insert, Update, Delete:
+				default:
+					return nil, fmt.Errorf("%s: unrecognized privilege %q", at, priv)
+				}
+				if len(g.Columns) == 0 {
+					tg.all[priv] = true
+					continue
+				}
+				if tg.columns[priv] == nil {
+					tg.columns[priv] = make(map[string]bool)
+				}
+				for _, c := range g.Columns {
+					tg.columns[priv][c] = true
+				}
+			}
+		}
+		p.tables[principal] = tables
+	}
+	for principal, l := range p.Limits {
+		err := l.check()
+		if err != nil {
+			return nil, fmt.Errorf("limits[%q]: %v", principal, err)
+		}
+	}
+	return p, nil
+}
+
+// Load reads and parses the policy in filename.
+func Load(filename string) (*Policy, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing policy %s: %v", filename, err)
+	}
+	return p, nil
+}
+
+// qualify returns the name of a table given as either "table" or
+// "schema.table", qualified with the default schema if required.
+func (p *Policy) qualify(name string) (string, bool) {
+	m := identifier.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	if m[1] == "" {
+		return p.DefaultSchema + "." + m[2], true
+	}
+	return name, true
+}
+
+// grant returns what the principal may do with the table, combining their own
+// grants with those for Anyone, or nil if they may do nothing.
+func (p *Policy) grant(principal, table string) *tableGrant {
+	own, anyone := p.tables[principal][table], p.tables[Anyone][table]
+	if principal == Anyone || anyone == nil {
+		return own
+	}
+	if own == nil {
+		return anyone
+	}
+	combined := &tableGrant{all: make(map[Privilege]bool), columns: make(map[Privilege]map[string]bool)}
+	for _, tg := range []*tableGrant{own, anyone} {
+		combined.filters = append(combined.filters, tg.filters...)
+		combined.fullTable = combined.fullTable || tg.fullTable
+		for priv := range tg.all {
+			combined.all[priv] = true
+		}
+		for priv, columns := range tg.columns {
+			if combined.columns[priv] == nil {
+				combined.columns[priv] = make(map[string]bool)
+			}
+			for c := range columns {
+				combined.columns[priv][c] = true
+			}
+		}
+	}
+	return combined
+}
+
+// MayAnalyze returns true if the principal may use EXPLAIN ANALYZE.
+func (p *Policy) MayAnalyze(principal string) bool {
+	for _, a := range p.Analyze {
+		if a == principal || a == Anyone {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible returns a copy of schema with only the tables on which the principal
+// holds some privilege, so that they can't discover tables they may not use.
+// Schemas left without any tables are removed.
+func (p *Policy) Visible(principal string, schema *pb.DatabaseSchema) *pb.DatabaseSchema {
+	visible := &pb.DatabaseSchema{}
+	for _, s := range schema.GetSchemas() {
+		var tables []*pb.Table
+		for _, t := range s.Tables {
+			if p.grant(principal, s.Name+"."+t.Name) != nil {
+				tables = append(tables, t)
+			}
+		}
+		if len(tables) == 0 {
+			continue
+		}
+		visible.Schemas = append(visible.Schemas, &pb.Schema{Name: s.Name, Tables: tables, Enums: s.Enums})
+	}
+	return visible
+}
+
+// DeniedError lists everything a statement does that its caller may not do.
+type DeniedError struct {
+	Principal string
+	Reasons   []string
+}
+
+func (de *DeniedError) Error() string {
+	who := de.Principal
+	if who == "" {
+		who = "anonymous caller"
+	}
+	return fmt.Sprintf("Permission denied for %s: %s", who, strings.Join(de.Reasons, "; "))
+}
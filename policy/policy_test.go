@@ -0,0 +1,410 @@
+package policy_test
+
+import (
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/policy"
+	"github.com/GeorgeBills/grpcdb/query"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const config = `{
+	"principals": {
+		"orders-service": [
+			{"table": "orders", "privileges": ["select", "insert", "update", "delete"], "allow_full_table": true},
+			{"table": "person", "privileges": ["select"], "columns": ["id", "full_name"]},
+			{"table": "person", "privileges": ["update"], "columns": ["full_name"]}
+		],
+		"reporting": [
+			{"table": "audit.log", "privileges": ["select"]}
+		],
+		"*": [
+			{"table": "country", "privileges": ["select"]}
+		]
+	}
+}`
+
+func TestCheck(t *testing.T) {
+	p, err := policy.Parse([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	table := []struct {
+		principal string
+		statement string
+		reasons   []string
+	}{
+		{"orders-service", "select * from orders where total > 10", nil},
+		{"orders-service", "select id, full_name as name from public.person order by person.full_name", nil},
+		{"orders-service", "select orders.id, person.full_name from orders join person on orders.person_id = person.email", []string{
+			"may not select public.person.email",
+		}},
+		{"orders-service", "select id from orders join person on orders.id = person.id", nil},
+		{"orders-service", "select id from person where birth > '2000-01-01'", []string{
+			"may not select public.person.birth",
+		}},
+		{"orders-service", "select * from person", []string{
+			`may not select every column of public.person, as required by result column "*"`,
+		}},
+		{"orders-service", "select count(*) from orders", nil},
+		{"orders-service", "select count(*) from person", []string{
+			`may not select every column of public.person, as required by result column "count(*)"`,
+		}},
+		{"orders-service", "select max(orders.total) as most, count(distinct id) from orders", nil},
+		{"orders-service", "select sum(birth) from person", []string{
+			"may not select public.person.birth",
+		}},
+		{"orders-service", "select id, (select max(salary) from payroll) as s from orders", []string{
+			`may not select result column "(select max(salary) from payroll) as s": only columns, *, and aggregates of them can be checked`,
+		}},
+		{"orders-service", "select name from country", nil},
+		{"orders-service", "select id from other.country", []string{
+			"may not select other.country",
+			"may not select other.country.id",
+		}},
+		{"orders-service", "update person set full_name = 'x' where id = 1", nil},
+		{"orders-service", "update person set birth = '2000-01-01' where id = 1", []string{
+			"may not update public.person.birth",
+		}},
+		{"orders-service", "delete from person where id = 1", []string{
+			"may not delete public.person",
+		}},
+		{"orders-service", "insert into orders (id, total) select id, full_name from person", nil},
+		{"orders-service", "insert into orders (id) select salary from payroll", []string{
+			"may not select public.payroll",
+			"may not select public.payroll.salary",
+		}},
+		{"orders-service", "replace into person (id) values (1)", []string{
+			"may not delete public.person",
+			"may not insert public.person",
+			"may not insert public.person.id",
+		}},
+		{"reporting", "select * from audit.log where audit.log.level = 'error'", nil},
+		{"reporting", "select * from orders", []string{
+			"may not select public.orders",
+			`may not select every column of public.orders, as required by result column "*"`,
+		}},
+		{"", "select name from country", nil},
+		{"", "delete from country", []string{"may not delete public.country"}},
+	}
+	for _, tt := range table {
+		t.Run(tt.principal+": "+tt.statement, func(t *testing.T) {
+			err := p.Check(tt.principal, query.MustParse(tt.statement))
+			if tt.reasons == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			de, ok := err.(*policy.DeniedError)
+			if !ok {
+				t.Fatalf("Expected a *DeniedError, got: %v", err)
+			}
+			if !reflect.DeepEqual(de.Reasons, tt.reasons) {
+				t.Errorf("Expected: %q\nActual: %q", tt.reasons, de.Reasons)
+			}
+		})
+	}
+}
+
+func TestCheckFullTable(t *testing.T) {
+	p, err := policy.Parse([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	table := []struct {
+		statement string
+		expected  string
+	}{
+		{"delete from orders", ""},
+		{"update orders set total = 0", ""},
+		{"update person set full_name = 'x'", "Permission denied for orders-service: may not update every row of public.person"},
+		{"update person set full_name = 'x' where id = 1", ""},
+	}
+	for _, tt := range table {
+		s := query.MustParse(tt.statement)
+		if upd := s.GetUpdate(); upd != nil {
+			upd.AllowFullTable = true
+		} else {
+			s.GetDelete().AllowFullTable = true
+		}
+		err := p.Check("orders-service", s)
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		if actual != tt.expected {
+			t.Errorf("%s\nExpected: %s\nActual: %s", tt.statement, tt.expected, actual)
+		}
+	}
+}
+
+const limitsConfig = `{
+	"limits": {
+		"reporting": {"max_rows": 5000, "max_joins": 0},
+		"*": {"max_rows": 100, "max_joins": 1, "max_expr_depth": 3, "max_statement_size": 200}
+	}
+}`
+
+func TestEnforce(t *testing.T) {
+	p, err := policy.Parse([]byte(limitsConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	long := "select * from t where a = '" + strings.Repeat("x", 200) + "'"
+	table := []struct {
+		principal string
+		statement string
+		expected  string
+		reasons   []string
+	}{
+		{"", "select * from t", "SELECT * FROM t LIMIT 100", nil},
+		{"", "select * from t limit 10", "SELECT * FROM t LIMIT 10", nil},
+		{"", "select * from t limit 1000", "SELECT * FROM t LIMIT 100", nil},
+		{"reporting", "select * from t limit 1000", "SELECT * FROM t LIMIT 1000", nil},
+		{"reporting", "select * from t", "SELECT * FROM t LIMIT 5000", nil},
+		{"", "delete from t where a = 1 and b = 2", "DELETE FROM t WHERE a = 1 AND b = 2", nil},
+		{"", "select * from t join u on t.id = u.id join v on t.id = v.id", "", []string{
+			"statement has 2 joins, more than the limit of 1",
+		}},
+		{"", "delete from t where a = 1 and (b = 2 or c = 3)", "", []string{
+			"expressions are nested 4 deep, more than the limit of 3",
+		}},
+		{"", long, "", []string{
+			"statement is 233 bytes, more than the limit of 200",
+		}},
+	}
+	for _, tt := range table {
+		t.Run(tt.principal+": "+tt.statement, func(t *testing.T) {
+			statement := query.MustParse(tt.statement)
+			enforced, err := p.Enforce(tt.principal, statement)
+			if tt.reasons == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if actual := query.Format(enforced); actual != tt.expected {
+					t.Errorf("Expected: %s\nActual: %s", tt.expected, actual)
+				}
+				if query.Format(statement) != query.Format(query.MustParse(tt.statement)) {
+					t.Errorf("Enforce modified the original statement")
+				}
+				return
+			}
+			le, ok := err.(*policy.LimitError)
+			if !ok {
+				t.Fatalf("Expected a *LimitError, got: %v", err)
+			}
+			if !reflect.DeepEqual(le.Reasons, tt.reasons) {
+				t.Errorf("Expected: %q\nActual: %q", tt.reasons, le.Reasons)
+			}
+		})
+	}
+}
+
+func TestVisible(t *testing.T) {
+	p, err := policy.Parse([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &pb.DatabaseSchema{Schemas: []*pb.Schema{
+		{Name: "public", Tables: []*pb.Table{{Name: "orders"}, {Name: "person"}, {Name: "country"}, {Name: "secret"}}},
+		{Name: "audit", Tables: []*pb.Table{{Name: "log"}}},
+	}}
+	table := []struct {
+		principal string
+		expected  []string
+	}{
+		{"orders-service", []string{"public.orders", "public.person", "public.country"}},
+		{"reporting", []string{"public.country", "audit.log"}},
+		{"", []string{"public.country"}},
+	}
+	for _, tt := range table {
+		t.Run(tt.principal, func(t *testing.T) {
+			var actual []string
+			for _, s := range p.Visible(tt.principal, schema).Schemas {
+				for _, t := range s.Tables {
+					actual = append(actual, s.Name+"."+t.Name)
+				}
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("Expected: %q\nActual: %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	table := []struct {
+		config   string
+		expected string
+	}{
+		{`{"principals": {"a": [{"table": "t"}]}}`, `principals["a"][0]: at least one privilege is required`},
+		{`{"principals": {"a": [{"table": "t t", "privileges": ["select"]}]}}`, `principals["a"][0]: invalid table "t t"`},
+		{`{"principals": {"a": [{"table": "t", "privileges": ["drop"]}]}}`, `principals["a"][0]: unrecognized privilege "drop"`},
+		{`{"principals": {"a": [{"table": "t", "privileges": ["select"], "where": "other.x = 1"}]}}`, `principals["a"][0]: invalid where: column other.x must be unqualified, or qualified with claims`},
+		{`{"limits": {"a": {"max_joins": -1}}}`, `limits["a"]: max_joins must not be negative`},
+	}
+	for _, tt := range table {
+		_, err := policy.Parse([]byte(tt.config))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Expected: %s\nActual: %v", tt.expected, err)
+		}
+	}
+}
+
+const rowFilterConfig = `{
+	"principals": {
+		"tenant": [
+			{"table": "orders", "privileges": ["select", "insert", "update", "delete"], "where": "tenant_id = claims.tenant"},
+			{"table": "invoice", "privileges": ["select", "insert"], "where": "tenant_id = claims.tenant and region = 'eu'"},
+			{"table": "audit.log", "privileges": ["select", "insert"], "where": "level <> 'debug'"},
+			{"table": "country", "privileges": ["select"]}
+		]
+	}
+}`
+
+func TestApply(t *testing.T) {
+	p, err := policy.Parse([]byte(rowFilterConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	claims := map[string]string{"tenant": "42"}
+	table := []struct {
+		statement string
+		expected  string
+		reasons   []string
+	}{
+		{"select * from country", "SELECT * FROM country", nil},
+		{
+			"select * from orders where a = 1 or b = 2",
+			"SELECT * FROM orders WHERE (a = 1 OR b = 2) AND orders.tenant_id = '42'",
+			nil,
+		},
+		{
+			"select * from public.orders",
+			"SELECT * FROM public.orders WHERE public.orders.tenant_id = '42'",
+			nil,
+		},
+		{
+			"select * from country join orders on country.id = orders.country_id",
+			"SELECT * FROM country JOIN orders ON country.id = orders.country_id AND orders.tenant_id = '42'",
+			nil,
+		},
+		{
+			"select * from country right join orders on country.id = orders.country_id",
+			"SELECT * FROM country RIGHT JOIN orders ON country.id = orders.country_id WHERE orders.tenant_id = '42'",
+			nil,
+		},
+		{
+			"update orders set total = 1 where id = 2",
+			"UPDATE orders SET total = 1 WHERE id = 2 AND orders.tenant_id = '42'",
+			nil,
+		},
+		{"update orders set tenant_id = '7' where id = 2", "", []string{
+			"may not update orders.tenant_id, which is used by a row filter",
+		}},
+		{"delete from orders", "DELETE FROM orders WHERE orders.tenant_id = '42'", nil},
+		{
+			"insert into orders (id) values (1), (2)",
+			"INSERT INTO orders (id, tenant_id) VALUES (1, '42'), (2, '42')",
+			nil,
+		},
+		{
+			"insert into invoice (id, region) values (1, 'eu')",
+			"INSERT INTO invoice (id, region, tenant_id) VALUES (1, 'eu', '42')",
+			nil,
+		},
+		{"insert into orders (id, tenant_id) values (1, '42'), (2, '7')", "", []string{
+			"row 1: tenant_id must be '42' to satisfy the row filter on orders",
+		}},
+		{"insert into audit.log (level) values ('error')", "", []string{
+			"may not insert into audit.log, as its row filter level != 'debug' can't be checked: not a conjunction of column = value conditions",
+		}},
+		{"insert into orders (id) select id from country", "", []string{
+			"may not insert into orders from a select, as its row filter can't be checked",
+		}},
+	}
+	for _, tt := range table {
+		t.Run(tt.statement, func(t *testing.T) {
+			statement := query.MustParse(tt.statement)
+			applied, _, err := p.Apply("tenant", claims, statement, nil)
+			if tt.reasons == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				actual := query.Format(applied)
+				if actual != tt.expected {
+					t.Errorf("Expected: %s\nActual: %s", tt.expected, actual)
+				}
+				if query.Format(statement) != query.Format(query.MustParse(tt.statement)) {
+					t.Errorf("Apply modified the original statement")
+				}
+				return
+			}
+			de, ok := err.(*policy.DeniedError)
+			if !ok {
+				t.Fatalf("Expected a *DeniedError, got: %v", err)
+			}
+			if !reflect.DeepEqual(de.Reasons, tt.reasons) {
+				t.Errorf("Expected: %q\nActual: %q", tt.reasons, de.Reasons)
+			}
+		})
+	}
+
+	_, predicates, err := p.Apply("tenant", claims, query.MustParse("select * from invoice join orders on invoice.order_id = orders.id"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var actual []string
+	for _, pred := range predicates {
+		actual = append(actual, pred.Table+": "+query.FormatExpr(pred.Expr))
+	}
+	expected := []string{
+		"invoice: invoice.tenant_id = '42' AND invoice.region = 'eu'",
+		"orders: orders.tenant_id = '42'",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected: %q\nActual: %q", expected, actual)
+	}
+
+	_, _, err = p.Apply("tenant", nil, query.MustParse("select * from orders"), nil)
+	if de, ok := err.(*policy.DeniedError); !ok || de.Reasons[0] != "claim tenant required by row filter on orders is missing" {
+		t.Errorf("Expected a missing claim to be denied, got: %v", err)
+	}
+}
+
+func TestApplyTypedClaims(t *testing.T) {
+	p, err := policy.Parse([]byte(rowFilterConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &pb.DatabaseSchema{Schemas: []*pb.Schema{{Name: "public", Tables: []*pb.Table{
+		{Name: "orders", Columns: []*pb.Column{{Name: "id", Type: "integer"}, {Name: "tenant_id", Type: "bigint"}}},
+	}}}}
+	table := []struct {
+		statement string
+		expected  string
+	}{
+		{"select * from orders", "SELECT * FROM orders WHERE orders.tenant_id = INTEGER '42'"},
+		{"select * from public.orders", "SELECT * FROM public.orders WHERE public.orders.tenant_id = INTEGER '42'"},
+		{"insert into orders (id) values (1)", "INSERT INTO orders (id, tenant_id) VALUES (1, INTEGER '42')"},
+	}
+	for _, tt := range table {
+		t.Run(tt.statement, func(t *testing.T) {
+			applied, _, err := p.Apply("tenant", map[string]string{"tenant": "42"}, query.MustParse(tt.statement), schema)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual := query.Format(applied); actual != tt.expected {
+				t.Errorf("Expected: %s\nActual: %s", tt.expected, actual)
+			}
+		})
+	}
+
+	_, _, err = p.Apply("tenant", map[string]string{"tenant": "acme"}, query.MustParse("select * from orders"), schema)
+	expected := `claim tenant required by row filter on orders isn't valid for tenant_id: "acme" isn't an integer`
+	if de, ok := err.(*policy.DeniedError); !ok || de.Reasons[0] != expected {
+		t.Errorf("Expected an invalid claim to be denied, got: %v", err)
+	}
+}
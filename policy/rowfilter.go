@@ -0,0 +1,322 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/proto"
+)
+
+// claimsTable qualifies columns in a row filter which refer to claims.
+const claimsTable = "claims"
+
+// parseFilter parses a row filter, checking that its columns refer either to
+// claims or, unqualified, to the granted table.
+func parseFilter(where string) (*pb.Expr, error) {
+	filter, err := query.ParseExpr(where)
+	if err != nil {
+		return nil, err
+	}
+	var check func(e *pb.Expr) error
+	check = func(e *pb.Expr) error {
+		switch e.GetExpr().(type) {
+		case *pb.Expr_Col:
+			col := e.GetCol()
+			if col.Schema != "" || (col.Table != "" && col.Table != claimsTable) {
+				return fmt.Errorf("column %s must be unqualified, or qualified with %s", query.FormatExpr(e), claimsTable)
+			}
+		case *pb.Expr_UnaryExpr:
+			return check(e.GetUnaryExpr().Expr)
+		case *pb.Expr_BinaryExpr:
+			err := check(e.GetBinaryExpr().Expr1)
+			if err != nil {
+				return err
+			}
+			return check(e.GetBinaryExpr().Expr2)
+		}
+		return nil
+	}
+	return filter, check(filter)
+}
+
+// Apply returns a copy of the statement restricted by the row filters in the
+// principal's grants, with claims in the filters replaced by the caller's
+// claims. A claim compared with a column is given the column's type in schema,
+// as by grpcdb.Literal, and other claims are strings:
+//
+//   - Selects, updates and deletes have each filter ANDed into their WHERE
+//     clause, or for joined tables into the join's ON clause.
+//   - Updates may not set columns used by a filter.
+//   - Inserts must satisfy filters made of column = value conditions. Columns
+//     which aren't given are added with the required value, and other values
+//     are rejected. Filters which aren't of that form can't be checked, so
+//     inserts into those tables are denied, as are inserts from a select into
+//     any filtered table.
+//
+// It also returns the filters it applied, bound to the claims. It returns a
+// *DeniedError if the statement can't be made to satisfy the filters,
+// including when a claim used by a filter is missing or isn't a valid value
+// for the column it's compared with. The statement should already have been
+// allowed by Check.
+func (p *Policy) Apply(principal string, claims map[string]string, s *pb.Statement, schema *pb.DatabaseSchema) (*pb.Statement, []Predicate, error) {
+	s = proto.Clone(s).(*pb.Statement)
+	a := &applier{checker: checker{policy: p, principal: principal}, claims: claims, schema: schema}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		a.selectStatement(s.GetSelect())
+	case *pb.Statement_Insert:
+		a.insertStatement(s.GetInsert())
+	case *pb.Statement_Update:
+		a.updateStatement(s.GetUpdate())
+	case *pb.Statement_Delete:
+		a.deleteStatement(s.GetDelete())
+	}
+	if len(a.reasons) > 0 {
+		return nil, nil, &DeniedError{Principal: principal, Reasons: a.reasons}
+	}
+	return s, a.predicates, nil
+}
+
+// Predicate is a row filter applied to a statement.
+type Predicate struct {
+	Table string // as written in the statement
+	Expr  *pb.Expr
+}
+
+type applier struct {
+	checker
+	claims     map[string]string
+	schema     *pb.DatabaseSchema
+	predicates []Predicate
+}
+
+// filters returns the row filters on a table.
+func (a *applier) filters(name string) []*pb.Expr {
+	qualified, ok := a.policy.qualify(name)
+	if !ok {
+		a.deny("can't apply row filters to table %q", name)
+		return nil
+	}
+	return a.policy.grant(a.principal, qualified).getFilters()
+}
+
+func (tg *tableGrant) getFilters() []*pb.Expr {
+	if tg == nil {
+		return nil
+	}
+	return tg.filters
+}
+
+// bind returns a copy of filter on granted with claims replaced by their values
+// and columns qualified with table as written in the statement.
+func (a *applier) bind(filter *pb.Expr, granted, table string) *pb.Expr {
+	var schema string
+	if m := identifier.FindStringSubmatch(table); m != nil {
+		schema, table = m[1], m[2]
+	}
+	// operand binds e, giving it the type of other if it's a claim compared
+	// with a column
+	var bind func(e *pb.Expr) *pb.Expr
+	operand := func(e, other *pb.Expr) *pb.Expr {
+		col := e.GetCol()
+		if col == nil || col.Table != claimsTable {
+			return bind(e)
+		}
+		value, ok := a.claims[col.Column]
+		if !ok {
+			a.deny("claim %s required by row filter on %s is missing", col.Column, granted)
+		}
+		lit := &pb.Lit{Lit: &pb.Lit_Str{Str: value}}
+		if c := other.GetCol(); ok && c != nil && c.Table == "" && a.schema != nil {
+			qualified, _ := a.policy.qualify(granted)
+			typed, err := grpcdb.Literal(a.schema, qualified, c.Column, value)
+			if err != nil {
+				a.deny("claim %s required by row filter on %s isn't valid for %s: %v", col.Column, granted, c.Column, err)
+			} else {
+				lit = typed
+			}
+		}
+		return &pb.Expr{Expr: &pb.Expr_Lit{Lit: lit}}
+	}
+	bind = func(e *pb.Expr) *pb.Expr {
+		switch e.GetExpr().(type) {
+		case *pb.Expr_Col:
+			col := e.GetCol()
+			if col.Table == claimsTable {
+				return operand(e, nil)
+			}
+			return &pb.Expr{Expr: &pb.Expr_Col{Col: &pb.Col{Schema: schema, Table: table, Column: col.Column}}}
+		case *pb.Expr_UnaryExpr:
+			ue := e.GetUnaryExpr()
+			return &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: ue.Op, Expr: bind(ue.Expr)}}}
+		case *pb.Expr_BinaryExpr:
+			be := e.GetBinaryExpr()
+			return &pb.Expr{Expr: &pb.Expr_BinaryExpr{BinaryExpr: &pb.BinaryExpr{Expr1: operand(be.Expr1, be.Expr2), Op: be.Op, Expr2: operand(be.Expr2, be.Expr1)}}}
+		}
+		return proto.Clone(e).(*pb.Expr)
+	}
+	return bind(filter)
+}
+
+// and ANDs the filters on table into e.
+func (a *applier) and(e *pb.Expr, table string) *pb.Expr {
+	for _, filter := range a.filters(table) {
+		bound := a.bind(filter, table, table)
+		a.predicates = append(a.predicates, Predicate{Table: table, Expr: bound})
+		if e == nil {
+			e = bound
+		} else {
+			e = &pb.Expr{Expr: &pb.Expr_BinaryExpr{BinaryExpr: &pb.BinaryExpr{Expr1: e, Op: pb.BinaryOp_AND, Expr2: bound}}}
+		}
+	}
+	return e
+}
+
+func (a *applier) selectStatement(sel *pb.Select) {
+	sel.Where = a.and(sel.Where, sel.From)
+	for _, j := range sel.Join {
+		switch j.JoinType {
+		case pb.JoinType_RIGHT, pb.JoinType_RIGHT_OUTER:
+			// the joined table's rows are kept even when ON doesn't match,
+			// so the filter must go in the WHERE clause
+			sel.Where = a.and(sel.Where, j.Table)
+		default:
+			j.On = a.and(j.On, j.Table)
+		}
+	}
+}
+
+func schemaTableName(st *pb.SchemaTable) string {
+	if st.GetSchema() != "" {
+		return st.Schema + "." + st.Table
+	}
+	return st.GetTable()
+}
+
+func (a *applier) updateStatement(upd *pb.Update) {
+	table := schemaTableName(upd.Table)
+	for _, filter := range a.filters(table) {
+		for _, set := range upd.Set {
+			if references(filter, set.Column) {
+				a.deny("may not update %s.%s, which is used by a row filter", table, set.Column)
+			}
+		}
+	}
+	upd.Where = a.and(upd.Where, table)
+}
+
+func (a *applier) deleteStatement(del *pb.Delete) {
+	del.Where = a.and(del.Where, schemaTableName(del.From))
+}
+
+// references returns true if the filter uses column from the granted table.
+func references(filter *pb.Expr, column string) bool {
+	switch filter.GetExpr().(type) {
+	case *pb.Expr_Col:
+		col := filter.GetCol()
+		return col.Table == "" && col.Column == column
+	case *pb.Expr_UnaryExpr:
+		return references(filter.GetUnaryExpr().Expr, column)
+	case *pb.Expr_BinaryExpr:
+		return references(filter.GetBinaryExpr().Expr1, column) || references(filter.GetBinaryExpr().Expr2, column)
+	}
+	return false
+}
+
+var errNotEqualities = errors.New("not a conjunction of column = value conditions")
+
+// equalities splits a bound filter made of column = value conditions joined
+// by AND into a map of column to value.
+func equalities(e *pb.Expr, values map[string]*pb.Expr) error {
+	be := e.GetBinaryExpr()
+	switch be.GetOp() {
+	case pb.BinaryOp_AND:
+		err := equalities(be.Expr1, values)
+		if err != nil {
+			return err
+		}
+		return equalities(be.Expr2, values)
+	case pb.BinaryOp_EQ:
+		col, value := be.Expr1.GetCol(), be.Expr2
+		if col == nil {
+			col, value = be.Expr2.GetCol(), be.Expr1
+		}
+		if col == nil || value.GetLit() == nil {
+			return errNotEqualities
+		}
+		if existing, ok := values[col.Column]; ok && !proto.Equal(existing, value) {
+			return errors.New("requires conflicting values")
+		}
+		values[col.Column] = value
+		return nil
+	}
+	return errNotEqualities
+}
+
+func (a *applier) insertStatement(ins *pb.Insert) {
+	table := schemaTableName(ins.Into)
+	filters := a.filters(table)
+	if sel := ins.GetToInsert().GetSelect(); sel != nil {
+		if len(filters) > 0 {
+			a.deny("may not insert into %s from a select, as its row filter can't be checked", table)
+		}
+		a.selectStatement(sel)
+		return
+	}
+	required := make(map[string]*pb.Expr)
+	for _, filter := range filters {
+		// claims are bound as literals and columns left unqualified
+		bound := a.bind(filter, table, "")
+		err := equalities(bound, required)
+		if err != nil {
+			a.deny("may not insert into %s, as its row filter %s can't be checked: %v", table, query.FormatExpr(filter), err)
+			return
+		}
+		a.predicates = append(a.predicates, Predicate{Table: table, Expr: bound})
+	}
+	rows := ins.GetToInsert().GetValues().GetRows()
+	for i, column := range ins.Columns {
+		value, ok := required[column]
+		if !ok {
+			continue
+		}
+		for j, row := range rows {
+			if i < len(row.Values) && !proto.Equal(row.Values[i], value) {
+				a.deny("row %d: %s must be %s to satisfy the row filter on %s", j, column, query.FormatExpr(value), table)
+			}
+		}
+		delete(required, column)
+	}
+	// add the remaining required columns, in a stable order
+	for _, filter := range filters {
+		for _, column := range filterColumns(filter) {
+			value, ok := required[column]
+			if !ok {
+				continue
+			}
+			ins.Columns = append(ins.Columns, column)
+			for _, row := range rows {
+				row.Values = append(row.Values, value)
+			}
+			delete(required, column)
+		}
+	}
+}
+
+// filterColumns returns the columns of the granted table used by the filter, in
+// the order they're written.
+func filterColumns(filter *pb.Expr) []string {
+	switch filter.GetExpr().(type) {
+	case *pb.Expr_Col:
+		if filter.GetCol().Table == "" {
+			return []string{filter.GetCol().Column}
+		}
+	case *pb.Expr_UnaryExpr:
+		return filterColumns(filter.GetUnaryExpr().Expr)
+	case *pb.Expr_BinaryExpr:
+		return append(filterColumns(filter.GetBinaryExpr().Expr1), filterColumns(filter.GetBinaryExpr().Expr2)...)
+	}
+	return nil
+}
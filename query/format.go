@@ -0,0 +1,468 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format writes the statement in the text form accepted by Parse, such that
+// Parse(Format(s)) is equal to s for any valid statement. Missing parts of an
+// invalid statement are written as <nil>.
+func Format(s *pb.Statement) string {
+	f := &formatter{}
+	f.statement(s)
+	return f.sb.String()
+}
+
+// Pretty is like Format, but writes each clause on its own line and each
+// condition joined by AND or OR in a WHERE, HAVING or ON clause on its own
+// indented line, for logs and other places people read statements. The result
+// can still be parsed by Parse.
+func Pretty(s *pb.Statement) string {
+	f := &formatter{pretty: true}
+	f.statement(s)
+	return f.sb.String()
+}
+
+// FormatExpr writes the expression in the text form accepted by Parse.
+func FormatExpr(e *pb.Expr) string {
+	f := &formatter{}
+	f.expr(e, 0)
+	return f.sb.String()
+}
+
+const indent = "    "
+
+type formatter struct {
+	sb     strings.Builder
+	pretty bool
+}
+
+// clause starts a new clause, on a new line if pretty printing.
+func (f *formatter) clause(keyword string) {
+	if f.pretty {
+		f.write("\n", keyword)
+	} else {
+		f.write(" ", keyword)
+	}
+}
+
+// condition writes a WHERE, HAVING or ON condition. When pretty printing, a
+// chain of ANDs or ORs is split so that each operand is on its own line.
+func (f *formatter) condition(e *pb.Expr) {
+	be := e.GetBinaryExpr()
+	if !f.pretty || be == nil || (be.Op != pb.BinaryOp_AND && be.Op != pb.BinaryOp_OR) {
+		f.expr(e, 0)
+		return
+	}
+	// flatten the left deep chain built by repeated ANDs or ORs
+	prec := precedence(e)
+	operands := []*pb.Expr{be.Expr2}
+	first := be.Expr1
+	for first.GetBinaryExpr().GetOp() == be.Op {
+		operands = append(operands, first.GetBinaryExpr().Expr2)
+		first = first.GetBinaryExpr().Expr1
+	}
+	f.expr(first, prec)
+	for i := len(operands) - 1; i >= 0; i-- {
+		f.write("\n", indent, strings.TrimSpace(binaryOpText[be.Op]), " ")
+		f.expr(operands[i], prec+1)
+	}
+}
+
+func (f *formatter) write(ss ...string) {
+	for _, s := range ss {
+		f.sb.WriteString(s)
+	}
+}
+
+func (f *formatter) statement(s *pb.Statement) {
+	switch s.GetStatement().(type) {
+	case *pb.Statement_Select:
+		f.selectStatement(s.GetSelect())
+	case *pb.Statement_Insert:
+		f.insertStatement(s.GetInsert())
+	case *pb.Statement_Update:
+		f.updateStatement(s.GetUpdate())
+	case *pb.Statement_Delete:
+		f.deleteStatement(s.GetDelete())
+	default:
+		f.write("<nil>")
+	}
+}
+
+func (f *formatter) selectStatement(sel *pb.Select) {
+	if sel == nil {
+		f.write("<nil>")
+		return
+	}
+	f.write("SELECT ")
+	if sel.DistinctAll == pb.DistinctAll_ALL {
+		f.write("ALL ")
+	}
+	f.write(strings.Join(sel.ResultColumn, ", "))
+	f.clause("FROM ")
+	f.write(name(sel.From))
+	for _, j := range sel.Join {
+		f.join(j)
+	}
+	if sel.Where != nil {
+		f.clause("WHERE ")
+		f.condition(sel.Where)
+	}
+	if len(sel.GroupBy) > 0 {
+		f.clause("GROUP BY ")
+		f.exprList(sel.GroupBy)
+	}
+	if sel.Having != nil {
+		f.clause("HAVING ")
+		f.condition(sel.Having)
+	}
+	for i, term := range sel.OrderBy {
+		if i == 0 {
+			f.clause("ORDER BY ")
+		} else {
+			f.write(", ")
+		}
+		f.expr(term.GetBy(), 0)
+		if term.GetDir() == pb.OrderingDirection_DESC {
+			f.write(" DESC")
+		}
+	}
+	if sel.Limit != 0 {
+		f.clause("LIMIT ")
+		f.write(strconv.FormatUint(sel.Limit, 10))
+	}
+	if sel.Offset != 0 {
+		f.clause("OFFSET ")
+		f.write(strconv.FormatUint(sel.Offset, 10))
+	}
+}
+
+func (f *formatter) join(j *pb.Join) {
+	f.clause("")
+	if j.GetNatural() {
+		f.write("NATURAL ")
+	}
+	switch j.GetJoinType() {
+	case pb.JoinType_LEFT:
+		f.write("LEFT ")
+	case pb.JoinType_LEFT_OUTER:
+		f.write("LEFT OUTER ")
+	case pb.JoinType_RIGHT:
+		f.write("RIGHT ")
+	case pb.JoinType_RIGHT_OUTER:
+		f.write("RIGHT OUTER ")
+	case pb.JoinType_CROSS:
+		f.write("CROSS ")
+	}
+	f.write("JOIN ", name(j.GetTable()), " ON ")
+	f.condition(j.GetOn())
+}
+
+func (f *formatter) insertStatement(ins *pb.Insert) {
+	if ins == nil {
+		f.write("<nil>")
+		return
+	}
+	if ins.Insert == pb.InsertType_REPLACE {
+		f.write("REPLACE INTO ")
+	} else {
+		f.write("INSERT INTO ")
+	}
+	f.schemaTable(ins.Into)
+	f.write(" (")
+	for i, c := range ins.Columns {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(ident(c))
+	}
+	f.write(")")
+	switch ins.GetToInsert().GetInsert().(type) {
+	case *pb.ToInsert_Values:
+		f.clause("VALUES")
+		for i, row := range ins.ToInsert.GetValues().Rows {
+			if i > 0 {
+				f.write(",")
+			}
+			f.listItem()
+			f.write("(")
+			f.exprList(row.GetValues())
+			f.write(")")
+		}
+	case *pb.ToInsert_Select:
+		f.clause("")
+		f.selectStatement(ins.ToInsert.GetSelect())
+	default:
+		f.clause("<nil>")
+	}
+}
+
+var updateOr = map[pb.UpdateType]string{
+	pb.UpdateType_OR_ROLLBACK: "OR ROLLBACK ",
+	pb.UpdateType_OR_ABORT:    "OR ABORT ",
+	pb.UpdateType_OR_REPLACE:  "OR REPLACE ",
+	pb.UpdateType_OR_FAIL:     "OR FAIL ",
+	pb.UpdateType_OR_IGNORE:   "OR IGNORE ",
+}
+
+func (f *formatter) updateStatement(upd *pb.Update) {
+	if upd == nil {
+		f.write("<nil>")
+		return
+	}
+	f.write("UPDATE ", updateOr[upd.UpdateOr])
+	f.schemaTable(upd.Table)
+	f.clause("SET")
+	for i, set := range upd.Set {
+		if i > 0 {
+			f.write(",")
+		}
+		f.listItem()
+		f.write(ident(set.GetColumn()), " = ")
+		f.expr(set.GetTo(), 0)
+	}
+	if upd.Where != nil {
+		f.clause("WHERE ")
+		f.condition(upd.Where)
+	}
+	if upd.AllowFullTable {
+		f.clause("ALLOW FULL TABLE")
+	}
+}
+
+func (f *formatter) deleteStatement(del *pb.Delete) {
+	if del == nil {
+		f.write("<nil>")
+		return
+	}
+	f.write("DELETE FROM ")
+	f.schemaTable(del.From)
+	if del.Where != nil {
+		f.clause("WHERE ")
+		f.condition(del.Where)
+	}
+	if del.AllowFullTable {
+		f.clause("ALLOW FULL TABLE")
+	}
+}
+
+// listItem starts an item in the list of rows to insert or columns to set, on
+// a new indented line if pretty printing.
+func (f *formatter) listItem() {
+	if f.pretty {
+		f.write("\n", indent)
+	} else {
+		f.write(" ")
+	}
+}
+
+func (f *formatter) schemaTable(st *pb.SchemaTable) {
+	if st == nil {
+		f.write("<nil>")
+		return
+	}
+	if st.Schema != "" {
+		f.write(ident(st.Schema), ".")
+	}
+	f.write(ident(st.Table))
+}
+
+func (f *formatter) exprList(exprs []*pb.Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expr(e, 0)
+	}
+}
+
+// precedence returns how tightly the expression binds, so that it can be
+// parenthesised where required.
+func precedence(e *pb.Expr) int {
+	switch e.GetExpr().(type) {
+	case *pb.Expr_BinaryExpr:
+		switch e.GetBinaryExpr().Op {
+		case pb.BinaryOp_OR:
+			return precOr
+		case pb.BinaryOp_AND:
+			return precAnd
+		}
+		return precCompare
+	case *pb.Expr_UnaryExpr:
+		if e.GetUnaryExpr().Op == pb.UnaryOp_NOT {
+			return precNot
+		}
+		return precUnary
+	case *pb.Expr_Lit:
+		// negative numbers are parsed as a literal rather than a negation
+		if e.GetLit().GetNum() < 0 {
+			return precUnary
+		}
+	}
+	return precPrimary
+}
+
+var binaryOpText = map[pb.BinaryOp]string{
+	pb.BinaryOp_EQ:     " = ",
+	pb.BinaryOp_NE:     " != ",
+	pb.BinaryOp_GT:     " > ",
+	pb.BinaryOp_GTE:    " >= ",
+	pb.BinaryOp_LT:     " < ",
+	pb.BinaryOp_LTE:    " <= ",
+	pb.BinaryOp_AND:    " AND ",
+	pb.BinaryOp_OR:     " OR ",
+	pb.BinaryOp_IS:     " IS ",
+	pb.BinaryOp_IS_NOT: " IS NOT ",
+}
+
+// expr writes the expression, in parentheses if it binds less tightly than
+// min.
+func (f *formatter) expr(e *pb.Expr, min int) {
+	if e == nil {
+		f.write("<nil>")
+		return
+	}
+	prec := precedence(e)
+	if prec < min {
+		f.write("(")
+		defer f.write(")")
+	}
+	switch e.Expr.(type) {
+	case *pb.Expr_Lit:
+		f.lit(e.GetLit())
+	case *pb.Expr_Col:
+		col := e.GetCol()
+		if col.Schema != "" {
+			f.write(ident(col.Schema), ".")
+		}
+		if col.Table != "" {
+			f.write(ident(col.Table), ".")
+		}
+		f.write(ident(col.Column))
+	case *pb.Expr_Param:
+		param := e.GetParam()
+		f.write(":", ident(param.Name))
+		if param.Type != pb.ParamType_ANY {
+			f.write("::", strings.ToLower(param.Type.String()))
+		}
+	case *pb.Expr_UnaryExpr:
+		ue := e.GetUnaryExpr()
+		switch ue.Op {
+		case pb.UnaryOp_NOT:
+			f.write("NOT ")
+			f.expr(ue.Expr, precNot)
+			return
+		case pb.UnaryOp_POS:
+			f.write("+")
+		case pb.UnaryOp_NEG:
+			f.write("-")
+			// -(1) is a negation, whereas -1 is a literal
+			if _, ok := ue.GetExpr().GetLit().GetLit().(*pb.Lit_Num); ok {
+				f.write("(")
+				f.expr(ue.Expr, 0)
+				f.write(")")
+				return
+			}
+		default:
+			f.write("<unknown> ")
+		}
+		f.expr(ue.Expr, precPrimary)
+	case *pb.Expr_BinaryExpr:
+		be := e.GetBinaryExpr()
+		// operators are left associative, so the right operand needs
+		// parentheses if it binds as loosely as this one
+		f.expr(be.Expr1, prec)
+		op, ok := binaryOpText[be.Op]
+		if !ok {
+			op = " <unknown> "
+		}
+		f.write(op)
+		f.expr(be.Expr2, prec+1)
+	default:
+		f.write("<nil>")
+	}
+}
+
+func (f *formatter) lit(l *pb.Lit) {
+	switch l.GetLit().(type) {
+	case *pb.Lit_Str:
+		f.write(quote(l.GetStr()))
+	case *pb.Lit_Num:
+		f.write(strconv.FormatFloat(l.GetNum(), 'g', -1, 64))
+	case *pb.Lit_Int:
+		// a bare number is parsed as a num
+		f.write("INTEGER ", quote(strconv.FormatInt(l.GetInt(), 10)))
+	case *pb.Lit_Timestamp:
+		t, err := ptypes.Timestamp(l.GetTimestamp())
+		if err != nil {
+			f.write("<", err.Error(), ">")
+			return
+		}
+		f.write("TIMESTAMP ", quote(t.Format(time.RFC3339Nano)))
+	case *pb.Lit_Date:
+		d := l.GetDate()
+		f.write("DATE ", quote(fmt.Sprintf("%04d-%02d-%02d", d.GetYear(), d.GetMonth(), d.GetDay())))
+	case *pb.Lit_Uuid:
+		f.write("UUID ", quote(l.GetUuid()))
+	case *pb.Lit_Decimal:
+		f.write("DECIMAL ", quote(l.GetDecimal()))
+	case *pb.Lit_Json:
+		f.write("JSON ", quote(l.GetJson()))
+	case *pb.Lit_Array:
+		f.write("ARRAY[")
+		for i, e := range l.GetArray().GetElements() {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.lit(e)
+		}
+		f.write("]")
+	case *pb.Lit_Blob:
+		f.write("X'", hex.EncodeToString(l.GetBlob()), "'")
+	case *pb.Lit_Null:
+		f.write("NULL")
+	case *pb.Lit_Boolean:
+		f.write(strings.ToUpper(strconv.FormatBool(l.GetBoolean())))
+	case *pb.Lit_CurrentTime:
+		f.write("CURRENT_TIME")
+	case *pb.Lit_CurrentDate:
+		f.write("CURRENT_DATE")
+	case *pb.Lit_CurrentTimestamp:
+		f.write("CURRENT_TIMESTAMP")
+	default:
+		f.write("<nil>")
+	}
+}
+
+// quote writes s as a string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// ident quotes the identifier if it isn't a plain identifier or is a keyword.
+func ident(s string) string {
+	plain := s != "" && isIdentStart(s[0]) && !keywords[strings.ToUpper(s)]
+	for i := 1; plain && i < len(s); i++ {
+		plain = isIdentPart(s[i])
+	}
+	if plain {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// name quotes each dot separated part of a name, such as schema.table.
+func name(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = ident(p)
+	}
+	return strings.Join(parts, ".")
+}
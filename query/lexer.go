@@ -0,0 +1,251 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenBlob
+	tokenNumber
+	tokenSymbol
+)
+
+func (tt tokenType) String() string {
+	switch tt {
+	case tokenEOF:
+		return "end of input"
+	case tokenIdent, tokenQuotedIdent:
+		return "identifier"
+	case tokenString:
+		return "string"
+	case tokenBlob:
+		return "blob"
+	case tokenNumber:
+		return "number"
+	}
+	return "symbol"
+}
+
+// Position is a location in the text being parsed. Line and Column start at 1,
+// and Column counts runes rather than bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+type token struct {
+	typ   tokenType
+	text  string // the token as written
+	value string // the unquoted value of identifiers, strings and blobs
+	pos   Position
+	end   int // offset just past the end of the token
+}
+
+func (t token) String() string {
+	if t.typ == tokenEOF {
+		return t.typ.String()
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keyword returns true if the token is the given keyword, ignoring case.
+// Quoted identifiers are never keywords.
+func (t token) keyword(kw string) bool {
+	return t.typ == tokenIdent && strings.EqualFold(t.text, kw)
+}
+
+func (t token) symbol(s string) bool {
+	return t.typ == tokenSymbol && t.text == s
+}
+
+// symbols are matched longest first.
+var symbols = []string{"!=", "<>", "<=", ">=", "||", "::", "(", ")", "[", "]", ",", ".", ";", ":", "*", "=", "<", ">", "+", "-", "/", "%"}
+
+type lexer struct {
+	input string
+	pos   Position
+}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: input, pos: Position{Line: 1, Column: 1}}
+	var tokens []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.typ == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek(n int) string {
+	end := l.pos.Offset + n
+	if end > len(l.input) {
+		end = len(l.input)
+	}
+	return l.input[l.pos.Offset:end]
+}
+
+// advance moves past n bytes, tracking lines and columns.
+func (l *lexer) advance(n int) {
+	for _, r := range l.input[l.pos.Offset : l.pos.Offset+n] {
+		if r == '\n' {
+			l.pos.Line++
+			l.pos.Column = 1
+		} else {
+			l.pos.Column++
+		}
+	}
+	l.pos.Offset += n
+}
+
+func (l *lexer) errorf(pos Position, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos.Offset < len(l.input) {
+		switch c := l.input[l.pos.Offset]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.advance(1)
+		case l.peek(2) == "--":
+			n := strings.IndexByte(l.input[l.pos.Offset:], '\n')
+			if n < 0 {
+				n = len(l.input) - l.pos.Offset
+			}
+			l.advance(n)
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+	start := l.pos
+	if start.Offset >= len(l.input) {
+		return token{typ: tokenEOF, pos: start, end: start.Offset}, nil
+	}
+	rest := l.input[start.Offset:]
+	c := rest[0]
+	tok := func(typ tokenType, n int, value string) (token, error) {
+		l.advance(n)
+		return token{typ: typ, text: rest[:n], value: value, pos: start, end: l.pos.Offset}, nil
+	}
+	switch {
+	case (c == 'x' || c == 'X') && len(rest) > 1 && rest[1] == '\'':
+		n, value, ok := quoted(rest[1:], '\'')
+		if !ok {
+			return token{}, l.errorf(start, "unterminated blob")
+		}
+		return tok(tokenBlob, n+1, value)
+	case isIdentStart(c):
+		n := 1
+		for n < len(rest) && isIdentPart(rest[n]) {
+			n++
+		}
+		return tok(tokenIdent, n, rest[:n])
+	case c == '"':
+		n, value, ok := quoted(rest, '"')
+		if !ok {
+			return token{}, l.errorf(start, "unterminated quoted identifier")
+		}
+		if value == "" {
+			return token{}, l.errorf(start, "empty quoted identifier")
+		}
+		return tok(tokenQuotedIdent, n, value)
+	case c == '\'':
+		n, value, ok := quoted(rest, '\'')
+		if !ok {
+			return token{}, l.errorf(start, "unterminated string")
+		}
+		return tok(tokenString, n, value)
+	case isDigit(c) || (c == '.' && len(rest) > 1 && isDigit(rest[1])):
+		n := number(rest)
+		if n < len(rest) && isIdentStart(rest[n]) {
+			return token{}, l.errorf(start, "invalid number %q", rest[:n+1])
+		}
+		return tok(tokenNumber, n, rest[:n])
+	}
+	for _, s := range symbols {
+		if strings.HasPrefix(rest, s) {
+			return tok(tokenSymbol, len(s), s)
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return token{}, l.errorf(start, "unexpected character %q", r)
+}
+
+// quoted returns the length and unescaped value of a string starting with
+// quote, in which the quote is escaped by doubling it.
+func quoted(s string, quote byte) (int, string, bool) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			sb.WriteByte(quote)
+			i++
+			continue
+		}
+		return i + 1, sb.String(), true
+	}
+	return 0, "", false
+}
+
+// number returns the length of the number at the start of s, which is digits
+// with an optional fraction and exponent.
+func number(s string) int {
+	n := 0
+	digits := func() {
+		for n < len(s) && isDigit(s[n]) {
+			n++
+		}
+	}
+	digits()
+	if n < len(s) && s[n] == '.' {
+		n++
+		digits()
+	}
+	if n < len(s) && (s[n] == 'e' || s[n] == 'E') {
+		m := n + 1
+		if m < len(s) && (s[m] == '+' || s[m] == '-') {
+			m++
+		}
+		if m < len(s) && isDigit(s[m]) {
+			n = m
+			digits()
+		}
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,896 @@
+// Package query parses and formats a SQL-like text form of grpcdb statements,
+// for use where building statements in Go isn't an option: other languages,
+// the command line and configuration files.
+//
+//	select full_name from person where birth > '2000-01-01' order by birth desc
+//
+// Keywords are case insensitive. Identifiers which clash with keywords or
+// contain other characters may be double quoted. Strings are single quoted,
+// with quotes escaped by doubling them, and blobs are written X'cafe'. Other
+// literals are written as their type followed by a string, e.g. DATE
+// '2000-01-01', TIMESTAMP '2000-01-01T00:00:00Z', INTEGER, UUID, DECIMAL and
+// JSON, and arrays as ARRAY[1, 2]. Updates and deletes which may change every
+// row of a table end with ALLOW FULL TABLE.
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is a syntax error at a position in the text.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// keywords can't be used as unquoted identifiers.
+var keywords = map[string]bool{
+	"ABORT": true, "ALL": true, "AND": true, "AS": true, "ASC": true, "BY": true,
+	"CROSS": true, "CURRENT_DATE": true, "CURRENT_TIME": true,
+	"CURRENT_TIMESTAMP": true, "DELETE": true, "DESC": true, "DISTINCT": true,
+	"FAIL": true, "FALSE": true, "FROM": true, "GROUP": true, "HAVING": true,
+	"IGNORE": true, "INNER": true, "INSERT": true, "INTO": true, "IS": true,
+	"JOIN": true, "LEFT": true, "LIMIT": true, "NATURAL": true, "NOT": true,
+	"NULL": true, "OFFSET": true, "ON": true, "OR": true, "ORDER": true,
+	"OUTER": true, "REPLACE": true, "RIGHT": true, "ROLLBACK": true,
+	"SELECT": true, "SET": true, "TRUE": true, "UPDATE": true, "VALUES": true,
+	"WHERE": true,
+}
+
+// Parse parses a single statement, which may be followed by a semicolon.
+func Parse(input string) (*pb.Statement, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	s, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().typ != tokenEOF {
+		return nil, p.unexpected("end of statement")
+	}
+	return s, nil
+}
+
+// ParseExpr parses a single expression, such as a WHERE clause.
+func ParseExpr(input string) (*pb.Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokenEOF {
+		return nil, p.unexpected("end of expression")
+	}
+	return e, nil
+}
+
+// MustParse is like Parse but panics if the statement can't be parsed. It's
+// intended for statements which are constants in the program.
+func MustParse(input string) *pb.Statement {
+	s, err := Parse(input)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.i]
+	if t.typ != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) unexpected(expected string) error {
+	return p.errorf(p.peek(), "expected %s, found %s", expected, p.peek())
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.peek().keyword(kw) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kws ...string) error {
+	for _, kw := range kws {
+		if !p.acceptKeyword(kw) {
+			return p.unexpected(kw)
+		}
+	}
+	return nil
+}
+
+func (p *parser) acceptSymbol(s string) bool {
+	if p.peek().symbol(s) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(s string) error {
+	if !p.acceptSymbol(s) {
+		return p.unexpected(fmt.Sprintf("%q", s))
+	}
+	return nil
+}
+
+// isIdent returns true if t can be used as an identifier.
+func isIdent(t token) bool {
+	return t.typ == tokenQuotedIdent || (t.typ == tokenIdent && !keywords[strings.ToUpper(t.text)])
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if !isIdent(t) {
+		return "", p.unexpected("identifier")
+	}
+	p.advance()
+	if t.typ == tokenQuotedIdent {
+		return t.value, nil
+	}
+	return t.text, nil
+}
+
+// name parses identifiers separated by dots.
+func (p *parser) name() ([]string, error) {
+	var parts []string
+	for {
+		part, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		if !p.acceptSymbol(".") {
+			return parts, nil
+		}
+	}
+}
+
+func (p *parser) statement() (*pb.Statement, error) {
+	t := p.peek()
+	switch {
+	case t.keyword("SELECT"):
+		sel, err := p.selectStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Statement{Statement: &pb.Statement_Select{Select: sel}}, nil
+	case t.keyword("INSERT"), t.keyword("REPLACE"):
+		ins, err := p.insertStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Statement{Statement: &pb.Statement_Insert{Insert: ins}}, nil
+	case t.keyword("UPDATE"):
+		upd, err := p.updateStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Statement{Statement: &pb.Statement_Update{Update: upd}}, nil
+	case t.keyword("DELETE"):
+		del, err := p.deleteStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Statement{Statement: &pb.Statement_Delete{Delete: del}}, nil
+	}
+	return nil, p.unexpected("SELECT, INSERT, REPLACE, UPDATE or DELETE")
+}
+
+func (p *parser) selectStatement() (*pb.Select, error) {
+	err := p.expectKeyword("SELECT")
+	if err != nil {
+		return nil, err
+	}
+	sel := &pb.Select{}
+	if p.acceptKeyword("ALL") {
+		sel.DistinctAll = pb.DistinctAll_ALL
+	} else if t := p.peek(); t.keyword("DISTINCT") {
+		// DISTINCT is DistinctAll's zero value, so it can't be told apart
+		// from a plain SELECT, which is translated without it
+		return nil, p.errorf(t, "SELECT DISTINCT isn't supported")
+	}
+	for {
+		rc, err := p.resultColumn()
+		if err != nil {
+			return nil, err
+		}
+		sel.ResultColumn = append(sel.ResultColumn, rc)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	err = p.expectKeyword("FROM")
+	if err != nil {
+		return nil, err
+	}
+	from, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel.From = strings.Join(from, ".")
+	for {
+		join, err := p.join()
+		if err != nil {
+			return nil, err
+		}
+		if join == nil {
+			break
+		}
+		sel.Join = append(sel.Join, join)
+	}
+	if p.acceptKeyword("WHERE") {
+		sel.Where, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		err = p.expectKeyword("BY")
+		if err != nil {
+			return nil, err
+		}
+		sel.GroupBy, err = p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("HAVING") {
+			sel.Having, err = p.expr()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		err = p.expectKeyword("BY")
+		if err != nil {
+			return nil, err
+		}
+		for {
+			term := &pb.OrderingTerm{}
+			term.By, err = p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("DESC") {
+				term.Dir = pb.OrderingDirection_DESC
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			sel.OrderBy = append(sel.OrderBy, term)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		sel.Limit, err = p.uint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		sel.Offset, err = p.uint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// resultColumn returns the text of a result column, which is passed through to
+// the database as written. It runs to the next comma or FROM that isn't nested
+// in parentheses.
+func (p *parser) resultColumn() (string, error) {
+	start := p.peek()
+	depth := 0
+	var end int
+	for {
+		t := p.peek()
+		if t.typ == tokenEOF || t.symbol(";") || (depth == 0 && (t.symbol(",") || t.keyword("FROM"))) {
+			break
+		}
+		if t.symbol("(") {
+			depth++
+		} else if t.symbol(")") {
+			if depth == 0 {
+				return "", p.unexpected("result column")
+			}
+			depth--
+		}
+		end = p.advance().end
+	}
+	if p.peek() == start {
+		return "", p.unexpected("result column")
+	}
+	if depth > 0 {
+		return "", p.unexpected(`")"`)
+	}
+	return p.input[start.pos.Offset:end], nil
+}
+
+var joinTypes = []struct {
+	keywords []string
+	joinType pb.JoinType
+}{
+	{[]string{"LEFT", "OUTER"}, pb.JoinType_LEFT_OUTER},
+	{[]string{"LEFT"}, pb.JoinType_LEFT},
+	{[]string{"RIGHT", "OUTER"}, pb.JoinType_RIGHT_OUTER},
+	{[]string{"RIGHT"}, pb.JoinType_RIGHT},
+	{[]string{"CROSS"}, pb.JoinType_CROSS},
+	{[]string{"INNER"}, pb.JoinType_INNER},
+}
+
+// join returns nil if there's no join to parse.
+func (p *parser) join() (*pb.Join, error) {
+	start := p.i
+	j := &pb.Join{Natural: p.acceptKeyword("NATURAL")}
+	for _, jt := range joinTypes {
+		matched := true
+		for k, kw := range jt.keywords {
+			if p.i+k >= len(p.tokens) || !p.tokens[p.i+k].keyword(kw) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			p.i += len(jt.keywords)
+			j.JoinType = jt.joinType
+			break
+		}
+	}
+	if p.i == start && !p.peek().keyword("JOIN") {
+		return nil, nil
+	}
+	err := p.expectKeyword("JOIN")
+	if err != nil {
+		return nil, err
+	}
+	table, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	j.Table = strings.Join(table, ".")
+	err = p.expectKeyword("ON")
+	if err != nil {
+		return nil, err
+	}
+	j.On, err = p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (p *parser) insertStatement() (*pb.Insert, error) {
+	ins := &pb.Insert{}
+	if p.acceptKeyword("REPLACE") {
+		ins.Insert = pb.InsertType_REPLACE
+	} else {
+		p.advance() // INSERT
+	}
+	err := p.expectKeyword("INTO")
+	if err != nil {
+		return nil, err
+	}
+	ins.Into, err = p.schemaTable()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expectSymbol("(")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		ins.Columns = append(ins.Columns, column)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	err = p.expectSymbol(")")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("VALUES"):
+		values := &pb.Values{}
+		for {
+			err = p.expectSymbol("(")
+			if err != nil {
+				return nil, err
+			}
+			row, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expectSymbol(")")
+			if err != nil {
+				return nil, err
+			}
+			values.Rows = append(values.Rows, &pb.Row{Values: row})
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		ins.ToInsert = &pb.ToInsert{Insert: &pb.ToInsert_Values{Values: values}}
+	case p.peek().keyword("SELECT"):
+		sel, err := p.selectStatement()
+		if err != nil {
+			return nil, err
+		}
+		ins.ToInsert = &pb.ToInsert{Insert: &pb.ToInsert_Select{Select: sel}}
+	default:
+		return nil, p.unexpected("VALUES or SELECT")
+	}
+	return ins, nil
+}
+
+var updateTypes = map[string]pb.UpdateType{
+	"ROLLBACK": pb.UpdateType_OR_ROLLBACK,
+	"ABORT":    pb.UpdateType_OR_ABORT,
+	"REPLACE":  pb.UpdateType_OR_REPLACE,
+	"FAIL":     pb.UpdateType_OR_FAIL,
+	"IGNORE":   pb.UpdateType_OR_IGNORE,
+}
+
+func (p *parser) updateStatement() (*pb.Update, error) {
+	p.advance() // UPDATE
+	upd := &pb.Update{}
+	if p.acceptKeyword("OR") {
+		t := p.peek()
+		ut, ok := updateTypes[strings.ToUpper(t.text)]
+		if t.typ != tokenIdent || !ok {
+			return nil, p.unexpected("ROLLBACK, ABORT, REPLACE, FAIL or IGNORE")
+		}
+		p.advance()
+		upd.UpdateOr = ut
+	}
+	var err error
+	upd.Table, err = p.schemaTable()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expectKeyword("SET")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		set := &pb.Set{}
+		set.Column, err = p.ident()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expectSymbol("=")
+		if err != nil {
+			return nil, err
+		}
+		set.To, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+		upd.Set = append(upd.Set, set)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		upd.Where, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	upd.AllowFullTable, err = p.allowFullTable()
+	if err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+func (p *parser) deleteStatement() (*pb.Delete, error) {
+	err := p.expectKeyword("DELETE", "FROM")
+	if err != nil {
+		return nil, err
+	}
+	del := &pb.Delete{}
+	del.From, err = p.schemaTable()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		del.Where, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	del.AllowFullTable, err = p.allowFullTable()
+	if err != nil {
+		return nil, err
+	}
+	return del, nil
+}
+
+// allowFullTable parses the optional ALLOW FULL TABLE which ends an update or
+// delete. Its words aren't keywords, so they may still be used as identifiers.
+func (p *parser) allowFullTable() (bool, error) {
+	if !p.acceptKeyword("ALLOW") {
+		return false, nil
+	}
+	err := p.expectKeyword("FULL", "TABLE")
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *parser) schemaTable() (*pb.SchemaTable, error) {
+	t := p.peek()
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	switch len(name) {
+	case 1:
+		return &pb.SchemaTable{Table: name[0]}, nil
+	case 2:
+		return &pb.SchemaTable{Schema: name[0], Table: name[1]}, nil
+	}
+	return nil, p.errorf(t, "expected table or schema.table, found %s", strings.Join(name, "."))
+}
+
+func (p *parser) uint() (uint64, error) {
+	t := p.peek()
+	if t.typ != tokenNumber {
+		return 0, p.unexpected("integer")
+	}
+	n, err := strconv.ParseUint(t.text, 10, 64)
+	if err != nil {
+		return 0, p.errorf(t, "expected integer, found %s", t)
+	}
+	p.advance()
+	return n, nil
+}
+
+func (p *parser) exprList() ([]*pb.Expr, error) {
+	var exprs []*pb.Expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// Operator precedence, from loosest to tightest binding.
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precUnary
+	precPrimary
+)
+
+var binaryOps = map[string]pb.BinaryOp{
+	"=":  pb.BinaryOp_EQ,
+	"!=": pb.BinaryOp_NE,
+	"<>": pb.BinaryOp_NE,
+	">":  pb.BinaryOp_GT,
+	">=": pb.BinaryOp_GTE,
+	"<":  pb.BinaryOp_LT,
+	"<=": pb.BinaryOp_LTE,
+}
+
+func binaryExpr(expr1 *pb.Expr, op pb.BinaryOp, expr2 *pb.Expr) *pb.Expr {
+	return &pb.Expr{Expr: &pb.Expr_BinaryExpr{BinaryExpr: &pb.BinaryExpr{Expr1: expr1, Op: op, Expr2: expr2}}}
+}
+
+func unaryExpr(op pb.UnaryOp, expr *pb.Expr) *pb.Expr {
+	return &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: op, Expr: expr}}}
+}
+
+func lit(l *pb.Lit) *pb.Expr {
+	return &pb.Expr{Expr: &pb.Expr_Lit{Lit: l}}
+}
+
+func (p *parser) expr() (*pb.Expr, error) {
+	return p.or()
+}
+
+func (p *parser) or() (*pb.Expr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		e2, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		e = binaryExpr(e, pb.BinaryOp_OR, e2)
+	}
+	return e, nil
+}
+
+func (p *parser) and() (*pb.Expr, error) {
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		e2, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		e = binaryExpr(e, pb.BinaryOp_AND, e2)
+	}
+	return e, nil
+}
+
+func (p *parser) not() (*pb.Expr, error) {
+	if p.acceptKeyword("NOT") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr(pb.UnaryOp_NOT, e), nil
+	}
+	return p.compare()
+}
+
+func (p *parser) compare() (*pb.Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		var op pb.BinaryOp
+		switch {
+		case t.typ == tokenSymbol && binaryOps[t.text] != pb.BinaryOp_UNKNOWN_BO:
+			p.advance()
+			op = binaryOps[t.text]
+		case t.keyword("IS"):
+			p.advance()
+			op = pb.BinaryOp_IS
+			if p.acceptKeyword("NOT") {
+				op = pb.BinaryOp_IS_NOT
+			}
+		default:
+			return e, nil
+		}
+		e2, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		e = binaryExpr(e, op, e2)
+	}
+}
+
+func (p *parser) unary() (*pb.Expr, error) {
+	switch {
+	case p.peek().symbol("-"):
+		p.advance()
+		// a minus sign directly before a number is part of the literal
+		if t := p.peek(); t.typ == tokenNumber {
+			p.advance()
+			n, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, p.errorf(t, "invalid number %s", t)
+			}
+			return lit(&pb.Lit{Lit: &pb.Lit_Num{Num: -n}}), nil
+		}
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr(pb.UnaryOp_NEG, e), nil
+	case p.peek().symbol("+"):
+		p.advance()
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr(pb.UnaryOp_POS, e), nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (*pb.Expr, error) {
+	t := p.peek()
+	switch {
+	case t.symbol("("):
+		p.advance()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expectSymbol(")")
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	case t.typ == tokenString:
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_Str{Str: t.value}}), nil
+	case t.typ == tokenNumber:
+		p.advance()
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t)
+		}
+		return lit(&pb.Lit{Lit: &pb.Lit_Num{Num: n}}), nil
+	case t.typ == tokenBlob:
+		p.advance()
+		b, err := hex.DecodeString(t.value)
+		if err != nil {
+			return nil, p.errorf(t, "invalid blob %s: must be hexadecimal", t)
+		}
+		return lit(&pb.Lit{Lit: &pb.Lit_Blob{Blob: b}}), nil
+	case t.keyword("NULL"):
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_Null{}}), nil
+	case t.symbol(":"):
+		p.advance()
+		name := p.peek()
+		if name.typ != tokenIdent && name.typ != tokenQuotedIdent {
+			return nil, p.errorf(name, "expected parameter name, found %s", name)
+		}
+		p.advance()
+		param := &pb.Param{Name: name.value}
+		if p.acceptSymbol("::") {
+			t := p.peek()
+			typ, ok := pb.ParamType_value[strings.ToUpper(t.value)]
+			if t.typ != tokenIdent || !ok {
+				return nil, p.errorf(t, "expected parameter type, found %s", t)
+			}
+			p.advance()
+			param.Type = pb.ParamType(typ)
+		}
+		return &pb.Expr{Expr: &pb.Expr_Param{Param: param}}, nil
+	case t.keyword("TRUE"), t.keyword("FALSE"):
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_Boolean{Boolean: t.keyword("TRUE")}}), nil
+	case t.keyword("CURRENT_TIME"):
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_CurrentTime{CurrentTime: &pb.CurrentTime{}}}), nil
+	case t.keyword("CURRENT_DATE"):
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_CurrentDate{CurrentDate: &pb.CurrentDate{}}}), nil
+	case t.keyword("CURRENT_TIMESTAMP"):
+		p.advance()
+		return lit(&pb.Lit{Lit: &pb.Lit_CurrentTimestamp{CurrentTimestamp: &pb.CurrentTimestamp{}}}), nil
+	case t.typ == tokenIdent && p.i+1 < len(p.tokens) && p.tokens[p.i+1].typ == tokenString:
+		s := p.tokens[p.i+1]
+		l, err := typedLit(t.text, s.value)
+		if err != nil {
+			return nil, p.errorf(s, "%v", err)
+		}
+		if l == nil {
+			break
+		}
+		p.advance()
+		p.advance()
+		return lit(l), nil
+	case t.keyword("ARRAY") && p.i+1 < len(p.tokens) && p.tokens[p.i+1].symbol("["):
+		p.advance()
+		p.advance()
+		array := &pb.Array{}
+		for !p.acceptSymbol("]") {
+			if len(array.Elements) > 0 {
+				err := p.expectSymbol(",")
+				if err != nil {
+					return nil, err
+				}
+			}
+			start := p.peek()
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if e.GetLit() == nil {
+				return nil, p.errorf(start, "array elements must be literals")
+			}
+			array.Elements = append(array.Elements, e.GetLit())
+		}
+		return lit(&pb.Lit{Lit: &pb.Lit_Array{Array: array}}), nil
+	}
+	if isIdent(t) {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		col := &pb.Col{}
+		switch len(name) {
+		case 1:
+			col.Column = name[0]
+		case 2:
+			col.Table, col.Column = name[0], name[1]
+		case 3:
+			col.Schema, col.Table, col.Column = name[0], name[1], name[2]
+		default:
+			return nil, p.errorf(t, "expected column, table.column or schema.table.column, found %s", strings.Join(name, "."))
+		}
+		return &pb.Expr{Expr: &pb.Expr_Col{Col: col}}, nil
+	}
+	return nil, p.unexpected("expression")
+}
+
+// typedLit returns the literal written as typ 'value', or nil if typ isn't a
+// type with a literal form.
+func typedLit(typ, value string) (*pb.Lit, error) {
+	switch strings.ToUpper(typ) {
+	case "INTEGER":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", value)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Int{Int: i}}, nil
+	case "TIMESTAMP":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q: must be RFC 3339", value)
+		}
+		ts, err := ptypes.TimestampProto(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q: %v", value, err)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Timestamp{Timestamp: ts}}, nil
+	case "DATE":
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q: must be YYYY-MM-DD", value)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Date{Date: &pb.Date{Year: int32(t.Year()), Month: int32(t.Month()), Day: int32(t.Day())}}}, nil
+	case "UUID":
+		return &pb.Lit{Lit: &pb.Lit_Uuid{Uuid: value}}, nil
+	case "DECIMAL":
+		return &pb.Lit{Lit: &pb.Lit_Decimal{Decimal: value}}, nil
+	case "JSON":
+		return &pb.Lit{Lit: &pb.Lit_Json{Json: value}}, nil
+	}
+	return nil, nil
+}
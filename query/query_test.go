@@ -0,0 +1,345 @@
+package query_test
+
+import (
+	pb "github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func mustStatement(t *testing.T, sb StatementBuilder) *pb.Statement {
+	t.Helper()
+	s, err := sb.Statement()
+	if err != nil {
+		t.Fatalf("Unexpected error building statement: %v", err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	table := []struct {
+		name     string
+		input    string
+		expected StatementBuilder
+	}{
+		{
+			"readme example",
+			"select full_name from person where birth > '2000-01-01' order by birth desc",
+			Select("person", "full_name").
+				Where(GT(Col("birth"), Str("2000-01-01"))).
+				OrderBy(Col("birth"), pb.OrderingDirection_DESC),
+		},
+		{
+			"precedence",
+			"SELECT a, b FROM t WHERE x = 1 OR y <> -2 AND NOT z IS NULL;",
+			Select("t", "a", "b").
+				Where(Or(Eq(Col("x"), Num(1)), And(NEq(Col("y"), Num(-2)), Not(Is(Col("z"), Null()))))),
+		},
+		{
+			"joins and grouping",
+			`select count(*) as n, "order".id from public.t1
+			 join t2 on t1.id = t2.id
+			 group by "order".id having "order".id is not null
+			 limit 10 offset 20`,
+			Select("public.t1", "count(*) as n", `"order".id`).
+				JoinEq("t2", TableCol("t1", "id"), TableCol("t2", "id")).
+				GroupBy(TableCol("order", "id")).
+				Having(IsNot(TableCol("order", "id"), Null())).
+				Limit(10).
+				Offset(20),
+		},
+		{
+			"insert",
+			"insert into s.t (x, y) values ('it''s', 'b')",
+			Insert(NewSchemaTable("s", "t"), "x", "y").Values([]*pb.Expr{Str("it's"), Str("b")}),
+		},
+		{
+			"delete",
+			"DELETE FROM t WHERE (a = 1 OR b = 2) AND c = true",
+			Delete(Table("t")).Where(And(Or(Eq(Col("a"), Num(1)), Eq(Col("b"), Num(2))), Eq(Col("c"), Bool(true)))),
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := mustStatement(t, tt.expected)
+			actual, err := query.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(actual, expected) {
+				t.Errorf("Expected: %v\nActual: %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	e, err := query.ParseExpr("tenant_id = claims.tenant AND NOT deleted")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := And(Eq(Col("tenant_id"), TableCol("claims", "tenant")), Not(Col("deleted")))
+	if !proto.Equal(e, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, e)
+	}
+	e, err = query.ParseExpr(`id = :id::integer OR name = :"select"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = Or(Eq(Col("id"), TypedParam("id", pb.ParamType_INTEGER)), Eq(Col("name"), Param("select")))
+	if !proto.Equal(e, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, e)
+	}
+	if formatted := query.FormatExpr(e); formatted != `id = :id::integer OR name = :"select"` {
+		t.Errorf("Unexpected formatting: %s", formatted)
+	}
+	_, err = query.ParseExpr("a = 1 b")
+	if err == nil || err.Error() != `line 1, column 7: expected end of expression, found "b"` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	table := []struct {
+		input    string
+		expected string
+	}{
+		{"", `line 1, column 1: expected SELECT, INSERT, REPLACE, UPDATE or DELETE, found end of input`},
+		{"select from t", `line 1, column 8: expected result column, found "from"`},
+		{"select a from t where", `line 1, column 22: expected expression, found end of input`},
+		{"select a\nfrom t\nwhere x = 'abc", `line 3, column 11: unterminated string`},
+		{"select a from t where x = = 1", `line 1, column 27: expected expression, found "="`},
+		{"select a from t limit -1", `line 1, column 23: expected integer, found "-"`},
+		{"delete from t where a.b.c.d = 1", `line 1, column 21: expected column, table.column or schema.table.column, found a.b.c.d`},
+		{"update t set x = 1 where", `line 1, column 25: expected expression, found end of input`},
+		{"select a from t; select b from t", `line 1, column 18: expected end of statement, found "select"`},
+		{"select a from where", `line 1, column 15: expected identifier, found "where"`},
+		{"select a from t where x = X'cafg'", `line 1, column 27: invalid blob "X'cafg'": must be hexadecimal`},
+		{"select a from t where x = 1 # 2", `line 1, column 29: unexpected character '#'`},
+		{"select a from t where x = date '2000-13-01'", `line 1, column 32: invalid date "2000-13-01": must be YYYY-MM-DD`},
+		{"select a from t where x = array[1, y]", `line 1, column 36: array elements must be literals`},
+		{"select distinct a from t", `line 1, column 8: SELECT DISTINCT isn't supported`},
+		{"delete from t allow full", `line 1, column 25: expected TABLE, found end of input`},
+	}
+	for _, tt := range table {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := query.Parse(tt.input)
+			if _, ok := err.(*query.Error); !ok || err.Error() != tt.expected {
+				t.Errorf("Expected: %s\nActual: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	table := []struct {
+		name      string
+		statement *pb.Statement
+		formatted string
+	}{
+		{
+			"select",
+			mustStatement(t, Select("person", "id", "full_name").
+				Where(All(GT(Col("birth"), Str("2000-01-01")), Not(Eq(Col("from"), Null())))).
+				OrderBy(Col("birth"), pb.OrderingDirection_DESC).
+				OrderBy(Col("id"), pb.OrderingDirection_ASC).
+				Limit(5)),
+			`SELECT id, full_name FROM person WHERE birth > '2000-01-01' AND NOT "from" = NULL ORDER BY birth DESC, id LIMIT 5`,
+		},
+		{
+			"joins",
+			&pb.Statement{Statement: &pb.Statement_Select{Select: &pb.Select{
+				DistinctAll:  pb.DistinctAll_ALL,
+				ResultColumn: []string{"*"},
+				From:         "t1",
+				Join: []*pb.Join{
+					{Natural: true, JoinType: pb.JoinType_LEFT_OUTER, Table: "t2", On: Bool(true)},
+					{JoinType: pb.JoinType_CROSS, Table: "s.t3", On: Eq(SchemaTableCol("s", "t3", "x"), TableCol("t1", "x"))},
+				},
+				GroupBy: []*pb.Expr{Col("a"), Col("b")},
+				Having:  GT(Col("a"), Num(1.5e21)),
+				Offset:  3,
+			}}},
+			`SELECT ALL * FROM t1 NATURAL LEFT OUTER JOIN t2 ON TRUE CROSS JOIN s.t3 ON s.t3.x = t1.x GROUP BY a, b HAVING a > 1.5e+21 OFFSET 3`,
+		},
+		{
+			"parenthesised expressions",
+			mustStatement(t, Delete(Table("t")).Where(And(
+				Or(Col("a"), Col("b")),
+				And(Eq(Not(Col("c")), Col("d")), Eq(Col("e"), Eq(Col("f"), Col("g")))),
+			))),
+			`DELETE FROM t WHERE (a OR b) AND ((NOT c) = d AND e = (f = g))`,
+		},
+		{
+			"unary expressions",
+			&pb.Statement{Statement: &pb.Statement_Delete{Delete: &pb.Delete{
+				From: Table("t"),
+				Where: All(
+					Eq(Col("a"), &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: pb.UnaryOp_NEG, Expr: Num(1)}}}),
+					Eq(Col("b"), &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: pb.UnaryOp_NEG, Expr: Num(-1)}}}),
+					Eq(Col("c"), &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: pb.UnaryOp_POS, Expr: Col("d")}}}),
+					Eq(Col("e"), &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{Op: pb.UnaryOp_NEG, Expr: Eq(Col("f"), Num(-2))}}}),
+				),
+			}}},
+			`DELETE FROM t WHERE a = -(1) AND b = -(-1) AND c = +d AND e = -(f = -2)`,
+		},
+		{
+			"insert values",
+			&pb.Statement{Statement: &pb.Statement_Insert{Insert: &pb.Insert{
+				Insert:  pb.InsertType_REPLACE,
+				Into:    NewSchemaTable("my schema", "t"),
+				Columns: []string{"a", "b", "c", "d"},
+				ToInsert: &pb.ToInsert{Insert: &pb.ToInsert_Values{Values: &pb.Values{Rows: []*pb.Row{
+					{Values: []*pb.Expr{
+						Str(`it's "quoted"`),
+						Blob([]byte{0xca, 0xfe}),
+						CurrentTimestamp(),
+						Bool(false),
+					}},
+					{Values: []*pb.Expr{
+						Null(),
+						CurrentDate(),
+						CurrentTime(),
+						Num(0.25),
+					}},
+				}}}},
+			}}},
+			`REPLACE INTO "my schema".t (a, b, c, d) VALUES ('it''s "quoted"', X'cafe', CURRENT_TIMESTAMP, FALSE), (NULL, CURRENT_DATE, CURRENT_TIME, 0.25)`,
+		},
+		{
+			"typed literals",
+			mustStatement(t, Select("t", "a").Where(All(
+				Eq(Col("b"), Int(-42)),
+				GT(Col("c"), Time(time.Date(2000, 1, 2, 3, 4, 5, 5e8, time.UTC))),
+				Eq(Col("d"), Date(1999, time.December, 31)),
+				Eq(Col("e"), UUID("123e4567-e89b-12d3-a456-426614174000")),
+				Eq(Col("f"), Decimal("-12.50")),
+				Eq(Col("g"), JSON(`{"k": "it's"}`)),
+				Eq(Col("date"), Array(Array(Num(1), Int(2)), Array())),
+			))),
+			`SELECT a FROM t WHERE b = INTEGER '-42' AND c > TIMESTAMP '2000-01-02T03:04:05.5Z' AND d = DATE '1999-12-31' AND e = UUID '123e4567-e89b-12d3-a456-426614174000' AND f = DECIMAL '-12.50' AND g = JSON '{"k": "it''s"}' AND date = ARRAY[ARRAY[1, INTEGER '2'], ARRAY[]]`,
+		},
+		{
+			"insert select",
+			mustStatement(t, Insert(Table("t"), "a").From(Select("u", "b").Where(LTE(Col("c"), Num(3))))),
+			`INSERT INTO t (a) SELECT b FROM u WHERE c <= 3`,
+		},
+		{
+			"update",
+			&pb.Statement{Statement: &pb.Statement_Update{Update: &pb.Update{
+				UpdateOr: pb.UpdateType_OR_IGNORE,
+				Table:    Table("t"),
+				Set: []*pb.Set{
+					{Column: "a", To: Num(1)},
+					{Column: "select", To: Col("b")},
+				},
+				Where: Or(GTE(Col("a"), Num(2)), LT(Col("a"), Num(0))),
+			}}},
+			`UPDATE OR IGNORE t SET a = 1, "select" = b WHERE a >= 2 OR a < 0`,
+		},
+		{
+			"update full table",
+			mustStatement(t, Update(Table("t")).Set("allow", Col("full")).AllowFullTable()),
+			`UPDATE t SET allow = full ALLOW FULL TABLE`,
+		},
+		{
+			"delete full table",
+			mustStatement(t, Delete(Table("person")).AllowFullTable()),
+			`DELETE FROM person ALLOW FULL TABLE`,
+		},
+		{
+			"delete with where and full table",
+			mustStatement(t, Delete(Table("table")).Where(Col("allow")).AllowFullTable()),
+			`DELETE FROM table WHERE allow ALLOW FULL TABLE`,
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := query.Format(tt.statement)
+			if formatted != tt.formatted {
+				t.Errorf("Expected: %s\nActual: %s", tt.formatted, formatted)
+			}
+			parsed, err := query.Parse(formatted)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(parsed, tt.statement) {
+				t.Errorf("Expected: %v\nActual: %v", tt.statement, parsed)
+			}
+		})
+	}
+}
+
+func TestPretty(t *testing.T) {
+	table := []struct {
+		name      string
+		statement *pb.Statement
+		expected  string
+	}{
+		{
+			"select",
+			mustStatement(t, Select("person", "id", "full_name").
+				JoinEq("country", TableCol("person", "country_id"), TableCol("country", "id")).
+				Where(All(GT(Col("birth"), Str("2000-01-01")), Any(Col("a"), Col("b")), Not(Col("c")))).
+				OrderBy(Col("birth"), pb.OrderingDirection_DESC).
+				Limit(5)),
+			`SELECT id, full_name
+FROM person
+JOIN country ON person.country_id = country.id
+WHERE birth > '2000-01-01'
+    AND (a OR b)
+    AND NOT c
+ORDER BY birth DESC
+LIMIT 5`,
+		},
+		{
+			"insert values",
+			mustStatement(t, Insert(Table("t"), "a", "b").Values([]*pb.Expr{Str("1"), Str("2")}, []*pb.Expr{Str("3"), Str("4")})),
+			`INSERT INTO t (a, b)
+VALUES
+    ('1', '2'),
+    ('3', '4')`,
+		},
+		{
+			"update",
+			&pb.Statement{Statement: &pb.Statement_Update{Update: &pb.Update{
+				Table: Table("t"),
+				Set:   []*pb.Set{{Column: "a", To: Num(1)}, {Column: "b", To: Null()}},
+				Where: Or(Eq(Col("a"), Num(2)), Eq(Col("b"), Num(3))),
+			}}},
+			`UPDATE t
+SET
+    a = 1,
+    b = NULL
+WHERE a = 2
+    OR b = 3`,
+		},
+		{
+			"invalid",
+			&pb.Statement{Statement: &pb.Statement_Delete{Delete: &pb.Delete{Where: And(Col("a"), nil)}}},
+			`DELETE FROM <nil>
+WHERE a
+    AND <nil>`,
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			pretty := query.Pretty(tt.statement)
+			if pretty != tt.expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", tt.expected, pretty)
+			}
+			if tt.name == "invalid" {
+				return
+			}
+			parsed, err := query.Parse(pretty)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(parsed, tt.statement) {
+				t.Errorf("Expected: %v\nActual: %v", tt.statement, parsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package scan
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrNoRows is returned by One when the result has no rows.
+var ErrNoRows = errors.New("no rows in result")
+
+// Error describes a value which couldn't be scanned into a struct field.
+type Error struct {
+	Row    int
+	Column string
+	Field  string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("row %d: can't scan column %s into field %s: %v", e.Row, e.Column, e.Field, e.Err)
+}
+
+// All scans every row of the result into dest, which must be a pointer to a
+// slice of structs or of pointers to structs. Result columns are matched to
+// fields with a `grpcdb:"column"` tag or, failing that, to exported fields with
+// the same name ignoring case. Columns with no matching field are ignored.
+// NULLs may be scanned into pointers and sql.Null* types, and any type
+// implementing sql.Scanner is passed the value as a database/sql driver would.
+func All(result *pb.Result, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a pointer to a slice, not %T", dest)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	ptr := elemType.Kind() == reflect.Ptr
+	structType := elemType
+	if ptr {
+		structType = elemType.Elem()
+	}
+	fields, err := fieldsFor(structType, result.Columns)
+	if err != nil {
+		return err
+	}
+	rows := reflect.MakeSlice(slice.Type(), len(result.Rows), len(result.Rows))
+	for i, r := range result.Rows {
+		elem := rows.Index(i)
+		if ptr {
+			elem.Set(reflect.New(structType))
+			elem = elem.Elem()
+		}
+		err = scanRow(i, r, result.Columns, fields, elem)
+		if err != nil {
+			return err
+		}
+	}
+	slice.Set(rows)
+	return nil
+}
+
+// One scans the first row of the result into dest, which must be a pointer to
+// a struct. It returns ErrNoRows if the result is empty. Fields are matched as
+// described for All.
+func One(result *pb.Result, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a pointer to a struct, not %T", dest)
+	}
+	if len(result.Rows) == 0 {
+		return ErrNoRows
+	}
+	fields, err := fieldsFor(v.Elem().Type(), result.Columns)
+	if err != nil {
+		return err
+	}
+	return scanRow(0, result.Rows[0], result.Columns, fields, v.Elem())
+}
+
+// Iterator scans rows one at a time from a sequence of results, such as the
+// messages received from a stream. Use it like sql.Rows:
+//
+//	it := scan.NewIterator(stream.Recv)
+//	for it.Next() {
+//		var p Person
+//		err := it.Scan(&p)
+//		...
+//	}
+//	err := it.Err()
+type Iterator struct {
+	next   func() (*pb.Result, error)
+	result *pb.Result
+	row    int // index into result.Rows
+	n      int // rows returned so far, for error messages
+	err    error
+}
+
+// NewIterator returns an iterator over the rows of the results returned by
+// next. next should return io.EOF once there are no more results.
+func NewIterator(next func() (*pb.Result, error)) *Iterator {
+	return &Iterator{next: next}
+}
+
+// Next advances to the next row, fetching the next result if required. It
+// returns false when there are no more rows or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.result != nil {
+		it.row++
+		it.n++
+	}
+	for it.result == nil || it.row >= len(it.result.Rows) {
+		result, err := it.next()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		// later results in a stream may omit the columns
+		if len(result.Columns) == 0 && it.result != nil {
+			result.Columns = it.result.Columns
+		}
+		it.result, it.row = result, 0
+	}
+	return true
+}
+
+// Scan scans the current row into dest, which must be a pointer to a struct.
+func (it *Iterator) Scan(dest interface{}) error {
+	if it.result == nil || it.row >= len(it.result.Rows) {
+		return errors.New("Scan called without a successful call to Next")
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a pointer to a struct, not %T", dest)
+	}
+	fields, err := fieldsFor(v.Elem().Type(), it.result.Columns)
+	if err != nil {
+		return err
+	}
+	return scanRow(it.n, it.result.Rows[it.row], it.result.Columns, fields, v.Elem())
+}
+
+// Err returns the error, if any, encountered while fetching results.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// field is the path to a (possibly embedded) struct field.
+type field struct {
+	index []int
+	name  string
+}
+
+// fieldsFor maps each column onto a field of t, or nil if no field matches.
+func fieldsFor(t reflect.Type, columns []string) ([]*field, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't scan into %s; must be a struct", t)
+	}
+	tagged := make(map[string]*field)
+	named := make(map[string]*field)
+	collectFields(t, nil, tagged, named)
+	fields := make([]*field, len(columns))
+	for i, c := range columns {
+		if f, ok := tagged[c]; ok {
+			fields[i] = f
+		} else if f, ok := named[strings.ToLower(c)]; ok {
+			fields[i] = f
+		}
+	}
+	return fields, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func collectFields(t reflect.Type, index []int, tagged, named map[string]*field) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+		tag := sf.Tag.Get("grpcdb")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			collectFields(sf.Type, idx, tagged, named)
+			continue
+		}
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+		f := &field{index: idx, name: t.Name() + "." + sf.Name}
+		if tag != "" {
+			tagged[tag] = f
+		} else if _, ok := named[strings.ToLower(sf.Name)]; !ok {
+			named[strings.ToLower(sf.Name)] = f
+		}
+	}
+}
+
+func scanRow(n int, r *pb.ResultRow, columns []string, fields []*field, dest reflect.Value) error {
+	if len(r.Values) != len(columns) {
+		return fmt.Errorf("row %d has %d values but there are %d columns", n, len(r.Values), len(columns))
+	}
+	for i, f := range fields {
+		if f == nil {
+			continue
+		}
+		err := setValue(dest.FieldByIndex(f.index), r.Values[i])
+		if err != nil {
+			return &Error{Row: n, Column: columns[i], Field: f.name, Err: err}
+		}
+	}
+	return nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// setValue converts v to the type of dest and sets it.
+func setValue(dest reflect.Value, v *pb.Value) error {
+	if dest.CanAddr() && dest.Addr().Type().Implements(scannerType) {
+		dv, err := driverValue(v)
+		if err != nil {
+			return err
+		}
+		return dest.Addr().Interface().(sql.Scanner).Scan(dv)
+	}
+	if dest.Kind() == reflect.Ptr {
+		if isNull(v) {
+			dest.Set(reflect.Zero(dest.Type()))
+			return nil
+		}
+		elem := reflect.New(dest.Type().Elem())
+		err := setValue(elem.Elem(), v)
+		if err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	}
+	if dest.Type() == timeType {
+		t, err := Time(v)
+		if err != nil {
+			return err
+		}
+		dest.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch dest.Kind() {
+	case reflect.String:
+		s, err := String(v)
+		if err != nil {
+			return err
+		}
+		dest.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := Int64(v)
+		if err != nil {
+			return err
+		}
+		if dest.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, dest.Type())
+		}
+		dest.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := Int64(v)
+		if err != nil {
+			return err
+		}
+		if i < 0 || dest.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%d overflows %s", i, dest.Type())
+		}
+		dest.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, err := Float64(v)
+		if err != nil {
+			return err
+		}
+		dest.SetFloat(f)
+	case reflect.Bool:
+		b, err := Bool(v)
+		if err != nil {
+			return err
+		}
+		dest.SetBool(b)
+	case reflect.Slice:
+		if dest.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported field type %s", dest.Type())
+		}
+		b, err := Bytes(v)
+		if err != nil {
+			return err
+		}
+		dest.SetBytes(b)
+	case reflect.Interface:
+		dv, err := driverValue(v)
+		if err != nil {
+			return err
+		}
+		if dv == nil {
+			dest.Set(reflect.Zero(dest.Type()))
+			return nil
+		}
+		rv := reflect.ValueOf(dv)
+		if !rv.Type().AssignableTo(dest.Type()) {
+			return mismatch(v, dest.Type().String())
+		}
+		dest.Set(rv)
+	default:
+		return fmt.Errorf("unsupported field type %s", dest.Type())
+	}
+	return nil
+}
+
+// driverValue converts v into one of the types a database/sql driver would
+// pass to sql.Scanner.Scan.
+func driverValue(v *pb.Value) (interface{}, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Null:
+		return nil, nil
+	case *pb.Value_Str:
+		return val.Str, nil
+	case *pb.Value_Num:
+		return val.Num, nil
+	case *pb.Value_Int:
+		return val.Int, nil
+	case *pb.Value_Boolean:
+		return val.Boolean, nil
+	case *pb.Value_Blob:
+		return val.Blob, nil
+	case *pb.Value_Time:
+		return Time(v)
+	}
+	return nil, fmt.Errorf("can't scan %s", kind(v))
+}
@@ -0,0 +1,136 @@
+package scan_test
+
+import (
+	"database/sql"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/scan"
+	"github.com/golang/protobuf/ptypes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Person struct {
+	ID       string         `grpcdb:"id"`
+	FullName sql.NullString `grpcdb:"full_name"`
+	Birth    *time.Time     `grpcdb:"birth"`
+	Age      int
+	Ignored  string `grpcdb:"-"`
+}
+
+func str(s string) *pb.Value { return &pb.Value{Value: &pb.Value_Str{Str: s}} }
+func num(i int64) *pb.Value  { return &pb.Value{Value: &pb.Value_Int{Int: i}} }
+func null() *pb.Value        { return &pb.Value{Value: &pb.Value_Null{Null: &pb.Null{}}} }
+func ts(t time.Time) *pb.Value {
+	p, _ := ptypes.TimestampProto(t)
+	return &pb.Value{Value: &pb.Value_Time{Time: p}}
+}
+
+var birth = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func result() *pb.Result {
+	return &pb.Result{
+		Columns: []string{"id", "full_name", "birth", "age", "ignored", "extra"},
+		Rows: []*pb.ResultRow{
+			{Values: []*pb.Value{str("a"), str("Alice"), ts(birth), num(18), str("x"), str("y")}},
+			{Values: []*pb.Value{str("b"), null(), null(), num(30), str("x"), null()}},
+		},
+	}
+}
+
+var expected = []Person{
+	{ID: "a", FullName: sql.NullString{String: "Alice", Valid: true}, Birth: &birth, Age: 18},
+	{ID: "b", Age: 30},
+}
+
+func TestAll(t *testing.T) {
+	var people []Person
+	err := scan.All(result(), &people)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(people, expected) {
+		t.Errorf("Expected: %+v\nActual: %+v", expected, people)
+	}
+
+	var ptrs []*Person
+	err = scan.All(result(), &ptrs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ptrs) != 2 || !reflect.DeepEqual(*ptrs[1], expected[1]) {
+		t.Errorf("Expected: %+v\nActual: %+v", expected, ptrs)
+	}
+}
+
+func TestOne(t *testing.T) {
+	var p Person
+	err := scan.One(result(), &p)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(p, expected[0]) {
+		t.Errorf("Expected: %+v\nActual: %+v", expected[0], p)
+	}
+	err = scan.One(&pb.Result{}, &p)
+	if err != scan.ErrNoRows {
+		t.Errorf("Expected ErrNoRows, got: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	table := []struct {
+		name     string
+		value    *pb.Value
+		column   string
+		expected string
+	}{
+		{"mismatch", num(1), "id", "row 0: can't scan column id into field Person.ID: can't scan integer into string"},
+		{"null", null(), "age", "row 0: can't scan column age into field Person.Age: can't scan NULL into a non-pointer type"},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &pb.Result{
+				Columns: []string{tt.column},
+				Rows:    []*pb.ResultRow{{Values: []*pb.Value{tt.value}}},
+			}
+			var p Person
+			err := scan.One(r, &p)
+			if _, ok := err.(*scan.Error); !ok || err.Error() != tt.expected {
+				t.Errorf("Expected: %s\nActual: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestIterator(t *testing.T) {
+	// simulate a stream which sends the columns only with the first result
+	second := result()
+	second.Columns = nil
+	results := []*pb.Result{result(), {}, second}
+	it := scan.NewIterator(func() (*pb.Result, error) {
+		if len(results) == 0 {
+			return nil, io.EOF
+		}
+		r := results[0]
+		results = results[1:]
+		return r, nil
+	})
+	var people []Person
+	for it.Next() {
+		var p Person
+		err := it.Scan(&p)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		people = append(people, p)
+	}
+	if it.Err() != nil {
+		t.Fatalf("Unexpected error: %v", it.Err())
+	}
+	all := append(append([]Person{}, expected...), expected...)
+	if !reflect.DeepEqual(people, all) {
+		t.Errorf("Expected: %+v\nActual: %+v", all, people)
+	}
+}
@@ -0,0 +1,207 @@
+// Package scan converts the values in a grpcdb result into Go values.
+package scan
+
+import (
+	"errors"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"strconv"
+	"time"
+)
+
+// ErrNull is returned when a NULL is scanned into a type which can't hold it.
+var ErrNull = errors.New("can't scan NULL into a non-pointer type")
+
+// kind describes the type held by a value, for use in error messages.
+func kind(v *pb.Value) string {
+	switch v.GetValue().(type) {
+	case *pb.Value_Null:
+		return "NULL"
+	case *pb.Value_Str:
+		return "string"
+	case *pb.Value_Num:
+		return "number"
+	case *pb.Value_Int:
+		return "integer"
+	case *pb.Value_Boolean:
+		return "boolean"
+	case *pb.Value_Blob:
+		return "blob"
+	case *pb.Value_Time:
+		return "time"
+	}
+	return "empty value"
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.GetValue().(*pb.Value_Null)
+	return ok
+}
+
+func mismatch(v *pb.Value, want string) error {
+	return fmt.Errorf("can't scan %s into %s", kind(v), want)
+}
+
+// String converts a string value.
+func String(v *pb.Value) (string, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Str:
+		return val.Str, nil
+	case *pb.Value_Null:
+		return "", ErrNull
+	}
+	return "", mismatch(v, "string")
+}
+
+// StringPtr converts a string value, returning nil for NULL.
+func StringPtr(v *pb.Value) (*string, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	s, err := String(v)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Int64 converts an integer value. Strings holding integers are also accepted,
+// since the database sends some numeric types as text.
+func Int64(v *pb.Value) (int64, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Int:
+		return val.Int, nil
+	case *pb.Value_Str:
+		i, err := strconv.ParseInt(val.Str, 10, 64)
+		if err != nil {
+			return 0, mismatch(v, "int64")
+		}
+		return i, nil
+	case *pb.Value_Null:
+		return 0, ErrNull
+	}
+	return 0, mismatch(v, "int64")
+}
+
+// Int64Ptr converts an integer value, returning nil for NULL.
+func Int64Ptr(v *pb.Value) (*int64, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	i, err := Int64(v)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Float64 converts a numeric value. Integers and strings holding numbers are
+// also accepted.
+func Float64(v *pb.Value) (float64, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Num:
+		return val.Num, nil
+	case *pb.Value_Int:
+		return float64(val.Int), nil
+	case *pb.Value_Str:
+		f, err := strconv.ParseFloat(val.Str, 64)
+		if err != nil {
+			return 0, mismatch(v, "float64")
+		}
+		return f, nil
+	case *pb.Value_Null:
+		return 0, ErrNull
+	}
+	return 0, mismatch(v, "float64")
+}
+
+// Float64Ptr converts a numeric value, returning nil for NULL.
+func Float64Ptr(v *pb.Value) (*float64, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	f, err := Float64(v)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Bool converts a boolean value.
+func Bool(v *pb.Value) (bool, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Boolean:
+		return val.Boolean, nil
+	case *pb.Value_Null:
+		return false, ErrNull
+	}
+	return false, mismatch(v, "bool")
+}
+
+// BoolPtr converts a boolean value, returning nil for NULL.
+func BoolPtr(v *pb.Value) (*bool, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	b, err := Bool(v)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// timeLayouts are the layouts accepted when a time is sent as a string.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Time converts a time value. Strings in RFC 3339 or SQL date and timestamp
+// formats are also accepted.
+func Time(v *pb.Value) (time.Time, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Time:
+		t, err := ptypes.Timestamp(val.Time)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t, nil
+	case *pb.Value_Str:
+		for _, layout := range timeLayouts {
+			t, err := time.Parse(layout, val.Str)
+			if err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, mismatch(v, "time.Time")
+	case *pb.Value_Null:
+		return time.Time{}, ErrNull
+	}
+	return time.Time{}, mismatch(v, "time.Time")
+}
+
+// TimePtr converts a time value, returning nil for NULL.
+func TimePtr(v *pb.Value) (*time.Time, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	t, err := Time(v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Bytes converts a blob value, returning nil for NULL.
+func Bytes(v *pb.Value) ([]byte, error) {
+	switch val := v.GetValue().(type) {
+	case *pb.Value_Blob:
+		return val.Blob, nil
+	case *pb.Value_Null:
+		return nil, nil
+	}
+	return nil, mismatch(v, "[]byte")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// authConfig is the format of the file given by -auth-config.
+//
+//	{
+//	    "api_keys": [
+//	        {"principal": "orders-service", "sha256": "<hex encoded SHA-256 of the key>", "claims": {"tenant": "42"}}
+//	    ],
+//	    "jwt": {
+//	        "jwks_file": "/etc/grpcdb/jwks.json",
+//	        "issuer": "https://auth.example.com/",
+//	        "audience": "grpcdb"
+//	    }
+//	}
+type authConfig struct {
+	APIKeys []apiKeyConfig `json:"api_keys"`
+	JWT     *jwtConfig     `json:"jwt"`
+}
+
+type apiKeyConfig struct {
+	Principal string            `json:"principal"`
+	SHA256    string            `json:"sha256"`
+	Claims    map[string]string `json:"claims"`
+}
+
+type jwtConfig struct {
+	JWKSFile string `json:"jwks_file"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+// loadAuthConfig reads the configuration in filename and returns the
+// verifiers it describes.
+func loadAuthConfig(filename string) ([]verifier, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config authConfig
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", filename, err)
+	}
+	var verifiers []verifier
+	if len(config.APIKeys) > 0 {
+		keys, err := newAPIKeys(config.APIKeys)
+		if err != nil {
+			return nil, fmt.Errorf("Error in %s: %v", filename, err)
+		}
+		verifiers = append(verifiers, keys)
+	}
+	if config.JWT != nil {
+		jwks, err := newJWKSVerifier(config.JWT.JWKSFile, config.JWT.Issuer, config.JWT.Audience)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, jwks)
+	}
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("No API keys or JWT configuration in %s", filename)
+	}
+	return verifiers, nil
+}
+
+// errNotMine is returned by a verifier for tokens it doesn't recognise, so that
+// the next verifier can try them.
+var errNotMine = errors.New("token not recognised")
+
+// verifier verifies bearer tokens sent by callers and identifies the caller.
+type verifier interface {
+	verify(token string) (*principal, error)
+}
+
+// apiKeys verifies static API keys. Only the SHA-256 of each key is kept, so
+// the configuration file doesn't hold the keys themselves.
+type apiKeys map[[sha256.Size]byte]apiKeyConfig
+
+func newAPIKeys(config []apiKeyConfig) (apiKeys, error) {
+	keys := make(apiKeys)
+	for i, k := range config {
+		b, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("api_keys[%d].sha256 must be a hex encoded SHA-256", i)
+		}
+		if k.Principal == "" {
+			return nil, fmt.Errorf("api_keys[%d].principal is required", i)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		keys[hash] = k
+	}
+	return keys, nil
+}
+
+func (keys apiKeys) verify(token string) (*principal, error) {
+	k, ok := keys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errNotMine
+	}
+	return &principal{name: k.Principal, source: "api key", claims: k.Claims}, nil
+}
+
+// authenticator is a server interceptor which requires every call to be made
+// either with a client certificate (see identify) or with a bearer token in
+// the authorization metadata that one of the verifiers accepts.
+type authenticator struct {
+	verifiers []verifier
+}
+
+func (a *authenticator) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if principalFromContext(ctx) != nil {
+		return handler(ctx, req)
+	}
+	p, err := a.authenticate(ctx)
+	if err != nil {
+		log.Printf("Rejected call to %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(contextWithPrincipal(ctx, p), req)
+}
+
+func (a *authenticator) authenticate(ctx context.Context) (*principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, errors.New("credentials are required")
+	}
+	const prefix = "bearer "
+	if len(values[0]) <= len(prefix) || !strings.EqualFold(values[0][:len(prefix)], prefix) {
+		return nil, errors.New("authorization must be a bearer token")
+	}
+	token := strings.TrimSpace(values[0][len(prefix):])
+	for _, v := range a.verifiers {
+		p, err := v.verify(token)
+		if err == errNotMine {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+// chainUnaryServer combines interceptors into one, which runs them in order.
+func chainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, h := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signJWT returns a JWT holding claims, signed with an HMAC secret or an RSA
+// private key.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": b64.EncodeToString(secret)},
+		{"kty": "RSA", "kid": "rsa", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}})
+	jwksFile := filepath.Join(dir, "jwks.json")
+	writeFile(t, jwksFile, jwks)
+	keyHash := sha256.Sum256([]byte("orders-key"))
+	configFile := filepath.Join(dir, "auth.json")
+	writeFile(t, configFile, []byte(fmt.Sprintf(`{
+		"api_keys": [{"principal": "orders-service", "sha256": %q, "claims": {"tenant": "42"}}],
+		"jwt": {"jwks_file": %q, "issuer": "https://auth.example.com/", "audience": "grpcdb"}
+	}`, hex.EncodeToString(keyHash[:]), jwksFile)))
+	verifiers, err := loadAuthConfig(configFile)
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	a := &authenticator{verifiers: verifiers}
+
+	now := time.Now().Unix()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "billing-service",
+			"iss": "https://auth.example.com/",
+			"aud": []string{"other", "grpcdb"},
+			"exp": now + 60,
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	table := []struct {
+		name          string
+		authorization string
+		expected      string // principal, or the error if it starts with "error: "
+	}{
+		{"api key", "Bearer orders-key", "orders-service (api key)"},
+		{"hmac jwt", "bearer " + signJWT(t, "HS256", "hmac", secret, claims(nil)), "billing-service (jwt)"},
+		{"rsa jwt", "Bearer " + signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "grpcdb"})), "billing-service (jwt)"},
+		{"missing", "", "error: credentials are required"},
+		{"not bearer", "Basic b3JkZXJzOmtleQ==", "error: authorization must be a bearer token"},
+		{"wrong api key", "Bearer other-key", "error: invalid credentials"},
+		{"wrong secret", "Bearer " + signJWT(t, "HS256", "hmac", []byte("wrong"), claims(nil)), "error: invalid JWT signature"},
+		{"rsa key as hmac secret", "Bearer " + signJWT(t, "HS256", "rsa", rsaKey.N.Bytes(), claims(nil)), "error: invalid JWT signature"},
+		{"alg none", "Bearer " + b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"x"}`)) + ".", `error: unsupported JWT algorithm "none"`},
+		{"expired", "Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"exp": now - 120})), "error: JWT has expired"},
+		{"no expiry", "Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"exp": nil})), "error: JWT has no expiry"},
+		{"not before", "Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"nbf": now + 120})), "error: JWT is not valid yet"},
+		{"issuer", "Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"iss": "https://evil.example.com/"})), `error: JWT issuer "https://evil.example.com/" is not trusted`},
+		{"audience", "Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"aud": "other"})), "error: JWT is for a different audience"},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+			var actual string
+			_, err := a.intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/grpcdbpb.GRPCDB/Query"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				actual = principalFromContext(ctx).String()
+				return nil, nil
+			})
+			if err != nil {
+				if status.Code(err) != codes.Unauthenticated {
+					t.Errorf("Expected Unauthenticated, got: %v", err)
+				}
+				actual = "error: " + status.Convert(err).Message()
+			}
+			if actual != tt.expected {
+				t.Errorf("Expected: %s\nActual: %s", tt.expected, actual)
+			}
+		})
+	}
+
+	t.Run("claims", func(t *testing.T) {
+		for authorization, expected := range map[string]map[string]string{
+			"Bearer orders-key": {"tenant": "42"},
+			"Bearer " + signJWT(t, "HS256", "hmac", secret, claims(map[string]interface{}{"tenant": 7, "admin": false})): {
+				"sub": "billing-service", "iss": "https://auth.example.com/", "exp": fmt.Sprint(now + 60), "tenant": "7", "admin": "false",
+			},
+		} {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", authorization))
+			var actual map[string]string
+			_, err := a.intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/grpcdbpb.GRPCDB/Query"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				actual = principalFromContext(ctx).claims
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Expected: %v\nActual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("tls principal", func(t *testing.T) {
+		ctx := contextWithPrincipal(context.Background(), &principal{name: "CN=orders", source: "tls"})
+		_, err := a.intercept(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		if err != nil {
+			t.Errorf("Expected callers identified by TLS to be accepted, got: %v", err)
+		}
+	})
+
+	t.Run("jwks reload", func(t *testing.T) {
+		other := []byte("fedcba9876543210fedcba9876543210")
+		jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "oct", "kid": "hmac", "k": b64.EncodeToString(other)},
+		}})
+		err := ioutil.WriteFile(jwksFile, jwks, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		touch(t, jwksFile)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signJWT(t, "HS256", "hmac", other, claims(nil))))
+		p, err := a.authenticate(ctx)
+		if err != nil || p.name != "billing-service" {
+			t.Errorf("Expected the reloaded key to be accepted, got: %v, %v", p, err)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/policy"
+	"github.com/lib/pq"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// invalidArgument converts a validation error into an InvalidArgument status
+// with a BadRequest detail listing each field violation.
+func invalidArgument(ve *grpcdb.ValidationError) error {
+	br := &errdetails.BadRequest{}
+	for _, v := range ve.Violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	st, err := status.New(codes.InvalidArgument, ve.Error()).WithDetails(br)
+	if err != nil {
+		log.Printf("Error attaching status details: %v", err)
+		return status.Error(codes.InvalidArgument, ve.Error())
+	}
+	return st.Err()
+}
+
+// permissionDenied converts a policy denial into a PermissionDenied status.
+func permissionDenied(de *policy.DeniedError) error {
+	return status.Error(codes.PermissionDenied, de.Error())
+}
+
+// failedPrecondition returns a FailedPrecondition status with a
+// PreconditionFailure detail listing the violations.
+func failedPrecondition(msg string, violations ...*errdetails.PreconditionFailure_Violation) error {
+	st, err := status.New(codes.FailedPrecondition, msg).WithDetails(&errdetails.PreconditionFailure{Violations: violations})
+	if err != nil {
+		log.Printf("Error attaching status details: %v", err)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	return st.Err()
+}
+
+// resourceExhausted converts a statement exceeding its limits into a
+// ResourceExhausted status with a QuotaFailure detail listing each limit.
+func resourceExhausted(le *policy.LimitError) error {
+	qf := &errdetails.QuotaFailure{}
+	for _, reason := range le.Reasons {
+		qf.Violations = append(qf.Violations, &errdetails.QuotaFailure_Violation{
+			Subject:     le.Principal,
+			Description: reason,
+		})
+	}
+	st, err := status.New(codes.ResourceExhausted, le.Error()).WithDetails(qf)
+	if err != nil {
+		log.Printf("Error attaching status details: %v", err)
+		return status.Error(codes.ResourceExhausted, le.Error())
+	}
+	return st.Err()
+}
+
+// sqlstateCodes maps specific SQLSTATE codes onto gRPC codes.
+var sqlstateCodes = map[pq.ErrorCode]codes.Code{
+	"23505": codes.AlreadyExists,      // unique_violation
+	"23503": codes.FailedPrecondition, // foreign_key_violation
+	"40001": codes.Aborted,            // serialization_failure
+	"40P01": codes.Aborted,            // deadlock_detected
+	"57014": codes.DeadlineExceeded,   // query_canceled (statement_timeout)
+	"42P01": codes.NotFound,           // undefined_table
+	"42703": codes.NotFound,           // undefined_column
+	"42501": codes.PermissionDenied,   // insufficient_privilege
+}
+
+// sqlstateClassCodes maps SQLSTATE classes onto gRPC codes for errors which
+// aren't listed in sqlstateCodes.
+var sqlstateClassCodes = map[pq.ErrorClass]codes.Code{
+	"08": codes.Unavailable,        // connection_exception
+	"22": codes.InvalidArgument,    // data_exception
+	"23": codes.FailedPrecondition, // integrity_constraint_violation
+	"40": codes.Aborted,            // transaction_rollback
+	"42": codes.InvalidArgument,    // syntax_error_or_access_rule_violation
+	"53": codes.ResourceExhausted,  // insufficient_resources
+	"57": codes.Unavailable,        // operator_intervention
+}
+
+// databaseError converts an error returned by the database driver into a
+// status with a code derived from the SQLSTATE, and a DatabaseError detail
+// describing the failure. Errors from the context expiring are returned as
+// DeadlineExceeded or Canceled, and other errors which didn't come from the
+// database are returned as Unknown.
+func databaseError(err error) error {
+	switch err {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, "statement timed out")
+	case context.Canceled:
+		return status.Error(codes.Canceled, "statement was canceled")
+	}
+	pqerr, ok := err.(*pq.Error)
+	if !ok {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	code, ok := sqlstateCodes[pqerr.Code]
+	if !ok {
+		code, ok = sqlstateClassCodes[pqerr.Code.Class()]
+	}
+	if !ok {
+		code = codes.Unknown
+	}
+	st, err := status.New(code, pqerr.Message).WithDetails(&grpcdbpb.DatabaseError{
+		Sqlstate:   string(pqerr.Code),
+		Condition:  pqerr.Code.Name(),
+		Message:    pqerr.Message,
+		Detail:     pqerr.Detail,
+		Schema:     pqerr.Schema,
+		Table:      pqerr.Table,
+		Column:     pqerr.Column,
+		Constraint: pqerr.Constraint,
+	})
+	if err != nil {
+		log.Printf("Error attaching status details: %v", err)
+		return status.Error(code, pqerr.Message)
+	}
+	return st.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestDatabaseError(t *testing.T) {
+	table := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"unique violation", &pq.Error{Code: "23505", Constraint: "person_pkey", Table: "person"}, codes.AlreadyExists},
+		{"foreign key violation", &pq.Error{Code: "23503", Constraint: "person_country_id_fkey"}, codes.FailedPrecondition},
+		{"serialization failure", &pq.Error{Code: "40001"}, codes.Aborted},
+		{"statement timeout", &pq.Error{Code: "57014"}, codes.DeadlineExceeded},
+		{"undefined table", &pq.Error{Code: "42P01"}, codes.NotFound},
+		{"insufficient privilege", &pq.Error{Code: "42501"}, codes.PermissionDenied},
+		{"class fallback", &pq.Error{Code: "23514"}, codes.FailedPrecondition},
+		{"unrecognized", &pq.Error{Code: "XX000"}, codes.Unknown},
+		{"context deadline", context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"context canceled", context.Canceled, codes.Canceled},
+		{"not a database error", errors.New("boom"), codes.Unknown},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(databaseError(tt.err))
+			if st.Code() != tt.code {
+				t.Errorf("Expected code %v, got %v", tt.code, st.Code())
+			}
+			pqerr, ok := tt.err.(*pq.Error)
+			if !ok {
+				return
+			}
+			details := st.Details()
+			if len(details) != 1 {
+				t.Fatalf("Expected 1 detail, got %d: %v", len(details), details)
+			}
+			dbe, ok := details[0].(*grpcdbpb.DatabaseError)
+			if !ok {
+				t.Fatalf("Expected *grpcdbpb.DatabaseError, got %T", details[0])
+			}
+			if dbe.Sqlstate != string(pqerr.Code) || dbe.Constraint != pqerr.Constraint || dbe.Table != pqerr.Table {
+				t.Errorf("Detail doesn't match error: %+v", dbe)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/policy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// Explain returns the database's plan for a statement, after the same checks
+// and rewriting as Query. With analyze the statement is run in a transaction
+// which is then rolled back.
+func (h *handler) Explain(ctx context.Context, req *grpcdbpb.ExplainRequest) (*grpcdbpb.ExplainResult, error) {
+	logStatement(ctx, "explain", req.Statement)
+	ctx, cancel := h.withTimeout(ctx, req.Statement)
+	defer cancel()
+	if req.Analyze && h.policy != nil {
+		name := ""
+		if pr := principalFromContext(ctx); pr != nil {
+			name = pr.name
+		}
+		if !h.policy.get().MayAnalyze(name) {
+			err := &policy.DeniedError{Principal: name, Reasons: []string{"may not use analyze"}}
+			log.Printf("Explain denied by policy: %v", err)
+			return nil, permissionDenied(err)
+		}
+	}
+	p, err := h.prepare(ctx, req.Statement)
+	if err != nil {
+		return nil, err
+	}
+	args, err := bindStatementParams(req.Statement, p)
+	if err != nil {
+		return nil, err
+	}
+	if req.Analyze {
+		// analyze runs the statement, so it's subject to the same limits
+		err = h.checkCost(ctx, p, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Explaining statement: %s", p.sql)
+	plan, err := explain(ctx, h.db, p.sql, req.Analyze, args...)
+	if err != nil {
+		log.Printf("Error explaining statement: %v", err)
+		return nil, databaseError(err)
+	}
+	result, err := parseExplain(plan)
+	if err != nil {
+		log.Printf("Error explaining statement: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	result.Sql = p.sql
+	return result, nil
+}
+
+// explain returns PostgreSQL's plan for sql, with args bound to its
+// placeholders, as JSON. With analyze the statement is run, in a transaction
+// which is rolled back.
+func explain(ctx context.Context, db *sql.DB, sql string, analyze bool, args ...interface{}) ([]byte, error) {
+	if !analyze {
+		var plan []byte
+		err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+sql, args...).Scan(&plan)
+		return plan, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var plan []byte
+	err = tx.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON, ANALYZE) "+sql, args...).Scan(&plan)
+	return plan, err
+}
+
+// explainCost returns the database's estimate of the total cost of running
+// sql with args.
+func explainCost(ctx context.Context, db *sql.DB, sql string, args ...interface{}) (float64, error) {
+	plan, err := explain(ctx, db, sql, false, args...)
+	if err != nil {
+		return 0, err
+	}
+	result, err := parseExplain(plan)
+	if err != nil {
+		return 0, err
+	}
+	return result.Plan.TotalCost, nil
+}
+
+// planNode is a node of the plan in EXPLAIN's JSON output.
+type planNode struct {
+	NodeType          string      `json:"Node Type"`
+	RelationName      string      `json:"Relation Name"`
+	Schema            string      `json:"Schema"`
+	Alias             string      `json:"Alias"`
+	IndexName         string      `json:"Index Name"`
+	JoinType          string      `json:"Join Type"`
+	Filter            string      `json:"Filter"`
+	StartupCost       float64     `json:"Startup Cost"`
+	TotalCost         *float64    `json:"Total Cost"`
+	PlanRows          float64     `json:"Plan Rows"`
+	PlanWidth         int64       `json:"Plan Width"`
+	ActualRows        float64     `json:"Actual Rows"`
+	ActualStartupTime float64     `json:"Actual Startup Time"`
+	ActualTotalTime   float64     `json:"Actual Total Time"`
+	ActualLoops       float64     `json:"Actual Loops"`
+	Plans             []*planNode `json:"Plans"`
+}
+
+func (n *planNode) proto() *grpcdbpb.PlanNode {
+	pn := &grpcdbpb.PlanNode{
+		NodeType:            n.NodeType,
+		Relation:            n.RelationName,
+		Schema:              n.Schema,
+		Alias:               n.Alias,
+		Index:               n.IndexName,
+		JoinType:            n.JoinType,
+		Filter:              n.Filter,
+		StartupCost:         n.StartupCost,
+		PlanRows:            n.PlanRows,
+		PlanWidth:           n.PlanWidth,
+		ActualRows:          n.ActualRows,
+		ActualStartupTimeMs: n.ActualStartupTime,
+		ActualTotalTimeMs:   n.ActualTotalTime,
+		ActualLoops:         n.ActualLoops,
+	}
+	if n.TotalCost != nil {
+		pn.TotalCost = *n.TotalCost
+	}
+	for _, child := range n.Plans {
+		pn.Plans = append(pn.Plans, child.proto())
+	}
+	return pn
+}
+
+// parseExplain converts the output of EXPLAIN (FORMAT JSON) into a result.
+func parseExplain(plan []byte) (*grpcdbpb.ExplainResult, error) {
+	var explain []struct {
+		Plan          *planNode `json:"Plan"`
+		PlanningTime  float64   `json:"Planning Time"`
+		ExecutionTime float64   `json:"Execution Time"`
+	}
+	err := json.Unmarshal(plan, &explain)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing EXPLAIN output: %v", err)
+	}
+	if len(explain) == 0 || explain[0].Plan == nil || explain[0].Plan.TotalCost == nil {
+		return nil, errors.New("EXPLAIN output has no total cost")
+	}
+	return &grpcdbpb.ExplainResult{
+		Plan:            explain[0].Plan.proto(),
+		PlanningTimeMs:  explain[0].PlanningTime,
+		ExecutionTimeMs: explain[0].ExecutionTime,
+	}, nil
+}
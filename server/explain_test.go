@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseExplain(t *testing.T) {
+	plan := []byte(`[{
+		"Plan": {
+			"Node Type": "Hash Join", "Join Type": "Inner",
+			"Startup Cost": 1.5, "Total Cost": 40.25, "Plan Rows": 100, "Plan Width": 64,
+			"Actual Startup Time": 0.1, "Actual Total Time": 0.9, "Actual Rows": 3, "Actual Loops": 1,
+			"Plans": [
+				{"Node Type": "Seq Scan", "Relation Name": "person", "Schema": "public", "Alias": "person",
+				 "Filter": "(age > 18)", "Total Cost": 20.5, "Plan Rows": 50},
+				{"Node Type": "Index Scan", "Relation Name": "country", "Index Name": "country_pkey", "Total Cost": 8}
+			]
+		},
+		"Planning Time": 0.2,
+		"Execution Time": 1.1
+	}]`)
+	expected := &grpcdbpb.ExplainResult{
+		Plan: &grpcdbpb.PlanNode{
+			NodeType: "Hash Join", JoinType: "Inner",
+			StartupCost: 1.5, TotalCost: 40.25, PlanRows: 100, PlanWidth: 64,
+			ActualStartupTimeMs: 0.1, ActualTotalTimeMs: 0.9, ActualRows: 3, ActualLoops: 1,
+			Plans: []*grpcdbpb.PlanNode{
+				{NodeType: "Seq Scan", Relation: "person", Schema: "public", Alias: "person", Filter: "(age > 18)", TotalCost: 20.5, PlanRows: 50},
+				{NodeType: "Index Scan", Relation: "country", Index: "country_pkey", TotalCost: 8},
+			},
+		},
+		PlanningTimeMs:  0.2,
+		ExecutionTimeMs: 1.1,
+	}
+	actual, err := parseExplain(plan)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+
+	_, err = parseExplain([]byte(`[]`))
+	if err == nil {
+		t.Errorf("Expected an error for a plan without a cost")
+	}
+}
+
+func TestExplainAnalyzePolicy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, filename, []byte(`{"principals": {"*": [{"table": "t", "privileges": ["select"]}]}, "analyze": ["a"]}`))
+	pf, err := newPolicyFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := &handler{policy: pf}
+	ctx := contextWithPrincipal(context.Background(), &principal{name: "b", source: "test"})
+	_, err = h.Explain(ctx, &grpcdbpb.ExplainRequest{Statement: query.MustParse("select * from t"), Analyze: true})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got: %v", err)
+	}
+	if !pf.get().MayAnalyze("a") || pf.get().MayAnalyze("") {
+		t.Errorf("Expected only a to be allowed to analyze")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// fullTableWrite returns the table an update or delete would change every row
+// of, because it has no WHERE clause and doesn't set allow_full_table. It
+// returns "" for any other statement.
+func fullTableWrite(statement *grpcdbpb.Statement) string {
+	switch s := statement.Statement.(type) {
+	case *grpcdbpb.Statement_Update:
+		if s.Update.Where == nil && !s.Update.AllowFullTable {
+			return tableName(s.Update.Table)
+		}
+	case *grpcdbpb.Statement_Delete:
+		if s.Delete.Where == nil && !s.Delete.AllowFullTable {
+			return tableName(s.Delete.From)
+		}
+	}
+	return ""
+}
+
+func tableName(st *grpcdbpb.SchemaTable) string {
+	if st.GetSchema() != "" {
+		return st.Schema + "." + st.Table
+	}
+	return st.GetTable()
+}
+
+// tooManyRowsError is returned when a statement would have affected more than
+// the configured maximum number of rows, and was rolled back.
+type tooManyRowsError struct {
+	affected, max int64
+}
+
+func (e *tooManyRowsError) Error() string {
+	return fmt.Sprintf("statement would affect %d rows, more than the limit of %d, so it was rolled back", e.affected, e.max)
+}
+
+func (e *tooManyRowsError) violation() *errdetails.PreconditionFailure_Violation {
+	return &errdetails.PreconditionFailure_Violation{
+		Type:        "MAX_ROWS_AFFECTED",
+		Subject:     fmt.Sprint(e.max),
+		Description: e.Error(),
+	}
+}
+
+// execLimited runs exec in a transaction, committing it only if it affects at
+// most max rows.
+func execLimited(ctx context.Context, db *sql.DB, max int64, exec func(*sql.Tx) (sql.Result, error)) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := exec(tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if n > max {
+		err = tx.Rollback()
+		if err != nil {
+			return 0, err
+		}
+		return 0, &tooManyRowsError{affected: n, max: max}
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb/builder"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/ptypes/duration"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFullTableWrite(t *testing.T) {
+	h := &handler{}
+	table := []struct {
+		name string
+		sb   builder.StatementBuilder
+		code codes.Code
+	}{
+		{"delete", builder.Delete(builder.Table("person")), codes.FailedPrecondition},
+		{"update", builder.Update(builder.Table("person")).Set("name", builder.Str("x")), codes.FailedPrecondition},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			statement, err := tt.sb.Statement()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			_, err = h.Query(context.Background(), statement)
+			st := status.Convert(err)
+			if st.Code() != tt.code {
+				t.Fatalf("Expected code %v, got: %v", tt.code, err)
+			}
+			details := st.Details()
+			if len(details) != 1 {
+				t.Fatalf("Expected 1 detail, got %d: %v", len(details), details)
+			}
+			pf, ok := details[0].(*errdetails.PreconditionFailure)
+			if !ok || len(pf.Violations) != 1 || pf.Violations[0].Type != "WHERE_REQUIRED" || pf.Violations[0].Subject != "person" {
+				t.Errorf("Unexpected detail: %v", details[0])
+			}
+		})
+	}
+
+	allowed, _ := builder.Delete(builder.Table("person")).AllowFullTable().Statement()
+	if table := fullTableWrite(allowed); table != "" {
+		t.Errorf("Expected allow_full_table to be respected, got %q", table)
+	}
+	bounded, _ := builder.Delete(builder.Table("person")).Where(builder.Eq(builder.Col("id"), builder.Num(1))).Statement()
+	if table := fullTableWrite(bounded); table != "" {
+		t.Errorf("Expected a delete with a where clause to be allowed, got %q", table)
+	}
+}
+
+func TestMaxRowsAffected(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE t (a INTEGER); INSERT INTO t VALUES (1), (2), (3)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := &handler{db: db, maxRowsAffected: 2}
+
+	_, err = h.exec(context.Background(), "DELETE FROM t")
+	if tmr, ok := err.(*tooManyRowsError); !ok || tmr.affected != 3 {
+		t.Fatalf("Expected a *tooManyRowsError, got: %v", err)
+	}
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n)
+	if err != nil || n != 3 {
+		t.Errorf("Expected the delete to be rolled back, %d rows remain (%v)", n, err)
+	}
+
+	result, err := h.exec(context.Background(), "DELETE FROM t WHERE a > 1")
+	if err != nil || result.RowsAffected != 2 {
+		t.Fatalf("Expected 2 rows affected, got: %v, %v", result, err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n)
+	if err != nil || n != 1 {
+		t.Errorf("Expected the delete to be committed, %d rows remain (%v)", n, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, filename, []byte(`{
+		"principals": {"*": [{"table": "t", "privileges": ["select"]}, {"table": "u", "privileges": ["select"]}]},
+		"limits": {"*": {"max_joins": 0, "max_rows": 10}, "a": {"max_joins": 1}}
+	}`))
+	pf, err := newPolicyFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := &handler{policy: pf}
+	ctx := contextWithPrincipal(context.Background(), &principal{name: "a", source: "test"})
+	_, err = h.Query(ctx, query.MustParse("select * from t join u on t.id = u.id join u on t.id = u.id"))
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted, got: %v", err)
+	}
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("Expected 1 detail, got %d: %v", len(details), details)
+	}
+	qf, ok := details[0].(*errdetails.QuotaFailure)
+	if !ok || len(qf.Violations) != 1 || qf.Violations[0].Subject != "a" || qf.Violations[0].Description != "statement has 2 joins, more than the limit of 1" {
+		t.Errorf("Unexpected detail: %v", details[0])
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := &handler{defaultTimeout: 30 * time.Second, maxTimeout: time.Minute}
+	table := []struct {
+		timeout  *duration.Duration
+		expected time.Duration
+	}{
+		{nil, 30 * time.Second},
+		{&duration.Duration{Seconds: 5}, 5 * time.Second},
+		{&duration.Duration{Seconds: 600}, time.Minute},
+	}
+	for _, tt := range table {
+		statement := query.MustParse("select * from t")
+		statement.Timeout = tt.timeout
+		if actual := h.timeout(statement); actual != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.timeout, tt.expected, actual)
+		}
+	}
+	if actual := (&handler{maxTimeout: time.Minute}).timeout(query.MustParse("select * from t")); actual != time.Minute {
+		t.Errorf("Expected the maximum without a default, got %v", actual)
+	}
+
+	// a long running query is cancelled when the timeout expires
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = (&handler{db: db}).query(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c")
+	if err == nil {
+		t.Fatalf("Expected the query to be cancelled")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Query wasn't cancelled promptly: took %v", time.Since(start))
+	}
+	if code := status.Code(databaseError(err)); code != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v: %v", code, err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 and SHA-384
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway allows for clock skew when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+// jwtAlgs are the supported signing algorithms. "none" is deliberately absent.
+var jwtAlgs = map[string]struct {
+	kty  string
+	hash crypto.Hash
+}{
+	"HS256": {"oct", crypto.SHA256},
+	"HS384": {"oct", crypto.SHA384},
+	"HS512": {"oct", crypto.SHA512},
+	"RS256": {"RSA", crypto.SHA256},
+	"RS384": {"RSA", crypto.SHA384},
+	"RS512": {"RSA", crypto.SHA512},
+}
+
+// jwk is a JSON Web Key, as held in a JWKS file (RFC 7517). Only symmetric
+// ("oct") and RSA keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"` // oct
+	N   string `json:"n"` // RSA
+	E   string `json:"e"` // RSA
+
+	secret []byte
+	public *rsa.PublicKey
+}
+
+// jwksVerifier verifies JWTs signed by a key in a local JWKS file, which is
+// reloaded when it's modified. The token's sub claim identifies the caller.
+type jwksVerifier struct {
+	filename string
+	issuer   string
+	audience string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    []*jwk
+	modTime time.Time
+}
+
+func newJWKSVerifier(filename, issuer, audience string) (*jwksVerifier, error) {
+	v := &jwksVerifier{filename: filename, issuer: issuer, audience: audience, now: time.Now}
+	_, err := v.load()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// load returns the keys, reloading the file first if it's been modified. If
+// reloading fails the previous keys are returned along with the error.
+func (v *jwksVerifier) load() ([]*jwk, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fi, err := os.Stat(v.filename)
+	if err != nil {
+		return v.keys, err
+	}
+	if v.keys != nil && fi.ModTime().Equal(v.modTime) {
+		return v.keys, nil
+	}
+	b, err := ioutil.ReadFile(v.filename)
+	if err != nil {
+		return v.keys, err
+	}
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return v.keys, fmt.Errorf("Error parsing %s: %v", v.filename, err)
+	}
+	v.keys, v.modTime = keys, fi.ModTime()
+	return keys, nil
+}
+
+func parseJWKS(b []byte) ([]*jwk, error) {
+	var jwks struct {
+		Keys []*jwk `json:"keys"`
+	}
+	err := json.Unmarshal(b, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	for i, k := range jwks.Keys {
+		switch k.Kty {
+		case "oct":
+			k.secret, err = base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(k.secret) == 0 {
+				return nil, fmt.Errorf("keys[%d].k must be a base64url encoded secret", i)
+			}
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("keys[%d] must have a base64url encoded n and e", i)
+			}
+			k.public = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		default:
+			return nil, fmt.Errorf("keys[%d] has unsupported kty %q", i, k.Kty)
+		}
+	}
+	return jwks.Keys, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub string      `json:"sub"`
+	Iss string      `json:"iss"`
+	Aud interface{} `json:"aud"` // a string or an array of strings
+	Exp *int64      `json:"exp"`
+	Nbf *int64      `json:"nbf"`
+}
+
+func (c *jwtClaims) hasAudience(audience string) bool {
+	switch aud := c.Aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *jwksVerifier) verify(token string) (*principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errNotMine
+	}
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %v", err)
+	}
+	alg, ok := jwtAlgs[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature: %v", err)
+	}
+	keys, err := v.load()
+	if err != nil {
+		log.Printf("Error reloading JWKS: %v", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if k.Kty != alg.kty || (header.Kid != "" && k.Kid != header.Kid) || (k.Alg != "" && k.Alg != header.Alg) {
+			continue
+		}
+		if verifySignature(k, alg.hash, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid JWT signature")
+	}
+	var claims jwtClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %v", err)
+	}
+	now := v.now()
+	if claims.Exp == nil {
+		return nil, errors.New("JWT has no expiry")
+	}
+	if now.After(time.Unix(*claims.Exp, 0).Add(jwtLeeway)) {
+		return nil, errors.New("JWT has expired")
+	}
+	if claims.Nbf != nil && now.Add(jwtLeeway).Before(time.Unix(*claims.Nbf, 0)) {
+		return nil, errors.New("JWT is not valid yet")
+	}
+	if v.issuer != "" && claims.Iss != v.issuer {
+		return nil, fmt.Errorf("JWT issuer %q is not trusted", claims.Iss)
+	}
+	if v.audience != "" && !claims.hasAudience(v.audience) {
+		return nil, errors.New("JWT is for a different audience")
+	}
+	if claims.Sub == "" {
+		return nil, errors.New("JWT has no subject")
+	}
+	return &principal{name: claims.Sub, source: "jwt", claims: stringClaims(parts[1])}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(k *jwk, hash crypto.Hash, signed, sig []byte) bool {
+	switch k.Kty {
+	case "oct":
+		mac := hmac.New(hash.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RSA":
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(k.public, hash, h.Sum(nil), sig) == nil
+	}
+	return false
+}
+
+// stringClaims returns the string, number and boolean claims in the JWT
+// payload, formatted as strings.
+func stringClaims(payload string) map[string]string {
+	var all map[string]interface{}
+	if decodeJWTPart(payload, &all) != nil {
+		return nil
+	}
+	claims := make(map[string]string)
+	for name, value := range all {
+		switch v := value.(type) {
+		case string:
+			claims[name] = v
+		case float64:
+			claims[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			claims[name] = strconv.FormatBool(v)
+		}
+	}
+	return claims
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"sort"
+	"strings"
+)
+
+// bindParams returns the driver value for each parameter, in placeholder
+// order, or a *grpcdb.ValidationError listing missing, unused and mistyped
+// parameters.
+func bindParams(params []*grpcdbpb.Param, values map[string]*grpcdbpb.Value) ([]interface{}, error) {
+	var violations []grpcdb.FieldViolation
+	add := func(name, format string, args ...interface{}) {
+		violations = append(violations, grpcdb.FieldViolation{
+			Field:       fmt.Sprintf("parameters[%s]", name),
+			Description: fmt.Sprintf(format, args...),
+		})
+	}
+	args := make([]interface{}, len(params))
+	used := make(map[string]bool, len(params))
+	for i, param := range params {
+		used[param.Name] = true
+		v, ok := values[param.Name]
+		if !ok {
+			add(param.Name, "missing value for parameter")
+			continue
+		}
+		if t := valueType(v); !bindable(param.Type, t) {
+			add(param.Name, "can't bind %s value to %s parameter", typeName(t), typeName(param.Type))
+			continue
+		}
+		arg, err := driverValue(v)
+		if err != nil {
+			add(param.Name, "%v", err)
+			continue
+		}
+		args[i] = arg
+	}
+	var unused []string
+	for name := range values {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	// map iteration order is random, so sort for a stable error
+	sort.Strings(unused)
+	for _, name := range unused {
+		add(name, "statement has no such parameter")
+	}
+	if len(violations) > 0 {
+		return nil, &grpcdb.ValidationError{Violations: violations}
+	}
+	return args, nil
+}
+
+func paramNames(params []*grpcdbpb.Param) []string {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.Name
+	}
+	return names
+}
+
+// valueType returns the parameter type matching a value, or ANY for null.
+func valueType(v *grpcdbpb.Value) grpcdbpb.ParamType {
+	switch v.GetValue().(type) {
+	case *grpcdbpb.Value_Str:
+		return grpcdbpb.ParamType_STRING
+	case *grpcdbpb.Value_Num:
+		return grpcdbpb.ParamType_NUMBER
+	case *grpcdbpb.Value_Int:
+		return grpcdbpb.ParamType_INTEGER
+	case *grpcdbpb.Value_Boolean:
+		return grpcdbpb.ParamType_BOOLEAN
+	case *grpcdbpb.Value_Blob:
+		return grpcdbpb.ParamType_BYTES
+	case *grpcdbpb.Value_Time:
+		return grpcdbpb.ParamType_TIMESTAMP
+	}
+	return grpcdbpb.ParamType_ANY
+}
+
+// bindable returns whether a value of type t may be bound to a parameter
+// declared as declared. Nulls, whose type is ANY, may be bound to anything.
+func bindable(declared, t grpcdbpb.ParamType) bool {
+	switch {
+	case declared == grpcdbpb.ParamType_ANY, t == grpcdbpb.ParamType_ANY, declared == t:
+		return true
+	case declared == grpcdbpb.ParamType_NUMBER:
+		return t == grpcdbpb.ParamType_INTEGER
+	}
+	return false
+}
+
+func typeName(t grpcdbpb.ParamType) string {
+	if t == grpcdbpb.ParamType_ANY {
+		return "null"
+	}
+	return strings.ToLower(t.String())
+}
+
+// driverValue converts a value into one the database driver can bind.
+func driverValue(v *grpcdbpb.Value) (interface{}, error) {
+	switch v := v.GetValue().(type) {
+	case *grpcdbpb.Value_Null:
+		return nil, nil
+	case *grpcdbpb.Value_Str:
+		return v.Str, nil
+	case *grpcdbpb.Value_Num:
+		return v.Num, nil
+	case *grpcdbpb.Value_Int:
+		return v.Int, nil
+	case *grpcdbpb.Value_Boolean:
+		return v.Boolean, nil
+	case *grpcdbpb.Value_Blob:
+		return v.Blob, nil
+	case *grpcdbpb.Value_Time:
+		return ptypes.Timestamp(v.Time)
+	case nil:
+		return nil, fmt.Errorf("value is required")
+	default:
+		return nil, fmt.Errorf("unsupported value %T", v)
+	}
+}
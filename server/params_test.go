@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/ptypes"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBindParams(t *testing.T) {
+	ts, _ := ptypes.TimestampProto(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	params := []*grpcdbpb.Param{
+		{Name: "id", Type: grpcdbpb.ParamType_NUMBER},
+		{Name: "name"},
+		{Name: "born", Type: grpcdbpb.ParamType_TIMESTAMP},
+	}
+	table := []struct {
+		name   string
+		values map[string]*grpcdbpb.Value
+		args   []interface{}
+		fields []string
+	}{
+		{
+			"bound",
+			map[string]*grpcdbpb.Value{
+				"id":   {Value: &grpcdbpb.Value_Int{Int: 1}},
+				"name": {Value: &grpcdbpb.Value_Boolean{Boolean: true}},
+				"born": {Value: &grpcdbpb.Value_Time{Time: ts}},
+			},
+			[]interface{}{int64(1), true, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"nulls",
+			map[string]*grpcdbpb.Value{
+				"id":   {Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}},
+				"name": {Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}},
+				"born": {Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}},
+			},
+			[]interface{}{nil, nil, nil},
+			nil,
+		},
+		{
+			"missing, mistyped and unused",
+			map[string]*grpcdbpb.Value{
+				"id":    {Value: &grpcdbpb.Value_Str{Str: "1"}},
+				"name":  {},
+				"extra": {Value: &grpcdbpb.Value_Int{Int: 1}},
+			},
+			nil,
+			[]string{"parameters[id]", "parameters[name]", "parameters[born]", "parameters[extra]"},
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := bindParams(params, tt.values)
+			var fields []string
+			if ve, ok := err.(*grpcdb.ValidationError); ok {
+				for _, v := range ve.Violations {
+					fields = append(fields, v.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Expected violations: %v\nActual: %v (%v)", tt.fields, fields, err)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("Expected args: %v\nActual: %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE person (id INTEGER, name TEXT); INSERT INTO person VALUES (1, 'a'), (2, 'b')")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &grpcdbpb.DatabaseSchema{Schemas: []*grpcdbpb.Schema{{
+		Name: "public",
+		Tables: []*grpcdbpb.Table{{
+			Name:    "person",
+			Columns: []*grpcdbpb.Column{{Name: "id", Type: "integer"}, {Name: "name", Type: "text"}},
+		}},
+	}}}
+	h := &handler{db: db, schema: &schemaCache{schema: schema, loaded: time.Now(), maxAge: time.Hour}}
+
+	statement := query.MustParse("select name from person where id = :id::integer")
+	statement.Parameters = map[string]*grpcdbpb.Value{"id": {Value: &grpcdbpb.Value_Int{Int: 2}}}
+	result, err := h.Query(context.Background(), statement)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Values[0].GetStr() != "b" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+
+	statement.Parameters["id"] = &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: "2"}}
+	_, err = h.Query(context.Background(), statement)
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Expected InvalidArgument with details, got: %v", err)
+	}
+	br := st.Details()[0].(*errdetails.BadRequest)
+	if len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "parameters[id]" || br.FieldViolations[0].Description != "can't bind string value to integer parameter" {
+		t.Errorf("Unexpected violations: %v", br.FieldViolations)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/GeorgeBills/grpcdb/policy"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// policyFile holds the policy loaded from filename, reloading it when the file
+// is modified. If reloading fails the previous policy remains in force.
+type policyFile struct {
+	filename string
+
+	mu      sync.Mutex
+	policy  *policy.Policy
+	modTime time.Time
+}
+
+func newPolicyFile(filename string) (*policyFile, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := policy.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &policyFile{filename: filename, policy: p, modTime: fi.ModTime()}, nil
+}
+
+func (pf *policyFile) get() *policy.Policy {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	fi, err := os.Stat(pf.filename)
+	if err != nil {
+		log.Printf("Error checking policy for changes: %v", err)
+		return pf.policy
+	}
+	if fi.ModTime().Equal(pf.modTime) {
+		return pf.policy
+	}
+	p, err := policy.Load(pf.filename)
+	if err != nil {
+		log.Printf("Error reloading policy, continuing with the previous policy: %v", err)
+	} else {
+		log.Printf("Reloaded policy from %s", pf.filename)
+		pf.policy = p
+	}
+	// don't retry a broken file until it's modified again
+	pf.modTime = fi.ModTime()
+	return pf.policy
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/GeorgeBills/grpcdb/query"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyFileReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, filename, []byte(`{"principals": {"a": [{"table": "t", "privileges": ["select"]}]}}`))
+	pf, err := newPolicyFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	statement := query.MustParse("delete from t")
+	if pf.get().Check("a", statement) == nil {
+		t.Errorf("Expected delete to be denied")
+	}
+
+	writeFile(t, filename, []byte(`{"principals": {"a": [{"table": "t", "privileges": ["select", "delete"]}]}}`))
+	touch(t, filename)
+	if err := pf.get().Check("a", statement); err != nil {
+		t.Errorf("Expected delete to be allowed after reloading, got: %v", err)
+	}
+
+	// a broken policy leaves the previous one in force
+	writeFile(t, filename, []byte(`{"principals": `))
+	if err := pf.get().Check("a", statement); err != nil {
+		t.Errorf("Expected the previous policy to remain in force, got: %v", err)
+	}
+}
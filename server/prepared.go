@@ -0,0 +1,298 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/policy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Prepare checks and translates a statement with parameters, as Query does,
+// and prepares it on the database. The returned handle runs it with
+// ExecutePrepared.
+func (h *handler) Prepare(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.PreparedHandle, error) {
+	logStatement(ctx, "prepare", statement)
+	if h.prepared.size <= 0 {
+		return nil, status.Error(codes.FailedPrecondition, "prepared statements are disabled on this server")
+	}
+	p, err := h.prepare(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	handle := &grpcdbpb.PreparedHandle{
+		Id:         fingerprint(p.principal, p.claims, p.sql),
+		Parameters: paramNames(p.params),
+	}
+	if h.prepared.get(handle.Id) != nil {
+		return handle, nil
+	}
+	log.Printf("Preparing statement: %s", p.sql)
+	stmt, err := h.db.PrepareContext(ctx, p.sql)
+	if err != nil {
+		log.Printf("Error preparing statement: %v", err)
+		return nil, databaseError(err)
+	}
+	h.prepared.release(h.prepared.put(&cachedStmt{id: handle.Id, original: statement, prepared: p, stmt: stmt}))
+	return handle, nil
+}
+
+// ExecutePrepared runs a statement returned by Prepare, with values bound to
+// its parameters.
+func (h *handler) ExecutePrepared(ctx context.Context, req *grpcdbpb.ExecutePreparedRequest) (*grpcdbpb.Result, error) {
+	cs := h.prepared.acquire(req.Id)
+	if cs == nil {
+		log.Printf("Unknown prepared statement: %s", req.Id)
+		return nil, status.Errorf(codes.NotFound, "no prepared statement %s; it may have been evicted, so prepare it again", req.Id)
+	}
+	defer func() { h.prepared.release(cs) }()
+	name := ""
+	if pr := principalFromContext(ctx); pr != nil {
+		name = pr.name
+	}
+	// don't reveal statements prepared by someone else
+	if cs.prepared.principal != name {
+		log.Printf("Unknown prepared statement: %s", req.Id)
+		return nil, status.Errorf(codes.NotFound, "no prepared statement %s; it may have been evicted, so prepare it again", req.Id)
+	}
+	log.Printf("Executing prepared statement %s", req.Id)
+	ctx, cancel := h.withTimeout(ctx, cs.original)
+	defer cancel()
+	refreshed, err := h.refresh(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+	h.prepared.release(cs)
+	cs = refreshed
+	p := cs.prepared
+	args, err := bindParams(p.params, req.Parameters)
+	if err != nil {
+		log.Printf("Invalid parameters: %v", err)
+		return nil, invalidArgument(err.(*grpcdb.ValidationError))
+	}
+	err = h.checkCost(ctx, p, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Running prepared statement: %s", p.sql)
+	var result *grpcdbpb.Result
+	if p.statement.GetSelect() != nil {
+		result, err = h.queryPrepared(ctx, cs.stmt, args)
+	} else {
+		result, err = h.execPrepared(ctx, cs.stmt, args)
+	}
+	if err != nil {
+		return nil, runError(err)
+	}
+	return result, nil
+}
+
+// refresh checks a cached statement again if the policy has been reloaded, or
+// the caller's claims differ from the caller who prepared it, since either may
+// change the SQL it should run. The statement is then run from the entry for
+// the caller's claims and the SQL, which is prepared again if it isn't cached
+// or was checked against an older policy; the entry for the handle is left as
+// it is for other callers. It returns an entry the caller must release.
+func (h *handler) refresh(ctx context.Context, cs *cachedStmt) (*cachedStmt, error) {
+	var pol *policy.Policy
+	if h.policy != nil {
+		pol = h.policy.get()
+	}
+	var claims map[string]string
+	if pr := principalFromContext(ctx); pr != nil {
+		claims = pr.claims
+	}
+	sameClaims := reflect.DeepEqual(claims, cs.prepared.claims)
+	if pol == cs.prepared.policy && sameClaims {
+		return h.prepared.retain(cs), nil
+	}
+	p, err := h.prepare(ctx, cs.original)
+	if err != nil {
+		if sameClaims {
+			// the policy no longer allows the statement
+			h.prepared.remove(cs.id)
+		}
+		return nil, err
+	}
+	id := fingerprint(p.principal, p.claims, p.sql)
+	if found := h.prepared.acquire(id); found != nil {
+		if found.prepared.policy == pol {
+			return found, nil
+		}
+		h.prepared.release(found)
+	}
+	log.Printf("Preparing statement again: %s", p.sql)
+	stmt, err := h.db.PrepareContext(ctx, p.sql)
+	if err != nil {
+		log.Printf("Error preparing statement: %v", err)
+		return nil, databaseError(err)
+	}
+	return h.prepared.put(&cachedStmt{id: id, original: cs.original, prepared: p, stmt: stmt}), nil
+}
+
+func (h *handler) queryPrepared(ctx context.Context, stmt *sql.Stmt, args []interface{}) (*grpcdbpb.Result, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return readResult(rows)
+}
+
+func (h *handler) execPrepared(ctx context.Context, stmt *sql.Stmt, args []interface{}) (*grpcdbpb.Result, error) {
+	if h.maxRowsAffected > 0 {
+		n, err := execLimited(ctx, h.db, h.maxRowsAffected, func(tx *sql.Tx) (sql.Result, error) {
+			return tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &grpcdbpb.Result{RowsAffected: n}, nil
+	}
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &grpcdbpb.Result{RowsAffected: n}, nil
+}
+
+// fingerprint identifies the SQL prepared for a principal with claims.
+func fingerprint(principal string, claims map[string]string, sql string) string {
+	keys := make([]string, 0, len(claims))
+	for k := range claims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	h.Write([]byte(principal + "\x00"))
+	for _, k := range keys {
+		h.Write([]byte(k + "=" + claims[k] + "\x00"))
+	}
+	h.Write([]byte(sql))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// stmtCache holds up to size prepared statements by handle, evicting the least
+// recently used when it's full. Statements are reference counted, so that an
+// evicted statement is only closed once no ExecutePrepared is using it.
+type stmtCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cachedStmt
+	lru     *list.List               // most recently used first
+}
+
+type cachedStmt struct {
+	id       string
+	original *grpcdbpb.Statement // as the client sent it, to check it again
+	prepared *prepared
+	stmt     *sql.Stmt
+
+	// guarded by the cache's mu
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *stmtCache) get(id string) *cachedStmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedStmt)
+}
+
+// acquire returns the statement with a handle, or nil if there isn't one. The
+// statement must be released after use.
+func (c *stmtCache) acquire(id string) *cachedStmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	cs := e.Value.(*cachedStmt)
+	cs.refs++
+	return cs
+}
+
+// retain acquires a statement the caller already holds.
+func (c *stmtCache) retain(cs *cachedStmt) *cachedStmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs.refs++
+	return cs
+}
+
+// release gives up a statement, closing it if it has been evicted and nothing
+// else is using it.
+func (c *stmtCache) release(cs *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs.refs--
+	if cs.evicted && cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// put adds a statement, replacing any with the same handle, and returns it
+// acquired.
+func (c *stmtCache) put(cs *cachedStmt) *cachedStmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs.refs++
+	if e, ok := c.entries[cs.id]; ok {
+		c.evict(e.Value.(*cachedStmt))
+		e.Value = cs
+		c.lru.MoveToFront(e)
+		return cs
+	}
+	c.entries[cs.id] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		evicted := c.lru.Remove(e).(*cachedStmt)
+		delete(c.entries, evicted.id)
+		c.evict(evicted)
+	}
+	return cs
+}
+
+func (c *stmtCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, id)
+		c.evict(e.Value.(*cachedStmt))
+	}
+}
+
+// evict closes a statement which has been removed from the cache, or leaves it
+// to be closed by its last release. c.mu must be held.
+func (c *stmtCache) evict(cs *cachedStmt) {
+	cs.evicted = true
+	if cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
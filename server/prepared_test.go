@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrepared(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE person (id INTEGER, name TEXT); INSERT INTO person VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &grpcdbpb.DatabaseSchema{Schemas: []*grpcdbpb.Schema{{
+		Name: "public",
+		Tables: []*grpcdbpb.Table{{
+			Name: "person",
+			Columns: []*grpcdbpb.Column{
+				{Name: "id", Type: "integer"},
+				{Name: "name", Type: "text"},
+			},
+		}},
+	}}}
+	h := &handler{
+		db:       db,
+		schema:   &schemaCache{schema: schema, loaded: time.Now(), maxAge: time.Hour},
+		prepared: newStmtCache(1),
+	}
+	ctx := context.Background()
+
+	handle, err := h.Prepare(ctx, query.MustParse("select name from person where id = :id or id > :id"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(handle.Parameters) != 1 || handle.Parameters[0] != "id" {
+		t.Errorf("Unexpected parameters: %v", handle.Parameters)
+	}
+	again, err := h.Prepare(ctx, query.MustParse("select name from person where id = :id or id > :id"))
+	if err != nil || again.Id != handle.Id {
+		t.Errorf("Expected the same handle, got %v (%v)", again, err)
+	}
+	params := map[string]*grpcdbpb.Value{"id": {Value: &grpcdbpb.Value_Int{Int: 2}}}
+	result, err := h.ExecutePrepared(ctx, &grpcdbpb.ExecutePreparedRequest{Id: handle.Id, Parameters: params})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Rows) != 2 || result.Rows[0].Values[0].GetStr() != "b" || result.Rows[1].Values[0].GetStr() != "c" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+
+	_, err = h.ExecutePrepared(ctx, &grpcdbpb.ExecutePreparedRequest{Id: handle.Id, Parameters: map[string]*grpcdbpb.Value{
+		"name": {Value: &grpcdbpb.Value_Str{Str: "a"}},
+	}})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Expected InvalidArgument with details, got: %v", err)
+	}
+	br := st.Details()[0].(*errdetails.BadRequest)
+	if len(br.FieldViolations) != 2 || br.FieldViolations[0].Field != "parameters[id]" || br.FieldViolations[1].Field != "parameters[name]" {
+		t.Errorf("Unexpected violations: %v", br.FieldViolations)
+	}
+
+	other := contextWithPrincipal(ctx, &principal{name: "b", source: "test"})
+	_, err = h.ExecutePrepared(other, &grpcdbpb.ExecutePreparedRequest{Id: handle.Id, Parameters: params})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected another principal's statement to be hidden, got: %v", err)
+	}
+
+	update, err := h.Prepare(ctx, query.MustParse("update person set name = :name where id = :id"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = h.ExecutePrepared(ctx, &grpcdbpb.ExecutePreparedRequest{Id: handle.Id, Parameters: params})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected the select to have been evicted, got: %v", err)
+	}
+	result, err = h.ExecutePrepared(ctx, &grpcdbpb.ExecutePreparedRequest{Id: update.Id, Parameters: map[string]*grpcdbpb.Value{
+		"id":   {Value: &grpcdbpb.Value_Int{Int: 1}},
+		"name": {Value: &grpcdbpb.Value_Str{Str: "z"}},
+	}})
+	if err != nil || result.RowsAffected != 1 {
+		t.Fatalf("Unexpected result: %v (%v)", result, err)
+	}
+	var name string
+	err = db.QueryRow("SELECT name FROM person WHERE id = 1").Scan(&name)
+	if err != nil || name != "z" {
+		t.Errorf("Expected the update to bind its parameters, got %q (%v)", name, err)
+	}
+}
+
+func TestPreparedClaims(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE person (id INTEGER, tenant INTEGER); INSERT INTO person VALUES (1, 1), (2, 2), (3, 2)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, filename, []byte(`{
+		"principals": {"a": [{"table": "person", "privileges": ["select"], "where": "tenant = claims.tenant"}]}
+	}`))
+	pf, err := newPolicyFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &grpcdbpb.DatabaseSchema{Schemas: []*grpcdbpb.Schema{{
+		Name: "public",
+		Tables: []*grpcdbpb.Table{{
+			Name: "person",
+			Columns: []*grpcdbpb.Column{
+				{Name: "id", Type: "integer"},
+				{Name: "tenant", Type: "integer"},
+			},
+		}},
+	}}}
+	h := &handler{
+		db:       db,
+		policy:   pf,
+		schema:   &schemaCache{schema: schema, loaded: time.Now(), maxAge: time.Hour},
+		prepared: newStmtCache(2),
+	}
+	tenant := func(id string) context.Context {
+		return contextWithPrincipal(context.Background(), &principal{name: "a", source: "test", claims: map[string]string{"tenant": id}})
+	}
+
+	handle, err := h.Prepare(tenant("1"), query.MustParse("select id from person"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the handle runs with the claims of whoever executes it, without
+	// disturbing the statement prepared for the original claims
+	for _, tt := range []struct {
+		tenant   string
+		expected int
+	}{{"2", 2}, {"1", 1}, {"2", 2}} {
+		result, err := h.ExecutePrepared(tenant(tt.tenant), &grpcdbpb.ExecutePreparedRequest{Id: handle.Id})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(result.Rows) != tt.expected {
+			t.Errorf("Expected %d rows for tenant %s, got: %v", tt.expected, tt.tenant, result)
+		}
+	}
+	if cs := h.prepared.get(handle.Id); cs == nil || cs.prepared.claims["tenant"] != "1" {
+		t.Errorf("Expected the handle to keep the original claims, got: %v", cs)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	prepare := func() *sql.Stmt {
+		stmt, err := db.Prepare("SELECT 1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return stmt
+	}
+	c := newStmtCache(1)
+	a := c.put(&cachedStmt{id: "a", stmt: prepare()})
+	c.release(c.put(&cachedStmt{id: "b", stmt: prepare()}))
+	if c.get("a") != nil {
+		t.Fatalf("Expected a to have been evicted")
+	}
+	var n int
+	err = a.stmt.QueryRow().Scan(&n)
+	if err != nil {
+		t.Errorf("Expected an evicted statement to stay open while in use, got: %v", err)
+	}
+	c.release(a)
+	err = a.stmt.QueryRow().Scan(&n)
+	if err == nil {
+		t.Errorf("Expected an evicted statement to be closed once released")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// principal is the identity of the caller.
+type principal struct {
+	name   string
+	source string // how the caller was identified, e.g. "tls"
+	// claims are attributes of the caller, such as their tenant, which row
+	// filters in the policy may refer to
+	claims map[string]string
+}
+
+func (p *principal) String() string {
+	return p.name + " (" + p.source + ")"
+}
+
+type principalKey struct{}
+
+func contextWithPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext returns the caller's identity, or nil if the caller
+// hasn't been identified.
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// tlsPrincipal returns the subject of the client certificate verified during
+// a mutual TLS handshake, or nil if the client didn't present one.
+func tlsPrincipal(ctx context.Context) *principal {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	subject := info.State.VerifiedChains[0][0].Subject
+	claims := map[string]string{"cn": subject.CommonName}
+	if len(subject.Organization) > 0 {
+		claims["o"] = subject.Organization[0]
+	}
+	if len(subject.OrganizationalUnit) > 0 {
+		claims["ou"] = subject.OrganizationalUnit[0]
+	}
+	return &principal{
+		name:   subject.String(),
+		source: "tls",
+		claims: claims,
+	}
+}
+
+// identify is a server interceptor which identifies callers by their client
+// certificate.
+func identify(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if p := tlsPrincipal(ctx); p != nil {
+		ctx = contextWithPrincipal(ctx, p)
+	}
+	return handler(ctx, req)
+}
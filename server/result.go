@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"time"
+)
+
+// readResult reads every row into a result.
+func readResult(rows *sql.Rows) (*grpcdbpb.Result, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result := &grpcdbpb.Result{Columns: columns}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		row := &grpcdbpb.ResultRow{Values: make([]*grpcdbpb.Value, len(columns))}
+		for i, v := range values {
+			row.Values[i], err = toValue(v, types[i].DatabaseTypeName())
+			if err != nil {
+				return nil, fmt.Errorf("Error reading column %s: %v", columns[i], err)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
+
+// toValue converts a value returned by the driver into a result value. Drivers
+// return the text representation of types they don't know how to decode (e.g.
+// uuid, numeric and enums) as []byte, so only bytea is sent as a blob. Times of
+// day are sent as strings, as PostgreSQL writes them.
+func toValue(v interface{}, databaseType string) (*grpcdbpb.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}}, nil
+	case int64:
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Int{Int: v}}, nil
+	case float64:
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Num{Num: v}}, nil
+	case bool:
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Boolean{Boolean: v}}, nil
+	case string:
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: v}}, nil
+	case []byte:
+		if databaseType == "BYTEA" || databaseType == "BLOB" {
+			return &grpcdbpb.Value{Value: &grpcdbpb.Value_Blob{Blob: v}}, nil
+		}
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: string(v)}}, nil
+	case time.Time:
+		// lib/pq returns times of day as times on January 1st of year 0,
+		// which is out of a Timestamp's range
+		switch databaseType {
+		case "TIME":
+			return &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: v.Format("15:04:05.999999")}}, nil
+		case "TIMETZ":
+			return &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: v.Format("15:04:05.999999Z07:00")}}, nil
+		}
+		ts, err := ptypes.TimestampProto(v)
+		if err != nil {
+			return nil, err
+		}
+		return &grpcdbpb.Value{Value: &grpcdbpb.Value_Time{Time: ts}}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported value type: %T", v)
+	}
+}
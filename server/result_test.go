@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+	"time"
+)
+
+func TestReadResult(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Couldn't open SQLite database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE t (s TEXT, i INTEGER, f REAL, n TEXT, b BLOB);
+		INSERT INTO t VALUES ('a', 1, 1.5, NULL, X'0102');
+	`)
+	if err != nil {
+		t.Fatalf("Couldn't create table: %v", err)
+	}
+	rows, err := db.Query("SELECT s, i, f, n, b FROM t")
+	if err != nil {
+		t.Fatalf("Couldn't query: %v", err)
+	}
+	defer rows.Close()
+	actual, err := readResult(rows)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &grpcdbpb.Result{
+		Columns: []string{"s", "i", "f", "n", "b"},
+		Rows: []*grpcdbpb.ResultRow{{Values: []*grpcdbpb.Value{
+			{Value: &grpcdbpb.Value_Str{Str: "a"}},
+			{Value: &grpcdbpb.Value_Int{Int: 1}},
+			{Value: &grpcdbpb.Value_Num{Num: 1.5}},
+			{Value: &grpcdbpb.Value_Null{Null: &grpcdbpb.Null{}}},
+			{Value: &grpcdbpb.Value_Blob{Blob: []byte{1, 2}}},
+		}}},
+	}
+	if !proto.Equal(actual, expected) {
+		t.Errorf("Expected: %v\nActual: %v", expected, actual)
+	}
+}
+
+func TestToValueTimeOfDay(t *testing.T) {
+	// as lib/pq returns time and timetz columns
+	table := []struct {
+		value        time.Time
+		databaseType string
+		expected     *grpcdbpb.Value
+	}{
+		{time.Date(0, 1, 1, 13, 4, 5, 0, time.UTC), "TIME", &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: "13:04:05"}}},
+		{time.Date(0, 1, 1, 13, 4, 5, 250000000, time.FixedZone("", -7*60*60)), "TIMETZ", &grpcdbpb.Value{Value: &grpcdbpb.Value_Str{Str: "13:04:05.25-07:00"}}},
+		{time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC), "TIMESTAMPTZ", &grpcdbpb.Value{Value: &grpcdbpb.Value_Time{Time: &timestamp.Timestamp{Seconds: 946782245}}}},
+	}
+	for _, tt := range table {
+		t.Run(tt.databaseType, func(t *testing.T) {
+			actual, err := toValue(tt.value, tt.databaseType)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(actual, tt.expected) {
+				t.Errorf("Expected: %v\nActual: %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/introspect"
+	"sync"
+	"time"
+)
+
+// schemaCache holds the introspected database schema used to type check
+// statements, reloading it once it's older than maxAge.
+type schemaCache struct {
+	db     *sql.DB
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	schema *grpcdbpb.DatabaseSchema
+	loaded time.Time
+}
+
+func (sc *schemaCache) get(ctx context.Context) (*grpcdbpb.DatabaseSchema, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.schema != nil && time.Since(sc.loaded) < sc.maxAge {
+		return sc.schema, nil
+	}
+	schema, err := introspect.Postgres(ctx, sc.db, nil)
+	if err != nil {
+		return nil, err
+	}
+	sc.schema, sc.loaded = schema, time.Now()
+	return schema, nil
+}
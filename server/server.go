@@ -3,20 +3,44 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"github.com/GeorgeBills/grpcdb"
 	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/introspect"
+	"github.com/GeorgeBills/grpcdb/policy"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	_ "github.com/lib/pq"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"log"
 	"net"
+	"time"
 )
 
 const (
 	listen         = ":1234"
 	dataSourceName = "host=127.0.0.1 port=5432 user=postgres password=chbqkWQQkgEJh2 dbname=postgres sslmode=disable"
+	schemaMaxAge   = time.Minute
 )
 
 func main() {
+	tlsCert := flag.String("tls-cert", "", "serve TLS with the certificate in this PEM file")
+	tlsKey := flag.String("tls-key", "", "serve TLS with the private key in this PEM file")
+	tlsClientCA := flag.String("tls-client-ca", "", "require client certificates signed by a CA in this PEM file (mutual TLS)")
+	authConfig := flag.String("auth-config", "", "require callers to authenticate with an API key or JWT as configured in this JSON file")
+	policyFilename := flag.String("policy", "", "only allow statements granted by the policy in this JSON file, which is reloaded when modified")
+	defaultTimeout := flag.Duration("default-timeout", 30*time.Second, "cancel statements which run for longer than this, unless they set their own timeout (0 for no default)")
+	maxTimeout := flag.Duration("max-timeout", 5*time.Minute, "cancel statements which run for longer than this, whatever timeout they set; also set as PostgreSQL's statement_timeout (0 for no maximum)")
+	preparedCacheSize := flag.Int("prepared-cache-size", 1000, "keep at most this many prepared statements, closing the least recently used")
+	maxRowsAffected := flag.Int64("max-rows-affected", 0, "roll back inserts, updates and deletes which affect more than this many rows (0 for no limit)")
+	flag.Parse()
+
 	// listen on socket
 	lis, err := net.Listen("tcp", listen)
 	if err != nil {
@@ -24,7 +48,14 @@ func main() {
 	}
 
 	// get database connection
-	db, err := sql.Open("postgres", dataSourceName)
+	dsn := dataSourceName
+	if *maxTimeout > 0 {
+		// lib/pq passes unrecognised parameters to the server as run-time
+		// settings, so the database cancels long statements on every
+		// connection even if a cancellation from the server is lost
+		dsn += fmt.Sprintf(" statement_timeout=%d", maxTimeout.Milliseconds())
+	}
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,31 +69,290 @@ func main() {
 	log.Printf("Database connected")
 
 	// start server
-	server := grpc.NewServer()
+	interceptors := []grpc.UnaryServerInterceptor{identify}
+	if *authConfig != "" {
+		verifiers, err := loadAuthConfig(*authConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		interceptors = append(interceptors, (&authenticator{verifiers: verifiers}).intercept)
+	} else if *tlsClientCA == "" {
+		log.Printf("Serving without authentication; anyone who can connect can run any statement")
+	}
+	options := []grpc.ServerOption{grpc.UnaryInterceptor(chainUnaryServer(interceptors...))}
+	switch {
+	case *tlsCert != "" && *tlsKey != "":
+		certs, err := newCertReloader(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(certs.tlsConfig())))
+	case *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "":
+		log.Fatal("Both -tls-cert and -tls-key are required to serve TLS")
+	default:
+		log.Printf("Serving without TLS; statements and results will be sent in clear text")
+	}
+	server := grpc.NewServer(options...)
 	handler := &handler{
-		db,
+		db:              db,
+		schema:          &schemaCache{db: db, maxAge: schemaMaxAge},
+		maxRowsAffected: *maxRowsAffected,
+		defaultTimeout:  *defaultTimeout,
+		maxTimeout:      *maxTimeout,
+		prepared:        newStmtCache(*preparedCacheSize),
+	}
+	if *policyFilename != "" {
+		handler.policy, err = newPolicyFile(*policyFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	grpcdbpb.RegisterGRPCDBServer(server, handler)
 	server.Serve(lis)
 }
 
 type handler struct {
-	db *sql.DB
+	db     *sql.DB
+	schema *schemaCache
+	policy *policyFile // nil if every statement is allowed
+	// maxRowsAffected is the most rows a write may affect, or 0 for no limit
+	maxRowsAffected int64
+	// defaultTimeout applies to statements which don't set a timeout, and
+	// maxTimeout caps the timeout they set; either may be 0 for no limit
+	defaultTimeout, maxTimeout time.Duration
+	prepared                   *stmtCache
 }
 
 func (h *handler) Query(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.Result, error) {
-	log.Printf("Received statement: %+v", statement)
-	sql, err := grpcdb.TranslateStatement(statement)
+	logStatement(ctx, "statement", statement)
+	ctx, cancel := h.withTimeout(ctx, statement)
+	defer cancel()
+	p, err := h.prepare(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	args, err := bindStatementParams(statement, p)
+	if err != nil {
+		return nil, err
+	}
+	err = h.checkCost(ctx, p, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Running statement: %s", p.sql)
+	var result *grpcdbpb.Result
+	if p.statement.GetSelect() != nil {
+		result, err = h.query(ctx, p.sql, args...)
+	} else {
+		result, err = h.exec(ctx, p.sql, args...)
+	}
+	if err != nil {
+		return nil, runError(err)
+	}
+	return result, nil
+}
+
+// runError converts an error from running a statement into a status.
+func runError(err error) error {
+	if tmr, ok := err.(*tooManyRowsError); ok {
+		log.Printf("Statement refused: %v", err)
+		return failedPrecondition(err.Error(), tmr.violation())
+	}
+	log.Printf("Error running statement: %v", err)
+	return databaseError(err)
+}
+
+func logStatement(ctx context.Context, what string, statement *grpcdbpb.Statement) {
+	if p := principalFromContext(ctx); p != nil {
+		log.Printf("Received %s from %v:\n%s", what, p, query.Pretty(statement))
+	} else {
+		log.Printf("Received %s:\n%s", what, query.Pretty(statement))
+	}
+}
+
+// prepared is a statement which has passed the server's checks.
+type prepared struct {
+	statement  *grpcdbpb.Statement // rewritten by the policy
+	sql        string
+	params     []*grpcdbpb.Param // in placeholder order
+	principal  string
+	claims     map[string]string
+	policy     *policy.Policy // nil if every statement is allowed
+	limits     policy.Limits
+	predicates []policy.Predicate // row filters added by the policy
+	schema     *grpcdbpb.DatabaseSchema
+}
+
+// prepare checks the statement against the server's guards and policy, and
+// translates it to SQL. Errors are returned as statuses.
+func (h *handler) prepare(ctx context.Context, statement *grpcdbpb.Statement) (*prepared, error) {
+	err := grpcdb.Validate(statement)
+	if err != nil {
+		log.Printf("Invalid statement: %v", err)
+		return nil, invalidArgument(err.(*grpcdb.ValidationError))
+	}
+	if table := fullTableWrite(statement); table != "" {
+		msg := fmt.Sprintf("refusing to change every row of %s; add a where clause, or set allow_full_table", table)
+		log.Printf("Statement refused: %s", msg)
+		return nil, failedPrecondition(msg, &errdetails.PreconditionFailure_Violation{
+			Type:        "WHERE_REQUIRED",
+			Subject:     table,
+			Description: msg,
+		})
+	}
+	p := &prepared{statement: statement}
+	if pr := principalFromContext(ctx); pr != nil {
+		p.principal, p.claims = pr.name, pr.claims
+	}
+	if h.policy != nil {
+		pol := h.policy.get()
+		p.policy = pol
+		err = pol.Check(p.principal, statement)
+		if err != nil {
+			log.Printf("Statement denied by policy: %v", err)
+			return nil, permissionDenied(err.(*policy.DeniedError))
+		}
+		limited, err := pol.Enforce(p.principal, statement)
+		if err != nil {
+			log.Printf("Statement exceeds limits: %v", err)
+			return nil, resourceExhausted(err.(*policy.LimitError))
+		}
+		p.statement = limited
+		p.limits = pol.LimitsFor(p.principal)
+	}
+	// the schema types the claims bound by row filters, as well as checking
+	// the statement
+	p.schema, err = h.schema.get(ctx)
+	if err != nil {
+		log.Printf("Error loading schema: %v", err)
+		return nil, databaseError(err)
+	}
+	if p.policy != nil {
+		filtered, predicates, err := p.policy.Apply(p.principal, p.claims, p.statement, p.schema)
+		if err != nil {
+			log.Printf("Statement denied by row filters: %v", err)
+			return nil, permissionDenied(err.(*policy.DeniedError))
+		}
+		if !proto.Equal(filtered, statement) {
+			log.Printf("Rewrote statement per policy:\n%s", query.Pretty(filtered))
+			p.statement = filtered
+		}
+		p.predicates = predicates
+	}
+	err = grpcdb.TypeCheck(p.statement, p.schema)
 	if err != nil {
+		log.Printf("Statement failed type checking: %v", err)
+		return nil, invalidArgument(err.(*grpcdb.ValidationError))
+	}
+	p.sql, p.params, err = grpcdb.TranslateWithParams(p.statement)
+	if err != nil {
+		// the statement is valid, so the translator doesn't support it
 		log.Printf("Error translating statement: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return p, nil
+}
+
+// bindStatementParams binds the parameters sent with a statement to its
+// placeholders. Errors are returned as statuses.
+func bindStatementParams(statement *grpcdbpb.Statement, p *prepared) ([]interface{}, error) {
+	args, err := bindParams(p.params, statement.Parameters)
+	if err != nil {
+		log.Printf("Invalid parameters: %v", err)
+		return nil, invalidArgument(err.(*grpcdb.ValidationError))
+	}
+	return args, nil
+}
+
+// checkCost refuses statements which the database estimates will cost more
+// than the principal's limit. args are bound to the statement's parameters.
+func (h *handler) checkCost(ctx context.Context, p *prepared, args ...interface{}) error {
+	if p.limits.MaxCost <= 0 {
+		return nil
+	}
+	cost, err := explainCost(ctx, h.db, p.sql, args...)
+	if err != nil {
+		log.Printf("Error estimating cost: %v", err)
+		return databaseError(err)
+	}
+	if cost > p.limits.MaxCost {
+		err := &policy.LimitError{
+			Principal: p.principal,
+			Reasons:   []string{fmt.Sprintf("estimated cost %g is more than the limit of %g", cost, p.limits.MaxCost)},
+		}
+		log.Printf("Statement exceeds limits: %v", err)
+		return resourceExhausted(err)
+	}
+	return nil
+}
+
+// withTimeout returns a context which is cancelled once the statement has run
+// for as long as it may.
+func (h *handler) withTimeout(ctx context.Context, statement *grpcdbpb.Statement) (context.Context, context.CancelFunc) {
+	if timeout := h.timeout(statement); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timeout returns how long the statement may run: the timeout it sets, or the
+// default if it doesn't set one, but no more than the maximum. The caller's
+// deadline, if earlier, still applies.
+func (h *handler) timeout(statement *grpcdbpb.Statement) time.Duration {
+	timeout := h.defaultTimeout
+	if statement.GetTimeout() != nil {
+		// invalid timeouts are rejected by grpcdb.Validate
+		timeout, _ = ptypes.Duration(statement.Timeout)
+	}
+	if h.maxTimeout > 0 && (timeout <= 0 || timeout > h.maxTimeout) {
+		timeout = h.maxTimeout
+	}
+	return timeout
+}
+
+func (h *handler) query(ctx context.Context, sql string, args ...interface{}) (*grpcdbpb.Result, error) {
+	rows, err := h.db.QueryContext(ctx, sql, args...)
+	if err != nil {
 		return nil, err
 	}
-	log.Printf("Running statement: %s", sql)
-	res, err := h.db.Exec(sql)
+	defer rows.Close()
+	return readResult(rows)
+}
+
+func (h *handler) exec(ctx context.Context, query string, args ...interface{}) (*grpcdbpb.Result, error) {
+	if h.maxRowsAffected > 0 {
+		n, err := execLimited(ctx, h.db, h.maxRowsAffected, func(tx *sql.Tx) (sql.Result, error) {
+			return tx.ExecContext(ctx, query, args...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &grpcdbpb.Result{RowsAffected: n}, nil
+	}
+	res, err := h.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("Error running statement: %v", err)
 		return nil, err
 	}
-	log.Print(res)
-	return &grpcdbpb.Result{}, nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &grpcdbpb.Result{RowsAffected: n}, nil
+}
+
+func (h *handler) DescribeSchema(ctx context.Context, req *grpcdbpb.DescribeSchemaRequest) (*grpcdbpb.DatabaseSchema, error) {
+	log.Printf("Describing schemas: %v", req.Schemas)
+	schema, err := introspect.Postgres(ctx, h.db, req.Schemas)
+	if err != nil {
+		log.Printf("Error describing schemas: %v", err)
+		return nil, databaseError(err)
+	}
+	if h.policy != nil {
+		var name string
+		if pr := principalFromContext(ctx); pr != nil {
+			name = pr.name
+		}
+		schema = h.policy.get().Visible(name, schema)
+	}
+	return schema, nil
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate and key in certFile and keyFile, and if
+// clientCAFile is set requires clients to present a certificate signed by one
+// of the CAs in it. The files are checked on each handshake and reloaded if
+// they've been modified, so certificates can be rotated without a restart. If
+// reloading fails the previously loaded files continue to be used.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes [3]time.Time
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	_, err := cr.load()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// tlsConfig returns the configuration to serve TLS with.
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config, err := cr.load()
+			if err != nil {
+				log.Printf("Error reloading TLS certificates: %v", err)
+			}
+			return config, nil
+		},
+	}
+}
+
+// load returns the current configuration, reloading the files first if any of
+// them have been modified since they were last loaded. On error it returns
+// the previous configuration along with the error.
+func (cr *certReloader) load() (*tls.Config, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var modTimes [3]time.Time
+	for i, filename := range []string{cr.certFile, cr.keyFile, cr.clientCAFile} {
+		if filename == "" {
+			continue
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return cr.config, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	if cr.config != nil && modTimes == cr.modTimes {
+		return cr.config, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return cr.config, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"}, // required by gRPC, normally set by credentials.NewTLS
+	}
+	if cr.clientCAFile != "" {
+		pool, err := loadCertPool(cr.clientCAFile)
+		if err != nil {
+			return cr.config, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cr.config != nil {
+		log.Printf("Reloaded TLS certificates")
+	}
+	cr.config, cr.modTimes = config, modTimes
+	return config, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", filename)
+	}
+	return pool, nil
+}
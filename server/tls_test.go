@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "grpcdb test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+var serial int64 = 1
+
+// issue returns a PEM encoded certificate and key for commonName, valid for
+// both servers on 127.0.0.1 and clients.
+func (ca *testCA) issue(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, filename string, data []byte) {
+	err := ioutil.WriteFile(filename, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the file's modification time forward, so that it's seen as
+// modified even on filesystems with coarse timestamps.
+func touch(t *testing.T, filename string) {
+	later := time.Now().Add(time.Minute)
+	err := os.Chtimes(filename, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// principalServer returns the caller's identity as the only value in the
+// result.
+type principalServer struct{}
+
+func (principalServer) Query(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.Result, error) {
+	name := ""
+	if p := principalFromContext(ctx); p != nil {
+		name = p.String()
+	}
+	return &grpcdbpb.Result{
+		Columns: []string{"principal"},
+		Rows:    []*grpcdbpb.ResultRow{{Values: []*grpcdbpb.Value{{Value: &grpcdbpb.Value_Str{Str: name}}}}},
+	}, nil
+}
+
+func (principalServer) DescribeSchema(ctx context.Context, req *grpcdbpb.DescribeSchemaRequest) (*grpcdbpb.DatabaseSchema, error) {
+	return &grpcdbpb.DatabaseSchema{}, nil
+}
+
+func (principalServer) Translate(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.TranslatedStatement, error) {
+	return &grpcdbpb.TranslatedStatement{}, nil
+}
+
+func (principalServer) Prepare(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.PreparedHandle, error) {
+	return &grpcdbpb.PreparedHandle{}, nil
+}
+
+func (principalServer) ExecutePrepared(ctx context.Context, req *grpcdbpb.ExecutePreparedRequest) (*grpcdbpb.Result, error) {
+	return &grpcdbpb.Result{}, nil
+}
+
+func (principalServer) Explain(ctx context.Context, req *grpcdbpb.ExplainRequest) (*grpcdbpb.ExplainResult, error) {
+	return &grpcdbpb.ExplainResult{}, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	ca := newTestCA(t)
+	writeFile(t, file("ca.pem"), ca.pem)
+	serverCert, serverKey := ca.issue(t, "server")
+	writeFile(t, file("server.pem"), serverCert)
+	writeFile(t, file("server.key"), serverKey)
+	clientCert, clientKey := ca.issue(t, "orders-service")
+	writeFile(t, file("client.pem"), clientCert)
+	writeFile(t, file("client.key"), clientKey)
+
+	certs, err := newCertReloader(file("server.pem"), file("server.key"), file("ca.pem"))
+	if err != nil {
+		t.Fatalf("Unexpected error loading certificates: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(certs.tlsConfig())), grpc.UnaryInterceptor(identify))
+	grpcdbpb.RegisterGRPCDBServer(server, principalServer{})
+	go server.Serve(lis)
+	defer server.Stop()
+	target := lis.Addr().String()
+
+	t.Run("client certificate identifies caller", func(t *testing.T) {
+		c, err := client.Dial(target, client.WithTLSFiles(file("ca.pem"), file("client.pem"), file("client.key")))
+		if err != nil {
+			t.Fatalf("Couldn't dial: %v", err)
+		}
+		defer c.Close()
+		result, err := c.QueryStatement(context.Background(), &grpcdbpb.Statement{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if name := result.Rows[0].Values[0].GetStr(); name != "CN=orders-service (tls)" {
+			t.Errorf("Expected the client certificate's subject, got: %q", name)
+		}
+	})
+
+	t.Run("client without certificate is rejected", func(t *testing.T) {
+		c, err := client.Dial(target, client.WithTLSFiles(file("ca.pem"), "", ""), client.WithTimeout(5*time.Second))
+		if err != nil {
+			t.Fatalf("Couldn't dial: %v", err)
+		}
+		defer c.Close()
+		_, err = c.QueryStatement(context.Background(), &grpcdbpb.Statement{})
+		if err == nil {
+			t.Errorf("Expected an error without a client certificate")
+		}
+	})
+
+	t.Run("certificates are reloaded", func(t *testing.T) {
+		serverCert, serverKey := ca.issue(t, "rotated")
+		writeFile(t, file("server.pem"), serverCert)
+		writeFile(t, file("server.key"), serverKey)
+		touch(t, file("server.pem"))
+		touch(t, file("server.key"))
+		cert, err := tls.LoadX509KeyPair(file("client.pem"), file("client.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca.pem)
+		conn, err := tls.Dial("tcp", target, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			t.Fatalf("Couldn't connect: %v", err)
+		}
+		defer conn.Close()
+		if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "rotated" {
+			t.Errorf("Expected the rotated certificate, got: %s", cn)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb"
+	"github.com/GeorgeBills/grpcdb/api"
+	"log"
+)
+
+// Translate returns the SQL the server would run for a statement, after the
+// same checks and rewriting as Query, without running it.
+func (h *handler) Translate(ctx context.Context, statement *grpcdbpb.Statement) (*grpcdbpb.TranslatedStatement, error) {
+	logStatement(ctx, "translate", statement)
+	p, err := h.prepare(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	ts := &grpcdbpb.TranslatedStatement{
+		Sql:       p.sql,
+		Statement: p.statement,
+	}
+	// parameters needn't be bound to translate a statement, but if they are
+	// they must be valid
+	if len(statement.Parameters) > 0 {
+		_, err = bindStatementParams(statement, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range p.params {
+			ts.Parameters = append(ts.Parameters, statement.Parameters[param.Name])
+		}
+	}
+	for _, ta := range grpcdb.Accesses(p.statement, p.schema) {
+		ts.Tables = append(ts.Tables, &grpcdbpb.TableAccess{
+			Schema:  ta.Schema,
+			Table:   ta.Table,
+			Columns: ta.Columns,
+		})
+	}
+	for _, pred := range p.predicates {
+		sql, err := grpcdb.TranslateExpr(pred.Expr)
+		if err != nil {
+			log.Printf("Error translating predicate: %v", err)
+			return nil, err
+		}
+		ts.Predicates = append(ts.Predicates, &grpcdbpb.InjectedPredicate{
+			Table: pred.Table,
+			Expr:  pred.Expr,
+			Sql:   sql,
+		})
+	}
+	return ts, nil
+}
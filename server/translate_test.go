@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTranslate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writeFile(t, filename, []byte(`{
+		"principals": {"a": [{"table": "orders", "privileges": ["select"], "where": "tenant_id = claims.tenant"}]},
+		"limits": {"*": {"max_rows": 10}}
+	}`))
+	pf, err := newPolicyFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	schema := &grpcdbpb.DatabaseSchema{Schemas: []*grpcdbpb.Schema{{
+		Name: "public",
+		Tables: []*grpcdbpb.Table{{
+			Name: "orders",
+			Columns: []*grpcdbpb.Column{
+				{Name: "id", Type: "integer"},
+				{Name: "total", Type: "numeric"},
+				{Name: "tenant_id", Type: "text"},
+			},
+		}},
+	}}}
+	h := &handler{
+		policy: pf,
+		schema: &schemaCache{schema: schema, loaded: time.Now(), maxAge: time.Hour},
+	}
+	ctx := contextWithPrincipal(context.Background(), &principal{name: "a", source: "test", claims: map[string]string{"tenant": "42"}})
+	ts, err := h.Translate(ctx, query.MustParse("select id, total from orders where total > 10"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedSQL := "SELECT id, total FROM orders WHERE total > 10 AND orders.tenant_id = '42' LIMIT 10"
+	if ts.Sql != expectedSQL {
+		t.Errorf("Expected: %s\nActual: %s", expectedSQL, ts.Sql)
+	}
+	if actual := query.Format(ts.Statement); actual != "SELECT id, total FROM orders WHERE total > 10 AND orders.tenant_id = '42' LIMIT 10" {
+		t.Errorf("Unexpected rewritten statement: %s", actual)
+	}
+	expectedTables := []*grpcdbpb.TableAccess{{Schema: "public", Table: "orders", Columns: []string{"id", "total", "tenant_id"}}}
+	if !reflect.DeepEqual(ts.Tables, expectedTables) {
+		t.Errorf("Expected: %v\nActual: %v", expectedTables, ts.Tables)
+	}
+	if len(ts.Predicates) != 1 || ts.Predicates[0].Table != "orders" || ts.Predicates[0].Sql != "orders.tenant_id = '42'" {
+		t.Errorf("Unexpected predicates: %v", ts.Predicates)
+	}
+
+	// claims compared with an integer column are bound as integers
+	schema.Schemas[0].Tables[0].Columns[2].Type = "bigint"
+	ts, err = h.Translate(ctx, query.MustParse("select id from orders"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedSQL = "SELECT id FROM orders WHERE orders.tenant_id = 42 LIMIT 10"
+	if ts.Sql != expectedSQL {
+		t.Errorf("Expected: %s\nActual: %s", expectedSQL, ts.Sql)
+	}
+}
@@ -1,18 +1,26 @@
 package grpcdb
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/GeorgeBills/grpcdb/query"
+	"github.com/golang/protobuf/ptypes"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/common.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/delete.proto
+//go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/error.proto
+//go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/explain.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/expression.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/grpcdb.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/insert.proto
+//go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/schema.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/select.proto
 //go:generate protoc -I api/ --go_out=plugins=grpc:api/ api/update.proto
 
@@ -22,12 +30,22 @@ type invalidStatementError struct {
 }
 
 func (ise *invalidStatementError) Error() string {
-	return fmt.Sprintf("Error translating statement %+v: %v", ise.context, ise.wrapped)
+	return fmt.Sprintf("Error translating statement %s: %v", query.Format(ise.context), ise.wrapped)
 }
 
 // TranslateStatement takes a grpcdb.Statement and returns SQL.
 func TranslateStatement(s *pb.Statement) (string, error) {
-	sb := &strings.Builder{}
+	sql, _, err := TranslateWithParams(s)
+	return sql, err
+}
+
+// TranslateWithParams takes a grpcdb.Statement and returns SQL, along with its
+// parameters. Parameters are written as the placeholders $1, $2 and so on,
+// numbered in order of their first use, and are returned in the same order; a
+// parameter used more than once reuses its placeholder, and has the type
+// declared by any of its uses.
+func TranslateWithParams(s *pb.Statement) (string, []*pb.Param, error) {
+	sb := &sqlBuilder{}
 	var err error
 	switch s.Statement.(type) {
 	case *pb.Statement_Select:
@@ -42,15 +60,50 @@ func TranslateStatement(s *pb.Statement) (string, error) {
 		err = fmt.Errorf("Unrecognized statement type: %T", s.Statement)
 	}
 	if err != nil {
-		return "", &invalidStatementError{
+		return "", nil, &invalidStatementError{
 			context: s,
 			wrapped: err,
 		}
 	}
+	return sb.String(), sb.params, nil
+}
+
+// sqlBuilder builds SQL, numbering the parameters it contains.
+type sqlBuilder struct {
+	strings.Builder
+	params []*pb.Param
+}
+
+// param writes the placeholder for the parameter.
+func (sb *sqlBuilder) param(param *pb.Param) {
+	for i, p := range sb.params {
+		if p.Name == param.Name {
+			if p.Type == pb.ParamType_ANY {
+				p.Type = param.Type
+			}
+			sb.WriteString("$" + strconv.Itoa(i+1))
+			return
+		}
+	}
+	// copy the parameter, as its type may be filled in by a later use
+	sb.params = append(sb.params, &pb.Param{Name: param.Name, Type: param.Type})
+	sb.WriteString("$" + strconv.Itoa(len(sb.params)))
+}
+
+// TranslateExpr takes a single expression and returns SQL. Parameters are
+// numbered as in TranslateWithParams.
+func TranslateExpr(e *pb.Expr) (string, error) {
+	sb := &sqlBuilder{}
+	err := translateExpr(sb, e)
+	if err != nil {
+		return "", fmt.Errorf("Error translating expression %s: %v", query.FormatExpr(e), err)
+	}
 	return sb.String(), nil
 }
 
-func translateSelectStatement(sb *strings.Builder, sel *pb.Select) error {
+func translateSelectStatement(sb *sqlBuilder, sel *pb.Select) error {
+	// clauses are written in the order the select-stmt grammar requires,
+	// regardless of the order they were added in the builder
 	sb.WriteString("SELECT ")
 	sb.WriteString(strings.Join(sel.ResultColumn, ", ") + " ")
 	sb.WriteString("FROM " + sel.From)
@@ -67,12 +120,6 @@ func translateSelectStatement(sb *strings.Builder, sel *pb.Select) error {
 			return err
 		}
 	}
-	for _, orderBy := range sel.OrderBy {
-		err := translateOrderBy(sb, orderBy)
-		if err != nil {
-			return err
-		}
-	}
 	if len(sel.GroupBy) > 0 {
 		sb.WriteString(" GROUP BY ")
 		lasti := len(sel.GroupBy) - 1
@@ -88,7 +135,23 @@ func translateSelectStatement(sb *strings.Builder, sel *pb.Select) error {
 	}
 	if sel.Having != nil {
 		sb.WriteString(" HAVING ")
-		translateExpr(sb, sel.Having)
+		err := translateExpr(sb, sel.Having)
+		if err != nil {
+			return err
+		}
+	}
+	if len(sel.OrderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		lasti := len(sel.OrderBy) - 1
+		for i, orderBy := range sel.OrderBy {
+			err := translateOrderingTerm(sb, orderBy)
+			if err != nil {
+				return err
+			}
+			if i != lasti {
+				sb.WriteString(", ")
+			}
+		}
 	}
 	if sel.Limit != 0 {
 		sb.WriteString(" LIMIT ")
@@ -101,7 +164,7 @@ func translateSelectStatement(sb *strings.Builder, sel *pb.Select) error {
 	return nil
 }
 
-func translateInsertStatement(sb *strings.Builder, ins *pb.Insert) error {
+func translateInsertStatement(sb *sqlBuilder, ins *pb.Insert) error {
 	switch ins.Insert {
 	case pb.InsertType_INSERT:
 		sb.WriteString("INSERT ")
@@ -109,26 +172,36 @@ func translateInsertStatement(sb *strings.Builder, ins *pb.Insert) error {
 		sb.WriteString("REPLACE ")
 	}
 	sb.WriteString("INTO ")
-	translateSchemaTable(sb, ins.Into)
+	err := translateSchemaTable(sb, ins.Into)
+	if err != nil {
+		return err
+	}
 	sb.WriteString(" (" + strings.Join(ins.Columns, ", ") + ") ")
-	var err error
+	if ins.ToInsert == nil {
+		return errors.New("values or select are required")
+	}
 	switch ins.ToInsert.Insert.(type) {
 	case *pb.ToInsert_Values:
 		err = translateInsertValues(sb, ins.ToInsert.GetValues())
 	case *pb.ToInsert_Select:
 		err = translateSelectStatement(sb, ins.ToInsert.GetSelect())
+	default:
+		err = fmt.Errorf("Unrecognized insert type: %T", ins.ToInsert.Insert)
 	}
 	return err
 }
 
-func translateInsertValues(sb *strings.Builder, vals *pb.Values) error {
+func translateInsertValues(sb *sqlBuilder, vals *pb.Values) error {
 	sb.WriteString("VALUES ")
 	lasti := len(vals.Rows) - 1
 	for i, r := range vals.Rows {
 		sb.WriteString("(")
 		lastj := len(r.Values) - 1
 		for j, v := range r.Values {
-			translateExpr(sb, v)
+			err := translateExpr(sb, v)
+			if err != nil {
+				return err
+			}
 			if j != lastj {
 				sb.WriteString(", ")
 			}
@@ -141,12 +214,15 @@ func translateInsertValues(sb *strings.Builder, vals *pb.Values) error {
 	return nil
 }
 
-func translateDeleteStatement(sb *strings.Builder, del *pb.Delete) error {
+func translateDeleteStatement(sb *sqlBuilder, del *pb.Delete) error {
 	sb.WriteString("DELETE FROM ")
-	translateSchemaTable(sb, del.From)
+	err := translateSchemaTable(sb, del.From)
+	if err != nil {
+		return err
+	}
 	if del.Where != nil {
 		sb.WriteString(" WHERE ")
-		err := translateExpr(sb, del.Where)
+		err = translateExpr(sb, del.Where)
 		if err != nil {
 			return err
 		}
@@ -154,15 +230,21 @@ func translateDeleteStatement(sb *strings.Builder, del *pb.Delete) error {
 	return nil
 }
 
-func translateUpdateStatement(sb *strings.Builder, upd *pb.Update) error {
+func translateUpdateStatement(sb *sqlBuilder, upd *pb.Update) error {
 	sb.WriteString("UPDATE ")
-	translateSchemaTable(sb, upd.Table)
+	err := translateSchemaTable(sb, upd.Table)
+	if err != nil {
+		return err
+	}
+	if len(upd.Set) == 0 {
+		return errors.New("at least one column must be set")
+	}
 	sb.WriteString(" SET ")
 	lasti := len(upd.Set) - 1
 	for i, set := range upd.Set {
 		sb.WriteString(set.Column)
 		sb.WriteString(" = ")
-		err := translateExpr(sb, set.To)
+		err = translateExpr(sb, set.To)
 		if err != nil {
 			return err
 		}
@@ -172,7 +254,7 @@ func translateUpdateStatement(sb *strings.Builder, upd *pb.Update) error {
 	}
 	if upd.Where != nil {
 		sb.WriteString(" WHERE ")
-		err := translateExpr(sb, upd.Where)
+		err = translateExpr(sb, upd.Where)
 		if err != nil {
 			return err
 		}
@@ -180,14 +262,19 @@ func translateUpdateStatement(sb *strings.Builder, upd *pb.Update) error {
 	return nil
 }
 
-func translateSchemaTable(sb *strings.Builder, table *pb.SchemaTable) {
+func translateSchemaTable(sb *sqlBuilder, table *pb.SchemaTable) error {
+	if table == nil {
+		return errors.New("table is required")
+	}
 	if table.Schema != "" {
-		sb.WriteString(table.Schema)
+		sb.WriteString(table.Schema + ".")
 	}
 	sb.WriteString(table.Table)
+	return nil
 }
 
-func translateJoin(sb *strings.Builder, j *pb.Join) error {
+func translateJoin(sb *sqlBuilder, j *pb.Join) error {
+	sb.WriteString(" ")
 	if j.Natural {
 		sb.WriteString("NATURAL ")
 	}
@@ -207,15 +294,13 @@ func translateJoin(sb *strings.Builder, j *pb.Join) error {
 			return fmt.Errorf("Unrecognized join type: %d", j.JoinType)
 		}
 	}
-	sb.WriteString(" JOIN ")
+	sb.WriteString("JOIN ")
 	sb.WriteString(j.Table)
 	sb.WriteString(" ON ")
-	translateExpr(sb, j.On)
-	return nil
+	return translateExpr(sb, j.On)
 }
 
-func translateOrderBy(sb *strings.Builder, e *pb.OrderingTerm) error {
-	sb.WriteString(" ORDER BY ")
+func translateOrderingTerm(sb *sqlBuilder, e *pb.OrderingTerm) error {
 	err := translateExpr(sb, e.By)
 	if err != nil {
 		return err
@@ -231,7 +316,7 @@ func translateOrderBy(sb *strings.Builder, e *pb.OrderingTerm) error {
 	return nil
 }
 
-func translateExpr(sb *strings.Builder, e *pb.Expr) error {
+func translateExpr(sb *sqlBuilder, e *pb.Expr) error {
 	if e == nil {
 		return errors.New("expression was nil")
 	}
@@ -245,29 +330,90 @@ func translateExpr(sb *strings.Builder, e *pb.Expr) error {
 		err = translateExprUnaryExpr(sb, e.GetUnaryExpr())
 	case *pb.Expr_BinaryExpr:
 		err = translateExprBinaryExpr(sb, e.GetBinaryExpr())
+	case *pb.Expr_Param:
+		sb.param(e.GetParam())
 	default:
 		err = fmt.Errorf("Unrecognized expression type: %T", e.Expr)
 	}
 	return err
 }
 
-func translateExprLit(sb *strings.Builder, lit *pb.Lit) error {
-	switch lit.Lit.(type) {
+// translateExprLit writes a literal. Values without a literal syntax of their
+// own are written as strings cast to the PostgreSQL type, so that the database
+// reads them with the right type rather than as text.
+func translateExprLit(sb *sqlBuilder, lit *pb.Lit) error {
+	switch l := lit.Lit.(type) {
 	case *pb.Lit_Str:
-		sb.WriteString(lit.GetStr())
+		sb.WriteString(quote(l.Str))
 	case *pb.Lit_Num:
-		sb.WriteString(strconv.FormatFloat(lit.GetNum(), 'f', -1, 64))
+		if math.IsNaN(l.Num) || math.IsInf(l.Num, 0) {
+			return fmt.Errorf("Invalid number: %v", l.Num)
+		}
+		sb.WriteString(strconv.FormatFloat(l.Num, 'f', -1, 64))
+	case *pb.Lit_Int:
+		sb.WriteString(strconv.FormatInt(l.Int, 10))
 	case *pb.Lit_Boolean:
-		sb.WriteString(strconv.FormatBool(lit.GetBoolean()))
+		sb.WriteString(strconv.FormatBool(l.Boolean))
 	case *pb.Lit_Null:
 		sb.WriteString("NULL")
+	case *pb.Lit_Blob:
+		// PostgreSQL's X'' is a bit string, so write bytea's hex format
+		sb.WriteString(`CAST('\x` + hex.EncodeToString(l.Blob) + `' AS bytea)`)
+	case *pb.Lit_CurrentTime:
+		sb.WriteString("CURRENT_TIME")
+	case *pb.Lit_CurrentDate:
+		sb.WriteString("CURRENT_DATE")
+	case *pb.Lit_CurrentTimestamp:
+		sb.WriteString("CURRENT_TIMESTAMP")
+	case *pb.Lit_Timestamp:
+		t, err := ptypes.Timestamp(l.Timestamp)
+		if err != nil {
+			return err
+		}
+		sb.WriteString("CAST(" + quote(t.Format(time.RFC3339Nano)) + " AS timestamptz)")
+	case *pb.Lit_Date:
+		if l.Date == nil {
+			return fmt.Errorf("date is required in %T", lit)
+		}
+		sb.WriteString(fmt.Sprintf("CAST('%04d-%02d-%02d' AS date)", l.Date.Year, l.Date.Month, l.Date.Day))
+	case *pb.Lit_Uuid:
+		sb.WriteString("CAST(" + quote(l.Uuid) + " AS uuid)")
+	case *pb.Lit_Decimal:
+		// written as is, so it's checked here as well as by Validate, since
+		// statements may be translated without being validated
+		if !decimalPattern.MatchString(l.Decimal) {
+			return fmt.Errorf("Invalid decimal: %q", l.Decimal)
+		}
+		sb.WriteString(l.Decimal)
+	case *pb.Lit_Json:
+		sb.WriteString("CAST(" + quote(l.Json) + " AS jsonb)")
+	case *pb.Lit_Array:
+		sb.WriteString("ARRAY[")
+		for i, e := range l.Array.GetElements() {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if e == nil {
+				return fmt.Errorf("array element is required in %T", lit)
+			}
+			err := translateExprLit(sb, e)
+			if err != nil {
+				return err
+			}
+		}
+		sb.WriteString("]")
 	default:
 		return fmt.Errorf("Unsupported literal type: %T", lit.Lit)
 	}
 	return nil
 }
 
-func translateExprCol(sb *strings.Builder, col *pb.Col) error {
+// quote returns s as a string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func translateExprCol(sb *sqlBuilder, col *pb.Col) error {
 	if col.Schema != "" {
 		sb.WriteString(col.Schema + ".")
 	}
@@ -281,10 +427,78 @@ func translateExprCol(sb *strings.Builder, col *pb.Col) error {
 	return nil
 }
 
-func translateExprUnaryExpr(sb *strings.Builder, ue *pb.UnaryExpr) error {
+// Operator precedence, from loosest to tightest binding.
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precUnary
+	precPrimary
+)
+
+func precedence(e *pb.Expr) int {
+	switch e.GetExpr().(type) {
+	case *pb.Expr_BinaryExpr:
+		switch e.GetBinaryExpr().Op {
+		case pb.BinaryOp_OR:
+			return precOr
+		case pb.BinaryOp_AND:
+			return precAnd
+		}
+		return precCompare
+	case *pb.Expr_UnaryExpr:
+		if e.GetUnaryExpr().Op == pb.UnaryOp_NOT {
+			return precNot
+		}
+		return precUnary
+	case *pb.Expr_Lit:
+		// a negative number is written with a leading minus sign, so it must
+		// be parenthesised after a unary minus, as -- starts a comment
+		if negative(e.GetLit()) {
+			return precUnary
+		}
+	}
+	return precPrimary
+}
+
+// negative returns true if the literal is written with a leading minus sign.
+func negative(lit *pb.Lit) bool {
+	switch l := lit.GetLit().(type) {
+	case *pb.Lit_Num:
+		return math.Signbit(l.Num)
+	case *pb.Lit_Int:
+		return l.Int < 0
+	case *pb.Lit_Decimal:
+		return strings.HasPrefix(l.Decimal, "-")
+	}
+	return false
+}
+
+// translateOperand writes an operand of an operator with precedence prec,
+// parenthesising it if required to keep the structure of the expression tree.
+// AND and OR are associative, but comparisons aren't, so an operand with the
+// same precedence as a comparison is parenthesised.
+func translateOperand(sb *sqlBuilder, e *pb.Expr, prec int) error {
+	p := precedence(e)
+	if p > prec || (p == prec && prec < precCompare) {
+		return translateExpr(sb, e)
+	}
+	sb.WriteString("(")
+	err := translateExpr(sb, e)
+	if err != nil {
+		return err
+	}
+	sb.WriteString(")")
+	return nil
+}
+
+func translateExprUnaryExpr(sb *sqlBuilder, ue *pb.UnaryExpr) error {
+	prec := precUnary
 	switch ue.Op {
 	case pb.UnaryOp_NOT:
 		sb.WriteString("NOT ")
+		prec = precNot
 	case pb.UnaryOp_POS:
 		sb.WriteString("+")
 	case pb.UnaryOp_NEG:
@@ -292,11 +506,12 @@ func translateExprUnaryExpr(sb *strings.Builder, ue *pb.UnaryExpr) error {
 	default:
 		return fmt.Errorf("Unrecognized unary op: %d", ue.Op)
 	}
-	return translateExpr(sb, ue.Expr)
+	return translateOperand(sb, ue.Expr, prec)
 }
 
-func translateExprBinaryExpr(sb *strings.Builder, be *pb.BinaryExpr) error {
-	err := translateExpr(sb, be.Expr1)
+func translateExprBinaryExpr(sb *sqlBuilder, be *pb.BinaryExpr) error {
+	prec := precedence(&pb.Expr{Expr: &pb.Expr_BinaryExpr{BinaryExpr: be}})
+	err := translateOperand(sb, be.Expr1, prec)
 	if err != nil {
 		return err
 	}
@@ -324,9 +539,5 @@ func translateExprBinaryExpr(sb *strings.Builder, be *pb.BinaryExpr) error {
 	default:
 		return fmt.Errorf("Unrecognized binary op: %d", be.Op)
 	}
-	err = translateExpr(sb, be.Expr2)
-	if err != nil {
-		return err
-	}
-	return nil
+	return translateOperand(sb, be.Expr2, prec)
 }
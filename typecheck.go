@@ -0,0 +1,618 @@
+package grpcdb
+
+import (
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sqlType is the broad category of an expression's type. It's deliberately
+// coarse: we only want to catch statements which are obviously wrong, like
+// comparing a varchar to a number, and leave anything subtler to the database.
+type sqlType int
+
+const (
+	typeAny      sqlType = iota // type couldn't be determined; compatible with everything
+	typeNull                    // the NULL literal; compatible with everything
+	typeString                  // a string literal; compatible with anything written as a string
+	typeText                    // char, varchar, text
+	typeNumeric                 // integers, decimals and floats
+	typeBoolean                 // boolean
+	typeTemporal                // dates, times and timestamps
+	typeUUID                    // uuid
+	typeBinary                  // bytea, blob
+	typeJSON                    // json, jsonb
+	typeEnum                    // a user defined enum
+)
+
+var sqlTypeNames = []string{
+	typeAny:      "any",
+	typeNull:     "null",
+	typeString:   "string",
+	typeText:     "text",
+	typeNumeric:  "numeric",
+	typeBoolean:  "boolean",
+	typeTemporal: "temporal",
+	typeUUID:     "uuid",
+	typeBinary:   "binary",
+	typeJSON:     "json",
+	typeEnum:     "enum",
+}
+
+func (t sqlType) String() string {
+	return sqlTypeNames[t]
+}
+
+// compatible returns true if values of the two types may be compared or
+// assigned to each other.
+func compatible(t1, t2 sqlType) bool {
+	if t1 == t2 || t1 == typeAny || t2 == typeAny || t1 == typeNull || t2 == typeNull {
+		return true
+	}
+	if t2 == typeString {
+		t1, t2 = t2, t1
+	}
+	if t1 == typeString {
+		switch t2 {
+		case typeText, typeTemporal, typeUUID, typeBinary, typeJSON, typeEnum:
+			return true
+		}
+	}
+	return false
+}
+
+// Kind is the kind of value a column holds, as given by ColumnKind.
+type Kind int
+
+const (
+	KindOther     Kind = iota // unrecognised; treated as text by clients
+	KindText                  // char, varchar, text
+	KindInteger               // integers, by name; see integerTypes
+	KindDecimal               // numeric and decimal, which are exact
+	KindFloat                 // real, float, double precision
+	KindBoolean               // boolean
+	KindDate                  // date
+	KindTimestamp             // timestamps, with or without a time zone
+	KindTimeOfDay             // time and timetz, which results send as strings
+	KindUUID                  // uuid
+	KindJSON                  // json, jsonb
+	KindBinary                // bytea, blob
+	KindEnum                  // a user defined enum in the schema
+)
+
+// ColumnKind maps a column's declared type onto the kind of value it holds.
+// Both PostgreSQL's information_schema names ("character varying") and
+// SQLite's declared types ("VARCHAR") are recognised, the latter loosely
+// following SQLite's affinity rules.
+func ColumnKind(schema *pb.Schema, declared string) Kind {
+	for _, e := range schema.GetEnums() {
+		if e.Name == declared {
+			return KindEnum
+		}
+	}
+	t := strings.ToLower(declared)
+	switch {
+	case t == "uuid":
+		return KindUUID
+	case strings.HasPrefix(t, "json"):
+		return KindJSON
+	case strings.HasPrefix(t, "bool"):
+		return KindBoolean
+	case isInteger(t):
+		return KindInteger
+	case strings.Contains(t, "numeric"),
+		strings.Contains(t, "decimal"):
+		return KindDecimal
+	case strings.Contains(t, "real"),
+		strings.Contains(t, "floa"),
+		strings.Contains(t, "doub"):
+		return KindFloat
+	case strings.Contains(t, "char"),
+		strings.Contains(t, "text"),
+		strings.Contains(t, "clob"):
+		return KindText
+	case t == "date":
+		return KindDate
+	case strings.HasPrefix(t, "timestamp"),
+		strings.Contains(t, "date"):
+		return KindTimestamp
+	case strings.HasPrefix(t, "time"):
+		return KindTimeOfDay
+	case t == "bytea", strings.Contains(t, "blob"):
+		return KindBinary
+	}
+	return KindOther
+}
+
+// columnType maps a column's declared type onto a sqlType.
+func columnType(schema *pb.Schema, declared string) sqlType {
+	switch ColumnKind(schema, declared) {
+	case KindText:
+		return typeText
+	case KindInteger, KindDecimal, KindFloat:
+		return typeNumeric
+	case KindBoolean:
+		return typeBoolean
+	case KindDate, KindTimestamp, KindTimeOfDay:
+		return typeTemporal
+	case KindUUID:
+		return typeUUID
+	case KindJSON:
+		return typeJSON
+	case KindBinary:
+		return typeBinary
+	case KindEnum:
+		return typeEnum
+	}
+	return typeAny
+}
+
+// integerTypes are the names of integer types. SQLite gives any type containing
+// "INT" integer affinity, but so many PostgreSQL types contain it (interval,
+// point) that only these names are recognised.
+var integerTypes = map[string]bool{
+	"int": true, "integer": true, "tinyint": true, "smallint": true,
+	"mediumint": true, "bigint": true, "unsigned big int": true, "int2": true,
+	"int4": true, "int8": true, "smallserial": true, "serial": true,
+	"bigserial": true, "serial2": true, "serial4": true, "serial8": true,
+}
+
+// isInteger returns true if the declared type is an integer type, ignoring
+// case and any size, as in INT(11).
+func isInteger(declared string) bool {
+	t := strings.ToLower(declared)
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	return integerTypes[strings.TrimSpace(t)]
+}
+
+// Literal returns text as a literal of the type of a column, so that it type
+// checks against the column: an integer for integer columns, a decimal for
+// other numeric columns, a boolean for boolean columns, and a string for any
+// other column or one which can't be found. The table is given as "table" or
+// "schema.table". It returns an error if text isn't a valid number or boolean
+// for a numeric or boolean column.
+func Literal(schema *pb.DatabaseSchema, table, column, text string) (*pb.Lit, error) {
+	tc := &typeChecker{schema: schema}
+	st := tc.qualifiedTable("", table)
+	if st == nil {
+		return &pb.Lit{Lit: &pb.Lit_Str{Str: text}}, nil
+	}
+	col := st.column(column)
+	if col == nil {
+		return &pb.Lit{Lit: &pb.Lit_Str{Str: text}}, nil
+	}
+	switch ColumnKind(st.schema, col.Type) {
+	case KindInteger:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't an integer", text)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Int{Int: i}}, nil
+	case KindDecimal, KindFloat:
+		if !decimalPattern.MatchString(text) {
+			return nil, fmt.Errorf("%q isn't a number", text)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Decimal{Decimal: text}}, nil
+	case KindBoolean:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a boolean", text)
+		}
+		return &pb.Lit{Lit: &pb.Lit_Boolean{Boolean: b}}, nil
+	}
+	return &pb.Lit{Lit: &pb.Lit_Str{Str: text}}, nil
+}
+
+// TypeCheck checks the statement against the given schema, resolving every
+// column to a column in the schema and checking that operators are applied
+// to operands of compatible types. It returns a *ValidationError listing every
+// problem found, or nil if the statement is well typed. The statement should
+// already have been checked with Validate.
+func TypeCheck(s *pb.Statement, schema *pb.DatabaseSchema) error {
+	tc := &typeChecker{schema: schema}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		tc.selectStatement("select", s.GetSelect())
+	case *pb.Statement_Insert:
+		tc.insertStatement("insert", s.GetInsert())
+	case *pb.Statement_Delete:
+		tc.deleteStatement("delete", s.GetDelete())
+	case *pb.Statement_Update:
+		tc.updateStatement("update", s.GetUpdate())
+	}
+	if len(tc.violations) > 0 {
+		return &ValidationError{Violations: tc.violations}
+	}
+	return nil
+}
+
+// TableAccess is a table a statement uses, and the columns of it that the
+// statement reads or writes.
+type TableAccess struct {
+	Schema  string
+	Table   string
+	Columns []string
+}
+
+// Accesses returns the tables the statement uses and the columns of each, in
+// the order they first appear. Columns used by result columns which are
+// expressions, such as count(*), aren't known so aren't listed. Tables and
+// columns which can't be resolved against the schema are left out; use
+// TypeCheck to find them.
+func Accesses(s *pb.Statement, schema *pb.DatabaseSchema) []*TableAccess {
+	tc := &typeChecker{schema: schema, accesses: make(map[*pb.Table]*tableAccess)}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		tc.selectStatement("select", s.GetSelect())
+	case *pb.Statement_Insert:
+		tc.insertStatement("insert", s.GetInsert())
+	case *pb.Statement_Delete:
+		tc.deleteStatement("delete", s.GetDelete())
+	case *pb.Statement_Update:
+		tc.updateStatement("update", s.GetUpdate())
+	}
+	accesses := make([]*TableAccess, len(tc.accessOrder))
+	for i, ta := range tc.accessOrder {
+		accesses[i] = &ta.TableAccess
+	}
+	return accesses
+}
+
+type tableAccess struct {
+	TableAccess
+	seen map[string]bool
+}
+
+type typeChecker struct {
+	validator
+	schema *pb.DatabaseSchema
+	// accesses records the tables and columns used, if it's not nil
+	accesses    map[*pb.Table]*tableAccess
+	accessOrder []*tableAccess
+}
+
+// touch records the use of a table and, unless it's empty, one of its columns.
+func (tc *typeChecker) touch(st *scopeTable, column string) {
+	if tc.accesses == nil || st == nil {
+		return
+	}
+	ta, ok := tc.accesses[st.table]
+	if !ok {
+		ta = &tableAccess{TableAccess: TableAccess{Schema: st.schema.Name, Table: st.table.Name}, seen: make(map[string]bool)}
+		tc.accesses[st.table] = ta
+		tc.accessOrder = append(tc.accessOrder, ta)
+	}
+	if column != "" && !ta.seen[column] {
+		ta.seen[column] = true
+		ta.Columns = append(ta.Columns, column)
+	}
+}
+
+// scopeTable is a table which columns in an expression may refer to. A nil
+// *scopeTable in a scope stands for a table which couldn't be resolved.
+type scopeTable struct {
+	schema *pb.Schema
+	table  *pb.Table
+}
+
+func (st *scopeTable) column(name string) *pb.Column {
+	for _, col := range st.table.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// table finds a table by name. If schema is empty then the table must exist in
+// exactly one schema.
+func (tc *typeChecker) table(path, schema, table string) *scopeTable {
+	var found []*scopeTable
+	for _, s := range tc.schema.Schemas {
+		if schema != "" && s.Name != schema {
+			continue
+		}
+		for _, t := range s.Tables {
+			if t.Name == table {
+				found = append(found, &scopeTable{s, t})
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		if schema != "" {
+			table = schema + "." + table
+		}
+		tc.add(path, "unknown table: %s", table)
+		return nil
+	case 1:
+		tc.touch(found[0], "")
+		return found[0]
+	default:
+		tc.add(path, "ambiguous table: %s exists in more than one schema", table)
+		return nil
+	}
+}
+
+func (tc *typeChecker) schemaTable(path string, st *pb.SchemaTable) *scopeTable {
+	return tc.table(path, st.Schema, st.Table)
+}
+
+// qualifiedTable finds a table given as either "table" or "schema.table".
+func (tc *typeChecker) qualifiedTable(path, name string) *scopeTable {
+	m := identifier.FindStringSubmatch(name)
+	if m == nil {
+		tc.add(path, "invalid table name: %s", name)
+		return nil
+	}
+	return tc.table(path, m[1], m[2])
+}
+
+// identifier matches result columns which are plain or qualified column names,
+// as opposed to *, t.* or expressions, which we don't attempt to check.
+var identifier = regexp.MustCompile(`^(?:([A-Za-z_][A-Za-z0-9_]*)\.)?([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// wildcard matches the result columns * and table.*
+var wildcard = regexp.MustCompile(`^\s*(?:([A-Za-z_][A-Za-z0-9_]*)\.)?\*\s*$`)
+
+func (tc *typeChecker) selectStatement(path string, sel *pb.Select) {
+	scope := []*scopeTable{tc.qualifiedTable(path+".from", sel.From)}
+	for i, join := range sel.Join {
+		scope = append(scope, tc.qualifiedTable(index(path+".join", i)+".table", join.Table))
+	}
+	for i, rc := range sel.ResultColumn {
+		if m := wildcard.FindStringSubmatch(rc); m != nil {
+			for _, st := range scope {
+				if st != nil && (m[1] == "" || m[1] == st.table.Name) {
+					for _, col := range st.table.Columns {
+						tc.touch(st, col.Name)
+					}
+				}
+			}
+			continue
+		}
+		m := identifier.FindStringSubmatch(rc)
+		if m == nil {
+			continue
+		}
+		tc.col(index(path+".result_column", i), scope, &pb.Col{Table: m[1], Column: m[2]})
+	}
+	for i, join := range sel.Join {
+		tc.condition(index(path+".join", i)+".on", scope, join.On)
+	}
+	if sel.Where != nil {
+		tc.condition(path+".where", scope, sel.Where)
+	}
+	for i, groupBy := range sel.GroupBy {
+		tc.expr(index(path+".group_by", i), scope, groupBy)
+	}
+	if sel.Having != nil {
+		tc.condition(path+".having", scope, sel.Having)
+	}
+	for i, orderBy := range sel.OrderBy {
+		tc.expr(index(path+".order_by", i)+".by", scope, orderBy.By)
+	}
+}
+
+func (tc *typeChecker) insertStatement(path string, ins *pb.Insert) {
+	into := tc.schemaTable(path+".into", ins.Into)
+	types := make([]sqlType, len(ins.Columns))
+	for i, name := range ins.Columns {
+		types[i] = typeAny
+		if into == nil {
+			continue
+		}
+		col := into.column(name)
+		if col == nil {
+			tc.add(index(path+".columns", i), "unknown column %s in table %s", name, into.table.Name)
+			continue
+		}
+		tc.touch(into, name)
+		types[i] = columnType(into.schema, col.Type)
+	}
+	switch ins.ToInsert.Insert.(type) {
+	case *pb.ToInsert_Values:
+		valuesPath := path + ".to_insert.values"
+		for i, r := range ins.ToInsert.GetValues().Rows {
+			rowPath := index(valuesPath+".rows", i) + ".values"
+			if len(r.Values) != len(types) {
+				tc.add(rowPath, "row has %d values but %d columns were given", len(r.Values), len(types))
+			}
+			for j, v := range r.Values {
+				t := tc.expr(index(rowPath, j), nil, v)
+				if j < len(types) && !compatible(types[j], t) {
+					tc.add(index(rowPath, j), "can't insert %s value into %s column %s", t, types[j], ins.Columns[j])
+				}
+			}
+		}
+	case *pb.ToInsert_Select:
+		sel := ins.ToInsert.GetSelect()
+		tc.selectStatement(path+".to_insert.select", sel)
+		if !containsWildcard(sel.ResultColumn) && len(sel.ResultColumn) != len(ins.Columns) {
+			tc.add(path+".to_insert.select.result_column", "select returns %d columns but %d columns were given", len(sel.ResultColumn), len(ins.Columns))
+		}
+	}
+}
+
+func containsWildcard(columns []string) bool {
+	for _, c := range columns {
+		if c == "*" || strings.HasSuffix(c, ".*") {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *typeChecker) deleteStatement(path string, del *pb.Delete) {
+	scope := []*scopeTable{tc.schemaTable(path+".from", del.From)}
+	if del.Where != nil {
+		tc.condition(path+".where", scope, del.Where)
+	}
+}
+
+func (tc *typeChecker) updateStatement(path string, upd *pb.Update) {
+	table := tc.schemaTable(path+".table", upd.Table)
+	scope := []*scopeTable{table}
+	for i, set := range upd.Set {
+		setPath := index(path+".set", i)
+		t := tc.expr(setPath+".to", scope, set.To)
+		if table == nil {
+			continue
+		}
+		col := table.column(set.Column)
+		if col == nil {
+			tc.add(setPath+".column", "unknown column %s in table %s", set.Column, table.table.Name)
+			continue
+		}
+		tc.touch(table, set.Column)
+		colType := columnType(table.schema, col.Type)
+		if !compatible(colType, t) {
+			tc.add(setPath+".to", "can't set %s column %s to %s value", colType, set.Column, t)
+		}
+	}
+	if upd.Where != nil {
+		tc.condition(path+".where", scope, upd.Where)
+	}
+}
+
+// condition checks an expression which must evaluate to a boolean, such as a
+// WHERE clause.
+func (tc *typeChecker) condition(path string, scope []*scopeTable, e *pb.Expr) {
+	t := tc.expr(path, scope, e)
+	if !compatible(typeBoolean, t) {
+		tc.add(path, "condition must be boolean, not %s", t)
+	}
+}
+
+func (tc *typeChecker) expr(path string, scope []*scopeTable, e *pb.Expr) sqlType {
+	switch e.Expr.(type) {
+	case *pb.Expr_Lit:
+		return litType(e.GetLit())
+	case *pb.Expr_Col:
+		return tc.col(path+".col", scope, e.GetCol())
+	case *pb.Expr_UnaryExpr:
+		return tc.unaryExpr(path+".unary_expr", scope, e.GetUnaryExpr())
+	case *pb.Expr_BinaryExpr:
+		return tc.binaryExpr(path+".binary_expr", scope, e.GetBinaryExpr())
+	case *pb.Expr_Param:
+		return paramType(e.GetParam())
+	}
+	return typeAny
+}
+
+// paramType returns the type of values which may be bound to a parameter.
+func paramType(param *pb.Param) sqlType {
+	switch param.Type {
+	case pb.ParamType_STRING:
+		return typeString
+	case pb.ParamType_NUMBER, pb.ParamType_INTEGER:
+		return typeNumeric
+	case pb.ParamType_BOOLEAN:
+		return typeBoolean
+	case pb.ParamType_BYTES:
+		return typeBinary
+	case pb.ParamType_TIMESTAMP:
+		return typeTemporal
+	}
+	return typeAny
+}
+
+func litType(lit *pb.Lit) sqlType {
+	switch lit.Lit.(type) {
+	case *pb.Lit_Str:
+		return typeString
+	case *pb.Lit_Num, *pb.Lit_Int, *pb.Lit_Decimal:
+		return typeNumeric
+	case *pb.Lit_Uuid:
+		return typeUUID
+	case *pb.Lit_Json:
+		return typeJSON
+	case *pb.Lit_Boolean:
+		return typeBoolean
+	case *pb.Lit_Blob:
+		return typeBinary
+	case *pb.Lit_Null:
+		return typeNull
+	case *pb.Lit_CurrentTime, *pb.Lit_CurrentDate, *pb.Lit_CurrentTimestamp, *pb.Lit_Timestamp, *pb.Lit_Date:
+		return typeTemporal
+	}
+	return typeAny
+}
+
+// col resolves a column against the tables in scope and returns its type.
+func (tc *typeChecker) col(path string, scope []*scopeTable, col *pb.Col) sqlType {
+	name := col.Column
+	if col.Table != "" {
+		name = col.Table + "." + name
+	}
+	var found []*scopeTable
+	var cols []*pb.Column
+	unresolved := false
+	for _, st := range scope {
+		if st == nil {
+			unresolved = true
+			continue
+		}
+		if col.Table != "" && st.table.Name != col.Table {
+			continue
+		}
+		if col.Schema != "" && st.schema.Name != col.Schema {
+			continue
+		}
+		if c := st.column(col.Column); c != nil {
+			found = append(found, st)
+			cols = append(cols, c)
+		}
+	}
+	switch len(found) {
+	case 0:
+		// don't pile more errors on top of an unknown table
+		if !unresolved {
+			tc.add(path, "unknown column: %s", name)
+		}
+		return typeAny
+	case 1:
+		tc.touch(found[0], cols[0].Name)
+		return columnType(found[0].schema, cols[0].Type)
+	default:
+		tc.add(path, "ambiguous column: %s", name)
+		return typeAny
+	}
+}
+
+func (tc *typeChecker) unaryExpr(path string, scope []*scopeTable, ue *pb.UnaryExpr) sqlType {
+	t := tc.expr(path+".expr", scope, ue.Expr)
+	var want sqlType
+	switch ue.Op {
+	case pb.UnaryOp_NOT:
+		want = typeBoolean
+	case pb.UnaryOp_POS, pb.UnaryOp_NEG:
+		want = typeNumeric
+	}
+	if !compatible(want, t) {
+		tc.add(path, "%s requires a %s operand, not %s", ue.Op, want, t)
+	}
+	return want
+}
+
+func (tc *typeChecker) binaryExpr(path string, scope []*scopeTable, be *pb.BinaryExpr) sqlType {
+	t1 := tc.expr(path+".expr1", scope, be.Expr1)
+	t2 := tc.expr(path+".expr2", scope, be.Expr2)
+	switch be.Op {
+	case pb.BinaryOp_AND, pb.BinaryOp_OR:
+		if !compatible(typeBoolean, t1) || !compatible(typeBoolean, t2) {
+			tc.add(path, "%s requires boolean operands, not %s and %s", be.Op, t1, t2)
+		}
+	case pb.BinaryOp_IS, pb.BinaryOp_IS_NOT:
+		// anything may be compared with IS
+	default:
+		if !compatible(t1, t2) {
+			tc.add(path, "can't compare %s with %s using %s", t1, t2, be.Op)
+		}
+	}
+	return typeBoolean
+}
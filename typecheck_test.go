@@ -0,0 +1,280 @@
+package grpcdb_test
+
+import (
+	"github.com/GeorgeBills/grpcdb"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	"github.com/golang/protobuf/proto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// schema mirrors testdata/database.sql as described by PostgreSQL.
+var schema = &pb.DatabaseSchema{
+	Schemas: []*pb.Schema{{
+		Name: "public",
+		Tables: []*pb.Table{
+			{
+				Name: "country",
+				Columns: []*pb.Column{
+					{Name: "id", Type: "uuid"},
+					{Name: "country", Type: "character varying", Nullable: true},
+					{Name: "continent", Type: "continent", Nullable: true},
+				},
+				PrimaryKey: []string{"id"},
+			},
+			{
+				Name: "person",
+				Columns: []*pb.Column{
+					{Name: "id", Type: "uuid"},
+					{Name: "full_name", Type: "character varying", Nullable: true},
+					{Name: "birth", Type: "date", Nullable: true},
+					{Name: "country_id", Type: "uuid", Nullable: true},
+				},
+				PrimaryKey: []string{"id"},
+			},
+			{
+				Name: "event",
+				Columns: []*pb.Column{
+					{Name: "id", Type: "bigint"},
+					{Name: "duration", Type: "interval", Nullable: true},
+					{Name: "location", Type: "point", Nullable: true},
+				},
+				PrimaryKey: []string{"id"},
+			},
+		},
+		Enums: []*pb.EnumType{{
+			Name:   "continent",
+			Values: []string{"Africa", "Asia", "Europe", "North America", "Oceania", "South America"},
+		}},
+	}},
+}
+
+// built is a statement built without a builder, such as one the builders
+// would refuse to build.
+type built struct {
+	statement *pb.Statement
+}
+
+func (b built) Statement() (*pb.Statement, error) {
+	return b.statement, nil
+}
+
+func TestTypeCheck(t *testing.T) {
+	table := []struct {
+		name             string
+		statementBuilder StatementBuilder
+		fields           []string
+	}{
+		{
+			"valid select",
+			Select("person", "full_name", "birth").
+				Where(GT(Col("birth"), Str("2000-01-01"))).
+				OrderBy(Col("birth"), pb.OrderingDirection_DESC),
+			nil,
+		},
+		{
+			"valid join",
+			Select("person", "full_name", "country.continent").
+				JoinEq("country", TableCol("person", "country_id"), TableCol("country", "id")).
+				Where(Eq(Col("continent"), Str("Europe"))),
+			nil,
+		},
+		{
+			"text compared with number",
+			Select("person", "full_name").
+				Where(GT(Col("full_name"), Num(3))),
+			[]string{"select.where.binary_expr"},
+		},
+		{
+			"text compared with typed parameter",
+			Select("person", "full_name").
+				Where(Or(Eq(Col("full_name"), TypedParam("name", pb.ParamType_INTEGER)), Eq(Col("id"), Param("id")))),
+			[]string{"select.where.binary_expr.expr1.binary_expr"},
+		},
+		{
+			"typed literals",
+			Select("person", "full_name").
+				Where(Eq(Col("id"), UUID("123e4567-e89b-12d3-a456-426614174000"))).
+				Where(GT(Col("birth"), Date(2000, time.January, 1))),
+			nil,
+		},
+		{
+			"typed literals of the wrong type",
+			Select("person", "full_name").
+				Where(Eq(Col("birth"), Int(2000))).
+				Where(Eq(Col("full_name"), UUID("123e4567-e89b-12d3-a456-426614174000"))),
+			[]string{"select.where.binary_expr.expr1.binary_expr", "select.where.binary_expr.expr2.binary_expr"},
+		},
+		{
+			"types containing int which aren't integers",
+			Select("event", "id").
+				Where(GT(Col("duration"), Str("1 day"))).
+				Where(Eq(Col("location"), Str("(1,2)"))),
+			nil,
+		},
+		{
+			"integer compared with string",
+			Select("event", "id").
+				Where(Eq(Col("id"), Str("1"))),
+			[]string{"select.where.binary_expr"},
+		},
+		{
+			"unknown table",
+			Select("people", "full_name"),
+			[]string{"select.from"},
+		},
+		{
+			"misspelled column",
+			Select("person", "fullname").
+				Where(Is(Col("brith"), Null())),
+			[]string{"select.result_column[0]", "select.where.binary_expr.expr1.col"},
+		},
+		{
+			"ambiguous column",
+			Select("person", "full_name").
+				JoinEq("country", TableCol("person", "country_id"), TableCol("country", "id")).
+				Where(IsNot(Col("id"), Null())),
+			[]string{"select.where.binary_expr.expr1.col"},
+		},
+		{
+			"non-boolean where",
+			Delete(Table("person")).
+				Where(Col("full_name")),
+			[]string{"delete.where"},
+		},
+		{
+			"insert",
+			Insert(Table("person"), "id", "full_name").
+				Values([]*pb.Expr{Str("a"), Str("b")}),
+			nil,
+		},
+		{
+			"insert unknown column and row width",
+			built{&pb.Statement{Statement: &pb.Statement_Insert{Insert: &pb.Insert{
+				Into:    Table("person"),
+				Columns: []string{"id", "name"},
+				ToInsert: &pb.ToInsert{Insert: &pb.ToInsert_Values{Values: &pb.Values{Rows: []*pb.Row{
+					{Values: []*pb.Expr{Str("a"), Str("b")}},
+					{Values: []*pb.Expr{Str("c")}},
+				}}}},
+			}}}},
+			[]string{"insert.columns[1]", "insert.to_insert.values.rows[1].values"},
+		},
+		{
+			"insert select column count",
+			Insert(Table("person"), "id", "full_name").
+				From(Select("country", "id")),
+			[]string{"insert.to_insert.select.result_column"},
+		},
+		{
+			"update type mismatch",
+			Update(Table("person")).
+				Set("birth", Num(1)).
+				Set("nonexistent", Null()).
+				Where(Eq(Col("id"), Str("a"))),
+			[]string{"update.set[0].to", "update.set[1].column"},
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			statement := mustStatement(t, tt.statementBuilder)
+			err := grpcdb.TypeCheck(statement, schema)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			ve, ok := err.(*grpcdb.ValidationError)
+			if !ok {
+				t.Fatalf("Expected *grpcdb.ValidationError, got %T: %v", err, err)
+			}
+			var fields []string
+			for _, v := range ve.Violations {
+				fields = append(fields, v.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Expected violations: %v\nActual: %v", tt.fields, ve.Violations)
+			}
+		})
+	}
+}
+
+func TestAccesses(t *testing.T) {
+	table := []struct {
+		name             string
+		statementBuilder StatementBuilder
+		expected         []*grpcdb.TableAccess
+	}{
+		{
+			"join",
+			Select("person", "full_name", "country.continent").
+				JoinEq("country", TableCol("person", "country_id"), TableCol("country", "id")).
+				Where(Eq(Col("continent"), Str("Europe"))),
+			[]*grpcdb.TableAccess{
+				{Schema: "public", Table: "person", Columns: []string{"full_name", "country_id"}},
+				{Schema: "public", Table: "country", Columns: []string{"continent", "id"}},
+			},
+		},
+		{
+			"wildcard",
+			Select("country", "*"),
+			[]*grpcdb.TableAccess{
+				{Schema: "public", Table: "country", Columns: []string{"id", "country", "continent"}},
+			},
+		},
+		{
+			"update",
+			Update(Table("person")).Set("full_name", Str("x")).Where(Eq(Col("id"), Str("a"))),
+			[]*grpcdb.TableAccess{
+				{Schema: "public", Table: "person", Columns: []string{"full_name", "id"}},
+			},
+		},
+		{
+			"unknown table",
+			Select("nope", "a"),
+			[]*grpcdb.TableAccess{},
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			statement, err := tt.statementBuilder.Statement()
+			if err != nil {
+				t.Fatalf("Couldn't build statement: %v", err)
+			}
+			actual := grpcdb.Accesses(statement, schema)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("Expected: %+v\nActual: %+v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	table := []struct {
+		table, column, text string
+		expected            *pb.Lit
+	}{
+		{"event", "id", "42", &pb.Lit{Lit: &pb.Lit_Int{Int: 42}}},
+		{"public.event", "duration", "1 day", &pb.Lit{Lit: &pb.Lit_Str{Str: "1 day"}}},
+		{"person", "full_name", "42", &pb.Lit{Lit: &pb.Lit_Str{Str: "42"}}},
+		{"nonexistent", "id", "42", &pb.Lit{Lit: &pb.Lit_Str{Str: "42"}}},
+	}
+	for _, tt := range table {
+		t.Run(tt.table+"."+tt.column, func(t *testing.T) {
+			actual, err := grpcdb.Literal(schema, tt.table, tt.column, tt.text)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !proto.Equal(actual, tt.expected) {
+				t.Errorf("Expected: %v\nActual: %v", tt.expected, actual)
+			}
+		})
+	}
+	_, err := grpcdb.Literal(schema, "event", "id", "1.5")
+	if err == nil || err.Error() != `"1.5" isn't an integer` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package grpcdb
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	"github.com/golang/protobuf/ptypes"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FieldViolation describes a single problem found in a statement. Field is the
+// path to the offending field using proto field names, e.g.
+// "select.join[1].on".
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError is returned by Validate and lists every problem found in the
+// statement.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := make([]string, len(ve.Violations))
+	for i, v := range ve.Violations {
+		msgs[i] = v.Field + ": " + v.Description
+	}
+	return "Invalid statement: " + strings.Join(msgs, "; ")
+}
+
+// Validate walks the whole statement and returns a *ValidationError listing
+// every problem that would prevent it from being translated, or nil if the
+// statement is valid.
+func Validate(s *pb.Statement) error {
+	v := &validator{}
+	v.statement(s)
+	if len(v.violations) > 0 {
+		return &ValidationError{Violations: v.violations}
+	}
+	return nil
+}
+
+type validator struct {
+	violations []FieldViolation
+	// paramTypes holds the type declared for each parameter, to catch
+	// parameters declared with different types
+	paramTypes map[string]pb.ParamType
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.violations = append(v.violations, FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func index(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+func (v *validator) statement(s *pb.Statement) {
+	if s == nil {
+		v.add("statement", "statement is required")
+		return
+	}
+	switch s.Statement.(type) {
+	case *pb.Statement_Select:
+		v.selectStatement("select", s.GetSelect())
+	case *pb.Statement_Insert:
+		v.insertStatement("insert", s.GetInsert())
+	case *pb.Statement_Delete:
+		v.deleteStatement("delete", s.GetDelete())
+	case *pb.Statement_Update:
+		v.updateStatement("update", s.GetUpdate())
+	default:
+		v.add("statement", "unrecognized statement type: %T", s.Statement)
+	}
+	if s.Timeout != nil {
+		d, err := ptypes.Duration(s.Timeout)
+		if err != nil {
+			v.add("timeout", "invalid duration: %v", err)
+		} else if d <= 0 {
+			v.add("timeout", "timeout must be positive")
+		}
+	}
+}
+
+func (v *validator) selectStatement(path string, sel *pb.Select) {
+	if sel == nil {
+		v.add(path, "select is required")
+		return
+	}
+	if len(sel.ResultColumn) == 0 {
+		v.add(path+".result_column", "at least one result column is required")
+	}
+	for i, rc := range sel.ResultColumn {
+		if rc == "" {
+			v.add(index(path+".result_column", i), "result column must not be empty")
+		}
+	}
+	if sel.From == "" {
+		v.add(path+".from", "table is required")
+	}
+	for i, join := range sel.Join {
+		v.join(index(path+".join", i), join)
+	}
+	if sel.Where != nil {
+		v.expr(path+".where", sel.Where)
+	}
+	for i, groupBy := range sel.GroupBy {
+		v.expr(index(path+".group_by", i), groupBy)
+	}
+	if sel.Having != nil {
+		v.expr(path+".having", sel.Having)
+	}
+	for i, orderBy := range sel.OrderBy {
+		v.orderingTerm(index(path+".order_by", i), orderBy)
+	}
+}
+
+func (v *validator) join(path string, j *pb.Join) {
+	if j == nil {
+		v.add(path, "join is required")
+		return
+	}
+	if _, ok := pb.JoinType_name[int32(j.JoinType)]; !ok {
+		v.add(path+".join_type", "unrecognized join type: %d", j.JoinType)
+	}
+	if j.Table == "" {
+		v.add(path+".table", "table is required")
+	}
+	if j.On == nil {
+		v.add(path+".on", "join constraint is required")
+		return
+	}
+	v.expr(path+".on", j.On)
+}
+
+func (v *validator) orderingTerm(path string, ot *pb.OrderingTerm) {
+	if ot == nil {
+		v.add(path, "ordering term is required")
+		return
+	}
+	if _, ok := pb.OrderingDirection_name[int32(ot.Dir)]; !ok {
+		v.add(path+".dir", "unrecognized ordering direction: %d", ot.Dir)
+	}
+	v.expr(path+".by", ot.By)
+}
+
+func (v *validator) insertStatement(path string, ins *pb.Insert) {
+	if ins == nil {
+		v.add(path, "insert is required")
+		return
+	}
+	if _, ok := pb.InsertType_name[int32(ins.Insert)]; !ok {
+		v.add(path+".insert", "unrecognized insert type: %d", ins.Insert)
+	}
+	v.schemaTable(path+".into", ins.Into)
+	if len(ins.Columns) == 0 {
+		v.add(path+".columns", "at least one column is required")
+	}
+	for i, col := range ins.Columns {
+		if col == "" {
+			v.add(index(path+".columns", i), "column must not be empty")
+		}
+	}
+	if ins.ToInsert == nil {
+		v.add(path+".to_insert", "values or select are required")
+		return
+	}
+	switch ins.ToInsert.Insert.(type) {
+	case *pb.ToInsert_Values:
+		v.values(path+".to_insert.values", ins.ToInsert.GetValues(), len(ins.Columns))
+	case *pb.ToInsert_Select:
+		v.selectStatement(path+".to_insert.select", ins.ToInsert.GetSelect())
+	default:
+		v.add(path+".to_insert", "values or select are required")
+	}
+}
+
+func (v *validator) values(path string, vals *pb.Values, ncols int) {
+	if vals == nil || len(vals.Rows) == 0 {
+		v.add(path+".rows", "at least one row is required")
+		return
+	}
+	for i, r := range vals.Rows {
+		rowPath := index(path+".rows", i)
+		if r == nil {
+			v.add(rowPath, "row is required")
+			continue
+		}
+		if len(r.Values) != ncols {
+			v.add(rowPath+".values", "row has %d values but %d columns were given", len(r.Values), ncols)
+		}
+		for j, val := range r.Values {
+			v.expr(index(rowPath+".values", j), val)
+		}
+	}
+}
+
+func (v *validator) deleteStatement(path string, del *pb.Delete) {
+	if del == nil {
+		v.add(path, "delete is required")
+		return
+	}
+	v.schemaTable(path+".from", del.From)
+	if del.Where != nil {
+		v.expr(path+".where", del.Where)
+	}
+}
+
+func (v *validator) updateStatement(path string, upd *pb.Update) {
+	if upd == nil {
+		v.add(path, "update is required")
+		return
+	}
+	if _, ok := pb.UpdateType_name[int32(upd.UpdateOr)]; !ok {
+		v.add(path+".update_or", "unrecognized update type: %d", upd.UpdateOr)
+	}
+	v.schemaTable(path+".table", upd.Table)
+	if len(upd.Set) == 0 {
+		v.add(path+".set", "at least one column must be set")
+	}
+	for i, set := range upd.Set {
+		setPath := index(path+".set", i)
+		if set == nil {
+			v.add(setPath, "set is required")
+			continue
+		}
+		if set.Column == "" {
+			v.add(setPath+".column", "column is required")
+		}
+		v.expr(setPath+".to", set.To)
+	}
+	if upd.Where != nil {
+		v.expr(path+".where", upd.Where)
+	}
+}
+
+func (v *validator) schemaTable(path string, st *pb.SchemaTable) {
+	if st == nil {
+		v.add(path, "table is required")
+		return
+	}
+	if st.Table == "" {
+		v.add(path+".table", "table is required")
+	}
+}
+
+func (v *validator) expr(path string, e *pb.Expr) {
+	if e == nil {
+		v.add(path, "expression is required")
+		return
+	}
+	switch e.Expr.(type) {
+	case *pb.Expr_Lit:
+		v.lit(path+".lit", e.GetLit())
+	case *pb.Expr_Col:
+		v.col(path+".col", e.GetCol())
+	case *pb.Expr_UnaryExpr:
+		v.unaryExpr(path+".unary_expr", e.GetUnaryExpr())
+	case *pb.Expr_BinaryExpr:
+		v.binaryExpr(path+".binary_expr", e.GetBinaryExpr())
+	case *pb.Expr_Param:
+		v.param(path+".param", e.GetParam())
+	default:
+		v.add(path, "unrecognized expression type: %T", e.Expr)
+	}
+}
+
+func (v *validator) param(path string, param *pb.Param) {
+	if param == nil {
+		v.add(path, "parameter is required")
+		return
+	}
+	if param.Name == "" {
+		v.add(path+".name", "parameter name is required")
+	}
+	if _, ok := pb.ParamType_name[int32(param.Type)]; !ok {
+		v.add(path+".type", "unrecognized parameter type: %d", param.Type)
+		return
+	}
+	if param.Type == pb.ParamType_ANY {
+		return
+	}
+	if v.paramTypes == nil {
+		v.paramTypes = make(map[string]pb.ParamType)
+	}
+	if t, ok := v.paramTypes[param.Name]; ok && t != param.Type {
+		v.add(path+".type", "parameter %s is declared as both %s and %s", param.Name, t, param.Type)
+		return
+	}
+	v.paramTypes[param.Name] = param.Type
+}
+
+var (
+	uuidPattern    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+)
+
+func (v *validator) lit(path string, lit *pb.Lit) {
+	if lit == nil || lit.Lit == nil {
+		v.add(path, "literal value is required")
+		return
+	}
+	switch l := lit.Lit.(type) {
+	case *pb.Lit_Num:
+		// SQL has no literal for these
+		if math.IsNaN(l.Num) || math.IsInf(l.Num, 0) {
+			v.add(path+".num", "invalid number: %v", l.Num)
+		}
+	case *pb.Lit_Timestamp:
+		if _, err := ptypes.Timestamp(l.Timestamp); err != nil {
+			v.add(path+".timestamp", "invalid timestamp: %v", err)
+		}
+	case *pb.Lit_Date:
+		d := l.Date
+		if d == nil {
+			v.add(path+".date", "date is required")
+			return
+		}
+		// time.Date normalises out of range days and months, e.g. February
+		// 30 becomes March 2, so a valid date is one which is unchanged
+		t := time.Date(int(d.Year), time.Month(d.Month), int(d.Day), 0, 0, 0, 0, time.UTC)
+		if d.Year < 1 || d.Year > 9999 || t.Year() != int(d.Year) || t.Month() != time.Month(d.Month) || t.Day() != int(d.Day) {
+			v.add(path+".date", "invalid date: %04d-%02d-%02d", d.Year, d.Month, d.Day)
+		}
+	case *pb.Lit_Uuid:
+		if !uuidPattern.MatchString(l.Uuid) {
+			v.add(path+".uuid", "invalid uuid: %q", l.Uuid)
+		}
+	case *pb.Lit_Decimal:
+		if !decimalPattern.MatchString(l.Decimal) {
+			v.add(path+".decimal", "invalid decimal: %q", l.Decimal)
+		}
+	case *pb.Lit_Json:
+		if !json.Valid([]byte(l.Json)) {
+			v.add(path+".json", "invalid json")
+		}
+	case *pb.Lit_Array:
+		if l.Array == nil {
+			v.add(path+".array", "array is required")
+			return
+		}
+		for i, e := range l.Array.Elements {
+			v.lit(index(path+".array.elements", i), e)
+		}
+	}
+}
+
+func (v *validator) col(path string, col *pb.Col) {
+	if col == nil {
+		v.add(path, "column is required")
+		return
+	}
+	if col.Column == "" {
+		v.add(path+".column", "column is required")
+	}
+	if col.Schema != "" && col.Table == "" {
+		v.add(path+".table", "table is required when schema is set")
+	}
+}
+
+func (v *validator) unaryExpr(path string, ue *pb.UnaryExpr) {
+	if ue == nil {
+		v.add(path, "unary expression is required")
+		return
+	}
+	if _, ok := pb.UnaryOp_name[int32(ue.Op)]; !ok || ue.Op == pb.UnaryOp_UNKNOWN_UO {
+		v.add(path+".op", "unrecognized unary op: %d", ue.Op)
+	}
+	v.expr(path+".expr", ue.Expr)
+}
+
+func (v *validator) binaryExpr(path string, be *pb.BinaryExpr) {
+	if be == nil {
+		v.add(path, "binary expression is required")
+		return
+	}
+	v.expr(path+".expr1", be.Expr1)
+	if _, ok := pb.BinaryOp_name[int32(be.Op)]; !ok || be.Op == pb.BinaryOp_UNKNOWN_BO {
+		v.add(path+".op", "unrecognized binary op: %d", be.Op)
+	}
+	v.expr(path+".expr2", be.Expr2)
+}
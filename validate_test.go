@@ -0,0 +1,177 @@
+package grpcdb_test
+
+import (
+	"github.com/GeorgeBills/grpcdb"
+	pb "github.com/GeorgeBills/grpcdb/api"
+	. "github.com/GeorgeBills/grpcdb/builder"
+	"github.com/golang/protobuf/ptypes/duration"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		name      string
+		statement *pb.Statement
+		fields    []string
+	}{
+		{
+			"valid",
+			mustStatement(t, Select("t", "a").Where(GT(Col("x"), Num(3)))),
+			nil,
+		},
+		{
+			"nil statement",
+			nil,
+			[]string{"statement"},
+		},
+		{
+			"empty statement",
+			&pb.Statement{},
+			[]string{"statement"},
+		},
+		{
+			"nil join on",
+			&pb.Statement{Statement: &pb.Statement_Select{Select: &pb.Select{
+				ResultColumn: []string{"x"},
+				From:         "t1",
+				Join: []*pb.Join{
+					{Table: "t2", On: Eq(TableCol("t1", "y"), TableCol("t2", "z"))},
+					{Table: "t3"},
+				},
+			}}},
+			[]string{"select.join[1].on"},
+		},
+		{
+			"missing column in where",
+			mustStatement(t, Select("t", "a").Where(GT(Col(""), Num(3)))),
+			[]string{"select.where.binary_expr.expr1.col.column"},
+		},
+		{
+			"parameter declared with two types",
+			mustStatement(t, Select("t", "a").Where(Or(
+				Eq(Col("x"), TypedParam("p", pb.ParamType_INTEGER)),
+				Eq(Col("y"), TypedParam("p", pb.ParamType_STRING)),
+			))),
+			[]string{"select.where.binary_expr.expr2.binary_expr.expr2.param.type"},
+		},
+		{
+			"nil parameter",
+			mustStatement(t, Select("t", "a").Where(Eq(Col("x"), &pb.Expr{Expr: &pb.Expr_Param{}}))),
+			[]string{"select.where.binary_expr.expr2.param"},
+		},
+		{
+			"valid typed literals",
+			mustStatement(t, Select("t", "a").Where(Eq(Col("x"), Array(
+				Date(2000, time.February, 29),
+				UUID("123E4567-e89b-12d3-a456-426614174000"),
+				Decimal("+.5"),
+				JSON(`[{"a": null}]`),
+			)))),
+			nil,
+		},
+		{
+			"invalid typed literals",
+			mustStatement(t, Select("t", "a").Where(Eq(Col("x"), Array(
+				Date(2001, time.February, 29),
+				UUID("123e4567e89b12d3a456426614174000"),
+				Decimal("1e5"),
+				JSON(`{"a": }`),
+				Time(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)),
+				Array(Col("y")),
+				Num(math.NaN()),
+				Num(math.Inf(-1)),
+			)))),
+			[]string{
+				"select.where.binary_expr.expr2.lit.array.elements[0].date",
+				"select.where.binary_expr.expr2.lit.array.elements[1].uuid",
+				"select.where.binary_expr.expr2.lit.array.elements[2].decimal",
+				"select.where.binary_expr.expr2.lit.array.elements[3].json",
+				"select.where.binary_expr.expr2.lit.array.elements[4].timestamp",
+				"select.where.binary_expr.expr2.lit.array.elements[5].array.elements[0]",
+				"select.where.binary_expr.expr2.lit.array.elements[6].num",
+				"select.where.binary_expr.expr2.lit.array.elements[7].num",
+			},
+		},
+		{
+			"nil insert table and values",
+			&pb.Statement{Statement: &pb.Statement_Insert{Insert: &pb.Insert{
+				Columns: []string{"x"},
+			}}},
+			[]string{"insert.into", "insert.to_insert"},
+		},
+		{
+			"insert row width",
+			&pb.Statement{Statement: &pb.Statement_Insert{Insert: &pb.Insert{
+				Into:    Table("t"),
+				Columns: []string{"x", "y"},
+				ToInsert: &pb.ToInsert{Insert: &pb.ToInsert_Values{Values: &pb.Values{Rows: []*pb.Row{
+					{Values: []*pb.Expr{Num(1), Num(2)}},
+					{Values: []*pb.Expr{Num(3)}},
+				}}}},
+			}}},
+			[]string{"insert.to_insert.values.rows[1].values"},
+		},
+		{
+			"empty update set",
+			mustStatement(t, Update(Table("t")).Where(Eq(Col("a"), Num(1)))),
+			[]string{"update.set"},
+		},
+		{
+			"multiple problems",
+			&pb.Statement{Statement: &pb.Statement_Update{Update: &pb.Update{
+				Set: []*pb.Set{{Column: "a"}},
+				Where: &pb.Expr{Expr: &pb.Expr_UnaryExpr{UnaryExpr: &pb.UnaryExpr{
+					Expr: Col("b"),
+				}}},
+			}}},
+			[]string{"update.table", "update.set[0].to", "update.where.unary_expr.op"},
+		},
+		{
+			"negative timeout",
+			&pb.Statement{
+				Statement: &pb.Statement_Delete{Delete: &pb.Delete{From: Table("t")}},
+				Timeout:   &duration.Duration{Seconds: -1},
+			},
+			[]string{"timeout"},
+		},
+		{
+			"nil delete table",
+			&pb.Statement{Statement: &pb.Statement_Delete{Delete: &pb.Delete{}}},
+			[]string{"delete.from"},
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			err := grpcdb.Validate(tt.statement)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			ve, ok := err.(*grpcdb.ValidationError)
+			if !ok {
+				t.Fatalf("Expected *grpcdb.ValidationError, got %T: %v", err, err)
+			}
+			var fields []string
+			for _, v := range ve.Violations {
+				fields = append(fields, v.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Expected violations: %v\nActual: %v", tt.fields, ve.Violations)
+			}
+		})
+	}
+}
+
+func mustStatement(t *testing.T, sb StatementBuilder) *pb.Statement {
+	t.Helper()
+	statement, err := sb.Statement()
+	if err != nil {
+		t.Fatalf("Couldn't build statement: %v", err)
+	}
+	return statement
+}